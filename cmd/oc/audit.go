@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/fzipp/oberon-compiler/files"
+	"github.com/fzipp/oberon-compiler/orp"
+	"github.com/fzipp/oberon-compiler/ors"
+)
+
+func auditUsage() {
+	fail(`
+Compiles modules without writing any files and lists the modules that
+import the unsafe pseudo-module SYSTEM, with the position of the import and
+of every use of a SYSTEM procedure or function (GET, PUT, COPY, VAL, ADR,
+...), followed by the number of uses of each of them.
+
+With -system only the given modules may import SYSTEM; the import by any
+other module is a compile error. The same option is accepted by the
+compiler.
+
+Modules are compiled in the given order, the symbol files of other
+imported modules are read from the current directory. The exit status is 1
+if any module has errors.
+
+The flags -x and -dialect select the language as for the compiler.
+
+Usage:
+    oc audit [-system modules] [-x extension]... [-dialect name] modfile...
+
+Examples:
+    oc audit *.Mod
+    oc audit -system Kernel,FileDir,Files,Modules *.Mod`)
+}
+
+func audit(args []string) {
+	flags := flag.NewFlagSet("audit", flag.ExitOnError)
+	var sysMods []ors.Ident
+	flags.Func("system", "allows only the given modules (comma-separated) to import SYSTEM", func(s string) error {
+		sysMods = moduleList(s)
+		return nil
+	})
+	var lang languageFlags
+	lang.register(flags)
+	flags.Usage = auditUsage
+	_ = flags.Parse(args)
+	if flags.NArg() < 1 {
+		auditUsage()
+	}
+
+	var log bytes.Buffer
+	var mods []*orp.Module
+	compiled := false
+	opts := orp.Options{
+		FS:            files.Overlay(files.OS),
+		SystemModules: sysMods,
+		Log:           &log,
+		Compiled: func(m *orp.Module) {
+			compiled = true
+			if m.SystemImport > 0 {
+				mods = append(mods, m)
+			}
+		},
+	}
+	lang.options(&opts)
+	failed := false
+	for _, arg := range flags.Args() {
+		log.Reset()
+		compiled = false
+		err := orp.CompileFileWith(arg, opts)
+		check(err)
+		if !compiled {
+			fmt.Print(log.String())
+			failed = true
+		}
+	}
+
+	var names []ors.Ident // in order of first use
+	count := make(map[ors.Ident]int)
+	for _, m := range mods {
+		fmt.Printf("%s  pos %d imports SYSTEM\n", m.Name, m.SystemImport)
+		for _, u := range m.SystemUses {
+			fmt.Printf("    pos %d SYSTEM.%s\n", u.Pos, u.Name)
+			if count[u.Name] == 0 {
+				names = append(names, u.Name)
+			}
+			count[u.Name]++
+		}
+	}
+	if len(mods) > 0 {
+		fmt.Printf("\n%d modules import SYSTEM\n", len(mods))
+		for _, name := range names {
+			fmt.Printf("    %-14s %d\n", "SYSTEM."+string(name), count[name])
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// moduleList returns the modules of a comma-separated list. The result is
+// not nil, even if the list is empty.
+func moduleList(s string) []ors.Ident {
+	mods := []ors.Ident{}
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			mods = append(mods, ors.Ident(name))
+		}
+	}
+	return mods
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"flag"
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/fzipp/oberon-compiler/files"
+	"github.com/fzipp/oberon-compiler/orb"
+	"github.com/fzipp/oberon-compiler/orp"
+)
+
+func docUsage() {
+	fail(`
+Generates documentation of the exported constants, types, variables and
+procedures of modules, from their source files (.Mod) or symbol files
+(.smb), as HTML (default) or Markdown pages, one per module, and an index
+page. The documentation of a declaration is the comment directly preceding
+it on a line of its own, that of a module the comment preceding or
+following its heading. Names of types declared in documented modules are
+linked to their declaration.
+
+Source files are compiled without writing any files, the symbol files of
+other imported modules are read from the current directory. Symbol files
+contain the comments of the exported declarations, but not the names of
+parameters.
+
+The flags -x and -dialect select the language as for the compiler.
+
+Usage:
+    oc doc [-md] [-o dir] [-x extension]... [-dialect name] file...
+
+Flags:
+    -md  Writes Markdown instead of HTML.
+    -o   Output directory (default ".").
+
+Examples:
+    oc doc -o doc *.Mod
+    oc doc -md -o doc Texts.smb Oberon.smb`)
+}
+
+// docModule is a module to be documented.
+type docModule struct {
+	name    string
+	doc     string
+	b       *orb.Base
+	symFile bool             // read from a symbol file
+	objs    []*orb.Object    // exported objects in order of declaration
+	imports map[int32]string // names of imported modules by module number
+}
+
+type docGen struct {
+	md   bool
+	mods map[string]*docModule
+	m    *docModule // module being written
+}
+
+func doc(args []string) {
+	flags := flag.NewFlagSet("doc", flag.ExitOnError)
+	md := flags.Bool("md", false, "writes Markdown instead of HTML")
+	outDir := flags.String("o", ".", "output directory")
+	var lang languageFlags
+	lang.register(flags)
+	flags.Usage = docUsage
+	_ = flags.Parse(args)
+	if flags.NArg() < 1 {
+		docUsage()
+	}
+
+	var mods []*docModule
+	var log bytes.Buffer
+	compiled := false
+	opts := orp.Options{
+		FS:  files.Overlay(files.OS),
+		Log: &log,
+		Compiled: func(m *orp.Module) {
+			compiled = true
+			dm := &docModule{
+				name:    string(m.Name),
+				doc:     m.Base.Doc,
+				b:       m.Base,
+				imports: importedModules(m.Base),
+			}
+			for obj := m.Base.TopScope.Next; obj != nil; obj = obj.Next {
+				if obj.Expo {
+					dm.objs = append(dm.objs, obj)
+				}
+			}
+			mods = append(mods, dm)
+		},
+	}
+	lang.options(&opts)
+	for _, arg := range flags.Args() {
+		if filepath.Ext(arg) == ".smb" {
+			mods = append(mods, readDocModule(arg))
+			continue
+		}
+		log.Reset()
+		compiled = false
+		err := orp.CompileFileWith(arg, opts)
+		check(err)
+		if !compiled {
+			fail(strings.TrimSpace(log.String()))
+		}
+	}
+
+	g := &docGen{md: *md, mods: make(map[string]*docModule)}
+	for _, m := range mods {
+		if g.mods[m.name] != nil {
+			fail("module " + m.name + " given more than once")
+		}
+		g.mods[m.name] = m
+	}
+	check(os.MkdirAll(*outDir, 0o755))
+	for _, m := range mods {
+		g.m = m
+		g.writeFile(filepath.Join(*outDir, m.name+g.ext()), g.module)
+	}
+	slices.SortFunc(mods, func(a, b *docModule) int { return cmp.Compare(a.name, b.name) })
+	g.writeFile(filepath.Join(*outDir, "index"+g.ext()), func(w *bufio.Writer) {
+		g.index(w, mods)
+	})
+}
+
+func readDocModule(path string) *docModule {
+	f, err := os.Open(path)
+	check(err)
+	defer f.Close()
+	b, mod, err := orb.ReadSymFile(f)
+	if err != nil {
+		fail(path + ": " + err.Error())
+	}
+	return &docModule{
+		name:    string(mod.OrgName),
+		doc:     mod.Doc,
+		b:       b,
+		symFile: true,
+		objs:    orb.Exports(mod),
+		imports: importedModules(b),
+	}
+}
+
+// importedModules returns the names of the modules imported into the open
+// scope of b. The scope of a compiled module is closed after
+// Options.Compiled, so the names have to be recorded before.
+func importedModules(b *orb.Base) map[int32]string {
+	imports := make(map[int32]string)
+	for mod := b.TopScope.Next; mod != nil; mod = mod.Next {
+		if mod.Class == orb.ClassMod {
+			imports[mod.Lev] = string(mod.OrgName)
+		}
+	}
+	return imports
+}
+
+func (g *docGen) ext() string {
+	if g.md {
+		return ".md"
+	}
+	return ".html"
+}
+
+func (g *docGen) writeFile(path string, write func(w *bufio.Writer)) {
+	f, err := os.Create(path)
+	check(err)
+	w := bufio.NewWriter(f)
+	write(w)
+	check(w.Flush())
+	check(f.Close())
+}
+
+func (g *docGen) module(w *bufio.Writer) {
+	m := g.m
+	if g.md {
+		fmt.Fprintf(w, "# MODULE %s\n\n", m.name)
+		if m.doc != "" {
+			fmt.Fprintf(w, "%s\n\n", m.doc)
+		}
+	} else {
+		fmt.Fprintf(w, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>MODULE %s</title>\n</head>\n<body>\n", m.name)
+		fmt.Fprintf(w, "<p><a href=\"index.html\">Index</a></p>\n<h1>MODULE %s</h1>\n", m.name)
+		g.text(w, m.doc)
+	}
+	sections := []struct {
+		title string
+		match func(obj *orb.Object) bool
+	}{
+		{"Constants", func(obj *orb.Object) bool { return obj.Class == orb.ClassConst && obj.Type.Form != orb.FormProc }},
+		{"Types", func(obj *orb.Object) bool { return obj.Class == orb.ClassTyp }},
+		{"Variables", func(obj *orb.Object) bool { return obj.Class == orb.ClassVar }},
+		{"Procedures", func(obj *orb.Object) bool { return obj.Class == orb.ClassConst && obj.Type.Form == orb.FormProc }},
+	}
+	for _, sec := range sections {
+		var objs []*orb.Object
+		for _, obj := range m.objs {
+			if sec.match(obj) {
+				objs = append(objs, obj)
+			}
+		}
+		if len(objs) == 0 {
+			continue
+		}
+		if g.md {
+			fmt.Fprintf(w, "## %s\n\n", sec.title)
+		} else {
+			fmt.Fprintf(w, "<h2>%s</h2>\n", sec.title)
+		}
+		for _, obj := range objs {
+			if g.md {
+				fmt.Fprintf(w, "<a id=\"%s\"></a>\n<pre>%s</pre>\n\n", obj.Name, g.decl(obj))
+				if obj.Doc != "" {
+					fmt.Fprintf(w, "%s\n\n", obj.Doc)
+				}
+			} else {
+				fmt.Fprintf(w, "<pre id=\"%s\">%s</pre>\n", obj.Name, g.decl(obj))
+				g.text(w, obj.Doc)
+			}
+		}
+	}
+	if !g.md {
+		fmt.Fprint(w, "</body>\n</html>\n")
+	}
+}
+
+func (g *docGen) index(w *bufio.Writer, mods []*docModule) {
+	if g.md {
+		fmt.Fprint(w, "# Modules\n\n")
+	} else {
+		fmt.Fprint(w, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>Modules</title>\n</head>\n<body>\n<h1>Modules</h1>\n<dl>\n")
+	}
+	for _, m := range mods {
+		summary, _, _ := strings.Cut(m.doc, "\n\n")
+		summary = strings.ReplaceAll(summary, "\n", " ")
+		if g.md {
+			fmt.Fprintf(w, "- [%s](%s.md)", m.name, m.name)
+			if summary != "" {
+				fmt.Fprintf(w, ": %s", summary)
+			}
+			fmt.Fprintln(w)
+		} else {
+			fmt.Fprintf(w, "<dt><a href=\"%s.html\">%s</a></dt><dd>%s</dd>\n", m.name, m.name, html.EscapeString(summary))
+		}
+	}
+	if !g.md {
+		fmt.Fprint(w, "</dl>\n</body>\n</html>\n")
+	}
+}
+
+// text writes a comment as HTML paragraphs.
+func (g *docGen) text(w *bufio.Writer, doc string) {
+	if doc == "" {
+		return
+	}
+	for _, par := range strings.Split(doc, "\n\n") {
+		fmt.Fprintf(w, "<p>%s</p>\n", html.EscapeString(par))
+	}
+}
+
+// decl returns the declaration of an exported object as HTML.
+func (g *docGen) decl(obj *orb.Object) string {
+	name := "<b>" + html.EscapeString(string(obj.Name)) + "</b>*"
+	switch obj.Class {
+	case orb.ClassConst:
+		if obj.Type.Form == orb.FormProc {
+			return "PROCEDURE " + name + g.signature(obj.Type)
+		}
+		return "CONST " + name + " = " + html.EscapeString(g.m.b.ConstString(obj))
+	case orb.ClassTyp:
+		if obj.Type.TypObj != obj {
+			return "TYPE " + name + " = " + g.ref(obj.Type) // alias
+		}
+		return "TYPE " + name + " = " + g.desc(obj.Type)
+	case orb.ClassVar:
+		return "VAR " + name + ": " + g.ref(obj.Type)
+	}
+	return name
+}
+
+// ref returns the name of a named type, linked to its declaration if it is
+// documented, or the description of an anonymous type.
+func (g *docGen) ref(t *orb.Type) string {
+	if t.TypObj == nil || t.TypObj.Type != t {
+		return g.desc(t)
+	}
+	name := html.EscapeString(string(t.TypObj.Name))
+	modName := g.typeModule(t)
+	if modName == "" || modName == g.m.name {
+		if slices.Contains(g.m.objs, t.TypObj) {
+			return fmt.Sprintf("<a href=\"#%s\">%s</a>", name, name)
+		}
+		return name // predeclared or not exported
+	}
+	qualName := html.EscapeString(modName) + "." + name
+	if m := g.mods[modName]; m != nil {
+		for _, obj := range m.objs {
+			if obj.Class == orb.ClassTyp && obj.Name == t.TypObj.Name {
+				return fmt.Sprintf("<a href=\"%s%s#%s\">%s</a>", modName, g.ext(), name, qualName)
+			}
+		}
+	}
+	return qualName
+}
+
+// typeModule returns the name of the module of an imported type, or "" if
+// the type is declared in the module being written or predeclared.
+func (g *docGen) typeModule(t *orb.Type) string {
+	if t.Mno > 0 {
+		return g.m.imports[t.Mno]
+	}
+	return ""
+}
+
+// desc returns the description of a type in Oberon syntax.
+func (g *docGen) desc(t *orb.Type) string {
+	switch t.Form {
+	case orb.FormPointer:
+		return "POINTER TO " + g.ref(t.Base)
+	case orb.FormProc:
+		return "PROCEDURE" + g.signature(t)
+	case orb.FormArray:
+		if t.Len < 0 {
+			return "ARRAY OF " + g.ref(t.Base)
+		}
+		return fmt.Sprintf("ARRAY %d OF %s", t.Len, g.ref(t.Base))
+	case orb.FormRecord:
+		var sb strings.Builder
+		sb.WriteString("RECORD")
+		if t.Base != nil {
+			sb.WriteString(" (" + g.ref(t.Base) + ")")
+		}
+		var bot *orb.Object
+		if t.Base != nil {
+			bot = t.Base.Dsc
+		}
+		var flds []*orb.Object
+		for fld := t.Dsc; fld != bot && fld != nil; fld = fld.Next {
+			if fld.Name != "" && (fld.Expo || g.m.symFile) {
+				flds = append(flds, fld)
+			}
+		}
+		slices.SortStableFunc(flds, func(a, b *orb.Object) int { return cmp.Compare(a.Val, b.Val) })
+		var items []string
+		for _, fld := range flds {
+			items = append(items, html.EscapeString(string(fld.Name))+"*: "+g.ref(fld.Type))
+		}
+		var ms []*orb.Object
+		for m := t.Methods; m != nil; m = m.Next {
+			if m.Expo {
+				ms = append(ms, m)
+			}
+		}
+		slices.SortFunc(ms, func(a, b *orb.Object) int { return cmp.Compare(a.Val, b.Val) })
+		for _, m := range ms {
+			items = append(items, "PROCEDURE ("+g.receiver(m.Type.Dsc)+") "+
+				html.EscapeString(string(m.Name))+"*"+g.signature(orb.MethodSignature(m)))
+		}
+		for i, item := range items {
+			sb.WriteString("\n    " + item)
+			if i < len(items)-1 {
+				sb.WriteString(";")
+			}
+		}
+		if len(items) > 0 {
+			sb.WriteString("\n ")
+		}
+		sb.WriteString(" END")
+		return sb.String()
+	}
+	return html.EscapeString(g.m.b.TypeString(t))
+}
+
+// receiver returns the receiver of a type-bound procedure.
+func (g *docGen) receiver(rcv *orb.Object) string {
+	s := ""
+	if rcv.Class == orb.ClassPar {
+		s = "VAR "
+	}
+	if rcv.Name != "" {
+		s += html.EscapeString(string(rcv.Name)) + ": "
+	}
+	return s + g.ref(rcv.Type)
+}
+
+// signature returns the formal parameters and the result type of
+// a procedure type. The names of the parameters are not known for
+// modules read from symbol files.
+func (g *docGen) signature(t *orb.Type) string {
+	var pars []string
+	par := t.Dsc
+	for i := int32(0); i < t.NOfPar && par != nil; i++ {
+		s := ""
+		if par.Class == orb.ClassPar && !par.Rdo {
+			s = "VAR "
+		}
+		if par.Name != "" {
+			s += html.EscapeString(string(par.Name)) + ": "
+		}
+		pars = append(pars, s+g.ref(par.Type))
+		par = par.Next
+	}
+	function := t.Base != nil && t.Base.Form != orb.FormNoTyp
+	sig := ""
+	if len(pars) > 0 || function {
+		sig = "(" + strings.Join(pars, "; ") + ")"
+	}
+	if function {
+		sig += ": " + g.ref(t.Base)
+	}
+	return sig
+}
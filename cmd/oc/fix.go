@@ -0,0 +1,1035 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/fzipp/oberon-compiler/ors"
+)
+
+func fixUsage() {
+	fail(`
+Converts modules written in earlier versions of Oberon, as accepted with
+-dialect oberon90, to Oberon-07:
+
+    LOOP and EXIT    WHILE loops with a flag, which EXIT sets; statements
+                     following a statement that may exit are enclosed in
+                     an IF statement that tests the flag
+    WITH             type CASE statements; WITH with ELSE is enclosed in
+                     an IF statement with type tests
+    RETURN           RETURN statements in the middle of a procedure assign
+                     the result to a variable and set a flag, a function
+                     returns the variable at its end
+    CASE             numeric CASE statements, which the compiler does not
+                     implement, IF statements with ELSIF
+    SHORTINT         INTEGER; LONG(x) and SHORT(x) become (x)
+
+The flags and result variables are declared as local variables, named
+exit, returned and result, or with a number appended if the names are
+used. The rest of the text, including comments and layout, is kept;
+statements moved into a new IF statement or ELSE branch are indented by
+another level. A WITH or numeric CASE statement without a matching branch
+and without ELSE does nothing instead of trapping.
+
+Constructs that cannot be converted automatically, such as EXIT or RETURN
+in a FOR statement and forward declarations, are reported in the same
+format as compile errors. The exit status is 1 if any are reported.
+
+Files in Oberon text format are written as plain text.
+
+Usage:
+    oc fix [-w] modfile...
+
+Flags:
+    -w  Writes the result to the source file instead of standard output.
+
+Examples:
+    oc fix Texts.Mod
+    oc fix -w *.Mod`)
+}
+
+func fix(args []string) {
+	flags := flag.NewFlagSet("fix", flag.ExitOnError)
+	write := flags.Bool("w", false, "writes the result to the source file")
+	flags.Usage = fixUsage
+	_ = flags.Parse(args)
+	if flags.NArg() < 1 {
+		fixUsage()
+	}
+
+	incomplete := false
+	for _, name := range flags.Args() {
+		in, err := os.Open(name)
+		check(err)
+		text, err := ors.ReadText(in)
+		in.Close()
+		check(err)
+		out, notes := fixText(text)
+		for _, n := range notes {
+			fmt.Fprintf(os.Stderr, "%s  pos %d %s\n", name, n.pos, n.msg)
+			incomplete = true
+		}
+		if !*write {
+			_, err = os.Stdout.Write(out)
+			check(err)
+		} else if !bytes.Equal(out, text) {
+			check(os.WriteFile(name, out, 0666))
+		}
+	}
+	if incomplete {
+		os.Exit(1)
+	}
+}
+
+// fixer converts the text of a module, see fixText.
+type fixer struct {
+	text   []byte
+	toks   []token
+	i      int             // current token of the parser
+	idents map[string]bool // identifiers in the text
+	nl     string          // line break of the text
+	unit   string          // indentation of one level
+
+	edits   []edit
+	regions int // number of statement sequences moved into IF or ELSE
+	notes   []note
+}
+
+// An edit replaces the text from pos to end. Insertions (pos = end) at the
+// same position are ordered by prio.
+type edit struct {
+	pos, end int
+	text     string
+	prio     int
+}
+
+// A note reports a construct that was not converted.
+type note struct {
+	pos int
+	msg string
+}
+
+// Orders of insertions at the same position
+const (
+	prioIndent = math.MinInt // indentation at the start of a line
+	prioFlag   = prioIndent + 1
+	prioResult = math.MaxInt // final RETURN of a function
+)
+
+// fixText converts the text of a module from earlier versions of Oberon to
+// Oberon-07 and returns it with notes on the constructs that were not
+// converted. If the module cannot be parsed, the text is not changed.
+func fixText(text []byte) (out []byte, notes []note) {
+	f := &fixer{
+		text:   text,
+		idents: make(map[string]bool),
+		nl:     lineBreak(text),
+		unit:   indentUnit(text),
+	}
+	if err := f.tokenize(); err != nil {
+		return text, []note{{0, err.Error()}}
+	}
+	m, err := f.parse()
+	if err != nil {
+		return text, append(f.notes, *err)
+	}
+	f.fixProc(m)
+	f.fixNames(m)
+	slices.SortStableFunc(f.notes, func(a, b note) int {
+		return cmp.Compare(a.pos, b.pos)
+	})
+	return f.apply(), f.notes
+}
+
+func (f *fixer) parse() (m *proc, err *note) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			e, ok := rec.(syntaxError)
+			if !ok {
+				panic(rec)
+			}
+			err = &note{e.pos, "cannot parse: " + e.msg}
+		}
+	}()
+	return f.module(), nil
+}
+
+func (f *fixer) note(pos int, msg string) {
+	f.notes = append(f.notes, note{pos, msg})
+}
+
+func (f *fixer) replace(pos, end int, text string) {
+	f.edits = append(f.edits, edit{pos, end, text, 0})
+}
+
+func (f *fixer) insert(pos int, text string, prio int) {
+	f.edits = append(f.edits, edit{pos, pos, text, prio})
+}
+
+// apply returns the text with the edits. Edits within text replaced by an
+// earlier edit are dropped.
+func (f *fixer) apply() []byte {
+	slices.SortStableFunc(f.edits, func(a, b edit) int {
+		if c := cmp.Compare(a.pos, b.pos); c != 0 {
+			return c
+		}
+		if ai, bi := a.pos == a.end, b.pos == b.end; ai != bi {
+			if ai {
+				return -1
+			}
+			return 1
+		}
+		return cmp.Compare(a.prio, b.prio)
+	})
+	var out bytes.Buffer
+	cur := 0
+	for _, e := range f.edits {
+		if e.pos < cur {
+			continue
+		}
+		out.Write(f.text[cur:e.pos])
+		out.WriteString(e.text)
+		cur = e.end
+	}
+	out.Write(f.text[cur:])
+	return out.Bytes()
+}
+
+// Layout
+
+// lineBreak returns the line break used in text: CR (Oberon), CR LF or LF.
+func lineBreak(text []byte) string {
+	if i := bytes.IndexAny(text, "\r\n"); i >= 0 && text[i] == '\r' {
+		if i+1 < len(text) && text[i+1] == '\n' {
+			return "\r\n"
+		}
+		return "\r"
+	}
+	return "\n"
+}
+
+// indentUnit returns a tab if the first indented line of text is indented
+// with a tab, otherwise two blanks.
+func indentUnit(text []byte) string {
+	for i := 0; i < len(text)-1; i++ {
+		if text[i] == '\r' || text[i] == '\n' {
+			switch text[i+1] {
+			case '\t':
+				return "\t"
+			case ' ':
+				return "  "
+			}
+		}
+	}
+	return "  "
+}
+
+func isBlank(ch byte) bool {
+	return ch == ' ' || ch == '\t'
+}
+
+func isLineBreak(ch byte) bool {
+	return ch == '\r' || ch == '\n'
+}
+
+// lineStart returns the position of the first character of the line
+// containing pos.
+func (f *fixer) lineStart(pos int) int {
+	for pos > 0 && !isLineBreak(f.text[pos-1]) {
+		pos--
+	}
+	return pos
+}
+
+// startsLine reports whether pos is preceded by blanks only on its line.
+func (f *fixer) startsLine(pos int) bool {
+	for i := f.lineStart(pos); i < pos; i++ {
+		if !isBlank(f.text[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// indentAt returns the indentation of the line containing pos, with level
+// more levels.
+func (f *fixer) indentAt(pos, level int) string {
+	i := f.lineStart(pos)
+	j := i
+	for j < len(f.text) && isBlank(f.text[j]) {
+		j++
+	}
+	return string(f.text[i:j]) + strings.Repeat(f.unit, level)
+}
+
+// reindent indents the lines that start between from and to by another
+// level, except blank lines.
+func (f *fixer) reindent(from, to int) {
+	for i := from; i < to-1; i++ {
+		if isLineBreak(f.text[i]) && !(f.text[i] == '\r' && f.text[i+1] == '\n') {
+			j := i + 1
+			for j < len(f.text) && isBlank(f.text[j]) {
+				j++
+			}
+			if j < len(f.text) && !isLineBreak(f.text[j]) {
+				f.insert(i+1, f.unit, prioIndent)
+			}
+		}
+	}
+}
+
+// appendPos returns the position for text appended to the statement that
+// ends with token i: the end of the line if only comments follow on it,
+// otherwise the end of the token.
+func (f *fixer) appendPos(i int) int {
+	end := f.toks[i].end
+	pos := end
+	for {
+		for pos < len(f.text) && isBlank(f.text[pos]) {
+			pos++
+		}
+		if pos == len(f.text) || isLineBreak(f.text[pos]) {
+			return pos
+		}
+		if !bytes.HasPrefix(f.text[pos:], []byte("(*")) {
+			return end
+		}
+		depth := 0
+		for pos < len(f.text) {
+			if bytes.HasPrefix(f.text[pos:], []byte("(*")) {
+				depth++
+				pos += 2
+			} else if bytes.HasPrefix(f.text[pos:], []byte("*)")) {
+				depth--
+				pos += 2
+				if depth == 0 {
+					break
+				}
+			} else if isLineBreak(f.text[pos]) {
+				return end
+			} else {
+				pos++
+			}
+		}
+	}
+}
+
+// Conversion of statements
+
+// A conversion converts the statements of a procedure or module body.
+type conversion struct {
+	f         *fixer
+	p         *proc
+	ret       *stmt            // target of RETURN statements, nil if not converted
+	retFlag   bool             // RETURN statements set a flag
+	loops     map[*stmt]bool   // converted LOOP statements
+	flags     map[*stmt]string // names of the flags of LOOPs and ret
+	loopFlags []string         // names of the flags of LOOPs by nesting level
+	depth     int              // nesting level of converted LOOPs
+	names     map[string]bool  // names of the variables added
+	bools     []string         // flags to be declared
+	result    string           // result variable to be declared
+	starts    map[int]bool     // positions of moved statement sequences
+}
+
+// fixProc converts the body of p and of its procedures.
+func (f *fixer) fixProc(p *proc) {
+	for _, q := range p.procs {
+		f.fixProc(q)
+	}
+	c := &conversion{
+		f:      f,
+		p:      p,
+		loops:  make(map[*stmt]bool),
+		flags:  make(map[*stmt]string),
+		names:  make(map[string]bool),
+		starts: make(map[int]bool),
+	}
+	c.run()
+}
+
+func (c *conversion) run() {
+	f, p := c.f, c.p
+	function := p.result.a < p.result.b
+	if n := len(p.body); !p.module && !function && n > 0 && p.body[n-1].kind == ors.SymReturn {
+		// final RETURN of a proper procedure
+		r := p.body[n-1]
+		c.deleteReturn(r)
+		p.body = p.body[:n-1]
+		p.returns = slices.DeleteFunc(p.returns, func(st *stmt) bool { return st == r })
+	}
+	visit(p.body, nil, func(st *stmt, outer []*stmt) {
+		if st.kind != ors.SymLoop {
+			return
+		}
+		inFor := false
+		visit(st.branches[0].seq, nil, func(s *stmt, outer []*stmt) {
+			if s.kind == ors.SymExit && s.target == st && slices.ContainsFunc(outer, isFor) {
+				inFor = true
+			}
+		})
+		if inFor {
+			f.note(f.toks[st.first].pos, "LOOP not converted: EXIT in FOR statement")
+		} else {
+			c.loops[st] = true
+		}
+	})
+	if p.module {
+		for _, r := range p.returns {
+			f.note(f.toks[r.first].pos, "RETURN in module body")
+		}
+	} else if c.convertReturns() {
+		c.ret = &stmt{kind: ors.SymProcedure}
+		for _, r := range p.returns {
+			r.target = c.ret
+		}
+		c.retFlag = c.needsFlag(p.body, c.ret)
+	}
+	c.seq(p.body, 0)
+	c.returns()
+	c.declare()
+}
+
+// convertReturns reports whether the RETURN statements of the procedure
+// must and can be converted.
+func (c *conversion) convertReturns() bool {
+	f, p := c.f, c.p
+	n := len(p.returns)
+	if n > 0 && p.returns[n-1] == p.body[len(p.body)-1] {
+		n-- // final RETURN of a function
+	}
+	if n == 0 {
+		return false
+	}
+	msg := ""
+	visit(p.body, nil, func(st *stmt, outer []*stmt) {
+		if st.kind != ors.SymReturn {
+			return
+		}
+		if slices.ContainsFunc(outer, isFor) {
+			msg = "RETURN in FOR statement"
+		} else if slices.ContainsFunc(outer, func(s *stmt) bool {
+			return s.kind == ors.SymLoop && !c.loops[s]
+		}) {
+			msg = "RETURN in LOOP that is not converted"
+		}
+	})
+	if msg != "" {
+		f.note(p.pos, "RETURN statements of "+p.name+" not converted: "+msg)
+		return false
+	}
+	return true
+}
+
+func isFor(st *stmt) bool {
+	return st.kind == ors.SymFor
+}
+
+// visit calls fn for the statements of seq and the statements nested in
+// them, with the enclosing statements.
+func visit(seq []*stmt, outer []*stmt, fn func(st *stmt, outer []*stmt)) {
+	for _, st := range seq {
+		fn(st, outer)
+		for _, b := range st.allBranches() {
+			visit(b.seq, append(outer, st), fn)
+		}
+	}
+}
+
+// allBranches returns the branches of st with the ELSE branch.
+func (st *stmt) allBranches() []*branch {
+	if st.els != nil {
+		return append(st.branches[:len(st.branches):len(st.branches)], st.els)
+	}
+	return st.branches
+}
+
+// exits returns the converted LOOPs and ret that st may leave.
+func (c *conversion) exits(st *stmt) []*stmt {
+	if st.exited {
+		return st.exits
+	}
+	st.exited = true
+	switch st.kind {
+	case ors.SymExit, ors.SymReturn:
+		if t := st.target; t != nil && (t == c.ret || c.loops[t]) {
+			st.exits = []*stmt{t}
+		}
+	default:
+		for _, b := range st.allBranches() {
+			for _, t := range c.seqExits(b.seq) {
+				if t != st && !slices.Contains(st.exits, t) {
+					st.exits = append(st.exits, t)
+				}
+			}
+		}
+	}
+	return st.exits
+}
+
+func (c *conversion) seqExits(seq []*stmt) (exits []*stmt) {
+	for _, st := range seq {
+		for _, t := range c.exits(st) {
+			if !slices.Contains(exits, t) {
+				exits = append(exits, t)
+			}
+		}
+	}
+	return exits
+}
+
+// alwaysExits reports whether seq ends with an EXIT or RETURN that is
+// converted, or an IF statement whose branches all do.
+func (c *conversion) alwaysExits(seq []*stmt) bool {
+	if len(seq) == 0 {
+		return false
+	}
+	st := seq[len(seq)-1]
+	switch st.kind {
+	case ors.SymExit, ors.SymReturn:
+		return len(c.exits(st)) > 0
+	case ors.SymIf:
+		if st.els == nil {
+			return false
+		}
+		for _, b := range st.allBranches() {
+			if !c.alwaysExits(b.seq) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// elseable reports whether the statements following st can be moved into
+// a new ELSE branch of st: st is an IF statement without ELSE, whose
+// branches either always exit or never.
+func (c *conversion) elseable(st *stmt) bool {
+	if st.kind != ors.SymIf || st.els != nil {
+		return false
+	}
+	for _, b := range st.branches {
+		if !c.alwaysExits(b.seq) && len(c.seqExits(b.seq)) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// needsFlag reports whether a loop condition or an IF statement enclosing
+// the statements that follow a statement tests that the statements of seq
+// left target t.
+func (c *conversion) needsFlag(seq []*stmt, t *stmt) bool {
+	for i, st := range seq {
+		if slices.Contains(c.exits(st), t) {
+			switch st.kind {
+			case ors.SymWhile, ors.SymRepeat, ors.SymLoop:
+				return true
+			}
+			if i < len(seq)-1 && !c.elseable(st) {
+				return true
+			}
+		}
+		for _, b := range st.allBranches() {
+			if c.needsFlag(b.seq, t) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// fresh returns a name for a variable, base with a number appended if
+// base is used.
+func (c *conversion) fresh(base string) string {
+	name := base
+	for n := 1; c.f.idents[name] || c.names[name]; n++ {
+		name = base + strconv.Itoa(n)
+	}
+	c.names[name] = true
+	return name
+}
+
+// flag returns the name of the flag of target t.
+func (c *conversion) flag(t *stmt) string {
+	name, ok := c.flags[t]
+	if !ok {
+		name = c.fresh("returned")
+		c.bools = append(c.bools, name)
+		c.flags[t] = name
+	}
+	return name
+}
+
+// notExited returns the condition that none of the targets was left.
+func (c *conversion) notExited(targets []*stmt) string {
+	conds := make([]string, len(targets))
+	for i, t := range targets {
+		conds[i] = "~" + c.flag(t)
+	}
+	return strings.Join(conds, " & ")
+}
+
+// seq converts the statements of seq, whose lines are indented by level
+// more levels.
+func (c *conversion) seq(seq []*stmt, level int) {
+	for i, st := range seq {
+		c.stmt(st, level)
+		if len(c.exits(st)) > 0 && i < len(seq)-1 {
+			rest := seq[i+1:]
+			if c.elseable(st) {
+				level = c.moveToElse(st, rest, level)
+			} else {
+				level = c.guard(st, rest, level)
+			}
+			c.seq(rest, level)
+			return
+		}
+	}
+}
+
+// guard encloses the statements rest following st, which may exit, in an
+// IF statement that tests that st did not, and returns their new level.
+func (c *conversion) guard(st *stmt, rest []*stmt, level int) int {
+	f := c.f
+	pos := f.toks[rest[0].first].pos
+	end := f.appendPos(rest[len(rest)-1].last)
+	cond := c.notExited(c.exits(st))
+	c.starts[pos] = true
+	f.regions++
+	if f.startsLine(pos) {
+		ind := f.indentAt(pos, level)
+		f.insert(pos, "IF "+cond+" THEN"+f.nl+ind+f.unit, 0)
+		f.reindent(pos, end)
+		f.insert(end, f.nl+ind+"END", -f.regions)
+		return level + 1
+	}
+	f.insert(pos, "IF "+cond+" THEN ", 0)
+	f.insert(end, " END", -f.regions)
+	return level
+}
+
+// moveToElse moves the statements rest following the IF statement st into
+// a new ELSE branch of st and returns their new level. If st is
+// IF c THEN RETURN END in a proper procedure, it becomes IF ~c THEN rest
+// END instead.
+func (c *conversion) moveToElse(st *stmt, rest []*stmt, level int) int {
+	f := c.f
+	endTok, semi := f.toks[st.last], f.toks[st.last+1]
+	from := semi.end
+	pos := f.toks[rest[0].first].pos
+	end := f.appendPos(rest[len(rest)-1].last)
+	if b := st.branches[0]; c.ret != nil && !c.retFlag && c.p.result.a == c.p.result.b &&
+		len(st.branches) == 1 && len(b.seq) == 1 && b.seq[0].kind == ors.SymReturn {
+		c.negate(b.cond)
+		f.replace(f.toks[b.body].end, semi.end, "")
+		b.seq[0].removed = true
+	} else if semi.sym != ors.SymSemicolon {
+		// IF c THEN RETURN x END RETURN y
+		f.replace(endTok.pos, endTok.end, "ELSE")
+		rest[0].noSep = false
+		from = endTok.end
+	} else {
+		f.replace(endTok.pos, endTok.end, "ELSE")
+		if len(bytes.TrimSpace(f.text[endTok.end:semi.pos])) == 0 {
+			f.replace(endTok.end, semi.end, "")
+		} else {
+			f.replace(semi.pos, semi.end, "")
+		}
+	}
+	f.regions++
+	if f.startsLine(pos) {
+		ind := f.indentAt(f.toks[st.first].pos, level)
+		f.reindent(from, end)
+		f.insert(end, f.nl+ind+"END", -f.regions)
+		return level + 1
+	}
+	f.insert(end, " END", -f.regions)
+	return level
+}
+
+// negate negates the condition s.
+func (c *conversion) negate(s span) {
+	f := c.f
+	rel, nRel, other := 0, 0, false
+	f.topLevel(s, func(i int) {
+		switch f.toks[i].sym {
+		case ors.SymEql, ors.SymNeq, ors.SymLss, ors.SymLeq, ors.SymGtr, ors.SymGeq:
+			rel = i
+			nRel++
+		case ors.SymAnd, ors.SymOr, ors.SymNot, ors.SymIn, ors.SymIs:
+			other = true
+		}
+	})
+	first, last := f.toks[s.a], f.toks[s.b-1]
+	switch {
+	case nRel == 1 && !other:
+		opposite := map[ors.Sym]string{
+			ors.SymEql: "#", ors.SymNeq: "=",
+			ors.SymLss: ">=", ors.SymGeq: "<",
+			ors.SymLeq: ">", ors.SymGtr: "<=",
+		}
+		f.replace(f.toks[rel].pos, f.toks[rel].end, opposite[f.toks[rel].sym])
+	case f.isDesignator(s):
+		f.insert(first.pos, "~", 0)
+	case first.sym == ors.SymNot && f.isDesignator(span{s.a + 1, s.b}):
+		f.replace(first.pos, f.toks[s.a+1].pos, "")
+	default:
+		f.insert(first.pos, "~(", 0)
+		f.insert(last.end, ")", 0)
+	}
+}
+
+// extendCond prefixes the condition s with the condition cond, joined by
+// op.
+func (c *conversion) extendCond(s span, cond, op string) {
+	f := c.f
+	if f.isDesignator(s) {
+		f.insert(f.toks[s.a].pos, cond+" "+op+" ", 0)
+	} else {
+		f.insert(f.toks[s.a].pos, cond+" "+op+" (", 0)
+		f.insert(f.toks[s.b-1].end, ")", 0)
+	}
+}
+
+// stmt converts st, whose lines are indented by level more levels.
+func (c *conversion) stmt(st *stmt, level int) {
+	f := c.f
+	tok := f.toks[st.first]
+	switch st.kind {
+	case ors.SymWhile:
+		if exits := c.exits(st); len(exits) > 0 {
+			for _, b := range st.branches {
+				c.extendCond(b.cond, c.notExited(exits), "&")
+			}
+		}
+	case ors.SymRepeat:
+		if exits := c.exits(st); len(exits) > 0 {
+			flags := make([]string, len(exits))
+			for i, t := range exits {
+				flags[i] = c.flag(t)
+			}
+			c.extendCond(st.expr, strings.Join(flags, " OR "), "OR")
+		}
+	case ors.SymCase:
+		c.caseStmt(st)
+	case ors.SymWith:
+		c.withStmt(st)
+	case ors.SymLoop:
+		if c.loops[st] {
+			if c.depth == len(c.loopFlags) {
+				name := c.fresh("exit")
+				c.loopFlags = append(c.loopFlags, name)
+				c.bools = append(c.bools, name)
+			}
+			flag := c.loopFlags[c.depth]
+			c.flags[st] = flag
+			cond := "~" + flag
+			if exits := c.exits(st); len(exits) > 0 {
+				cond += " & " + c.notExited(exits)
+			}
+			sep := " "
+			if f.startsLine(tok.pos) {
+				sep = f.nl + f.indentAt(tok.pos, level)
+			}
+			f.replace(tok.pos, tok.end, flag+" := FALSE;"+sep+"WHILE "+cond+" DO")
+			c.depth++
+			c.seq(st.branches[0].seq, level)
+			c.depth--
+			return
+		}
+	case ors.SymExit:
+		if c.loops[st.target] {
+			f.replace(tok.pos, tok.end, c.flag(st.target)+" := TRUE")
+		}
+	}
+	for _, b := range st.allBranches() {
+		c.seq(b.seq, level)
+	}
+}
+
+// withStmt converts WITH v: T DO S | v: U DO S2 END to a type CASE
+// statement, CASE v OF T: S | U: S2 END. With ELSE, it is enclosed in
+// IF (v IS T) OR (v IS U) THEN ... END ELSE S3 END.
+func (c *conversion) withStmt(st *stmt) {
+	f := c.f
+	v := f.spanText(st.branches[0].cond)
+	for _, b := range st.branches {
+		if f.spanText(b.cond) != v {
+			f.note(f.toks[st.first].pos, "WITH not converted: different variables")
+			return
+		}
+	}
+	head := "CASE"
+	if st.els != nil {
+		tests := make([]string, len(st.branches))
+		for i, b := range st.branches {
+			tests[i] = v + " IS " + f.spanText(b.typ)
+			if len(st.branches) > 1 {
+				tests[i] = "(" + tests[i] + ")"
+			}
+		}
+		head = "IF " + strings.Join(tests, " OR ") + " THEN CASE"
+		f.replace(f.toks[st.els.head].pos, f.toks[st.els.head].end, "END ELSE")
+	}
+	for i, b := range st.branches {
+		do := f.toks[b.body]
+		if i == 0 {
+			f.replace(f.toks[b.head].pos, f.toks[b.head].end, head)
+			f.replace(f.toks[b.cond.b-1].end, do.end, " OF "+f.spanText(b.typ)+":")
+		} else {
+			f.replace(f.toks[b.cond.a].pos, do.end, f.spanText(b.typ)+":")
+		}
+	}
+}
+
+// caseStmt converts a numeric CASE statement to an IF statement with an
+// ELSIF for each case.
+func (c *conversion) caseStmt(st *stmt) {
+	f := c.f
+	if c.typeCase(st) {
+		return
+	}
+	pos := f.toks[st.first].pos
+	var call string
+	f.topLevel(st.expr, func(i int) {
+		if f.toks[i].sym == ors.SymLparen && i > st.expr.a && f.toks[i-1].sym == ors.SymIdent {
+			switch name := f.str(i - 1); name {
+			case "ABS", "ASH", "CAP", "CHR", "LEN", "LONG", "ODD", "ORD", "SHORT":
+			default:
+				call = name
+			}
+		}
+	})
+	if call != "" {
+		f.note(pos, "CASE not converted: "+call+" would be called for each case")
+		return
+	}
+	x := f.spanText(st.expr)
+	first := true
+	for _, b := range st.branches {
+		if b.cond.a == b.cond.b {
+			// empty case
+			if b.head >= 0 && !first {
+				f.deleteToken(b.head)
+			}
+			continue
+		}
+		var labels []string
+		a := b.cond.a
+		f.topLevel(b.cond, func(i int) {
+			if f.toks[i].sym == ors.SymComma {
+				labels = append(labels, c.label(x, span{a, i}))
+				a = i + 1
+			}
+		})
+		labels = append(labels, c.label(x, span{a, b.cond.b}))
+		if len(labels) > 1 {
+			for i, l := range labels {
+				labels[i] = "(" + l + ")"
+			}
+		}
+		cond := strings.Join(labels, " OR ")
+		colon := f.toks[b.body]
+		if first {
+			f.replace(pos, colon.end, "IF "+cond+" THEN")
+			first = false
+		} else {
+			f.replace(f.toks[b.head].pos, colon.end, "ELSIF "+cond+" THEN")
+		}
+	}
+	if first {
+		f.note(pos, "CASE not converted: no cases")
+	}
+}
+
+// deleteToken deletes token i, with its line if nothing else is on it.
+func (f *fixer) deleteToken(i int) {
+	pos, end := f.toks[i].pos, f.toks[i].end
+	if f.startsLine(pos) {
+		j := end
+		for j < len(f.text) && isBlank(f.text[j]) {
+			j++
+		}
+		if j < len(f.text) && isLineBreak(f.text[j]) {
+			pos = f.lineStart(pos)
+			end = j + len(f.nl)
+		}
+	}
+	f.replace(pos, end, "")
+}
+
+// label returns the test of x for the case label s.
+func (c *conversion) label(x string, s span) string {
+	f := c.f
+	for i := s.a; i < s.b; i++ {
+		if f.toks[i].sym == ors.SymUpto {
+			return "(" + x + " >= " + f.spanText(span{s.a, i}) + ") & (" +
+				x + " <= " + f.spanText(span{i + 1, s.b}) + ")"
+		}
+	}
+	return x + " = " + f.spanText(s)
+}
+
+// typeCase reports whether st is a type CASE statement of Oberon-07: the
+// expression is a variable that is not declared with a basic type, and
+// the labels are names that are not declared as constants.
+func (c *conversion) typeCase(st *stmt) bool {
+	f := c.f
+	e := st.expr
+	if e.b-e.a != 1 || f.toks[e.a].sym != ors.SymIdent {
+		return e.b-e.a == 3 && f.isDesignator(e) && f.toks[e.a+1].sym == ors.SymPeriod &&
+			c.namesOnly(st)
+	}
+	switch c.p.scope.lookup(f.str(e.a)) {
+	case "INTEGER", "LONGINT", "SHORTINT", "BYTE", "CHAR":
+		return false
+	}
+	return c.namesOnly(st)
+}
+
+// namesOnly reports whether the case labels of st are names, not declared
+// as constants.
+func (c *conversion) namesOnly(st *stmt) bool {
+	f := c.f
+	for _, b := range st.branches {
+		s := b.cond
+		switch {
+		case s.a == s.b:
+		case s.b-s.a == 1 && f.toks[s.a].sym == ors.SymIdent:
+			if c.p.scope.lookup(f.str(s.a)) == "CONST" {
+				return false
+			}
+		case s.b-s.a == 3 && f.toks[s.a].sym == ors.SymIdent && f.toks[s.a+1].sym == ors.SymPeriod:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// returns converts the RETURN statements of a procedure: in a function,
+// RETURN x becomes result := x, and the function returns the result at
+// its end. With a flag, RETURN sets it.
+func (c *conversion) returns() {
+	f, p := c.f, c.p
+	if c.ret == nil {
+		return
+	}
+	function := p.result.a < p.result.b
+	if function {
+		c.result = c.fresh("result")
+	}
+	first, last := f.toks[p.body[0].first], p.body[len(p.body)-1]
+	if c.retFlag {
+		sep := " "
+		if f.startsLine(first.pos) {
+			sep = f.nl + f.indentAt(first.pos, 0)
+		}
+		f.insert(first.pos, c.flag(c.ret)+" := FALSE;"+sep, 0)
+	}
+	for _, r := range p.returns {
+		tok := f.toks[r.first]
+		if r.removed {
+			continue
+		}
+		if r.noSep {
+			f.insert(f.toks[r.first-1].end, ";", 0)
+		}
+		switch {
+		case function:
+			f.replace(tok.pos, tok.end, c.result+" :=")
+			if c.retFlag && r != last {
+				f.insert(f.toks[r.last].end, "; "+c.flag(c.ret)+" := TRUE", prioFlag)
+			}
+		case c.retFlag:
+			f.replace(tok.pos, tok.end, c.flag(c.ret)+" := TRUE")
+		default:
+			c.deleteReturn(r)
+		}
+	}
+	if function {
+		sep, pos := " ", f.toks[last.first].pos
+		if f.startsLine(pos) {
+			sep = f.nl + f.indentAt(pos, 0)
+		}
+		f.insert(f.appendPos(last.last), ";"+sep+"RETURN "+c.result, prioResult)
+	}
+}
+
+// deleteReturn deletes the RETURN statement r of a proper procedure, with
+// the preceding semicolon.
+func (c *conversion) deleteReturn(r *stmt) {
+	f := c.f
+	tok := f.toks[r.first]
+	pos := tok.pos
+	if prev := f.toks[r.first-1]; prev.sym == ors.SymSemicolon && !c.starts[tok.pos] {
+		pos = prev.pos
+	} else {
+		for pos > 0 && (isBlank(f.text[pos-1]) || isLineBreak(f.text[pos-1])) {
+			pos--
+		}
+	}
+	f.replace(pos, tok.end, "")
+}
+
+// declare declares the flags and the result variable of the procedure.
+func (c *conversion) declare() {
+	f, p := c.f, c.p
+	var decls []string
+	if len(c.bools) > 0 {
+		decls = append(decls, strings.Join(c.bools, ", ")+": BOOLEAN;")
+	}
+	if c.result != "" {
+		decls = append(decls, c.result+": "+f.spanText(p.result)+";")
+	}
+	if len(decls) == 0 {
+		return
+	}
+	text := strings.Join(decls, " ")
+	if p.varEnd >= 0 {
+		f.insert(f.toks[p.varEnd].end, " "+text, 0)
+	} else if pos := f.toks[p.declEnd].pos; f.startsLine(pos) {
+		f.insert(pos, "VAR "+text+f.nl+f.indentAt(pos, 0), 0)
+	} else {
+		f.insert(pos, "VAR "+text+" ", 0)
+	}
+}
+
+// fixNames replaces SHORTINT by INTEGER and removes LONG and SHORT from
+// LONG(x) and SHORT(x), unless the names are declared in the module.
+func (f *fixer) fixNames(m *proc) {
+	for i, t := range f.toks {
+		if t.sym != ors.SymIdent || (i > 0 && f.toks[i-1].sym == ors.SymPeriod) {
+			continue
+		}
+		switch name := f.str(i); name {
+		case "SHORTINT":
+			if !m.declares(name) {
+				f.replace(t.pos, t.end, "INTEGER")
+			}
+		case "LONG", "SHORT":
+			if f.toks[i+1].sym == ors.SymLparen && !m.declares(name) {
+				f.replace(t.pos, t.end, "")
+			}
+		}
+	}
+}
+
+// declares reports whether name is declared in p or its procedures.
+func (p *proc) declares(name string) bool {
+	if _, ok := p.scope.decls[name]; ok {
+		return true
+	}
+	return slices.ContainsFunc(p.procs, func(q *proc) bool {
+		return q.declares(name)
+	})
+}
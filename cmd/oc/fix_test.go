@@ -0,0 +1,162 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/fzipp/oberon-compiler/files"
+	"github.com/fzipp/oberon-compiler/orp"
+)
+
+// compiles reports whether src compiles without errors in the dialect.
+func compiles(t *testing.T, src, dialect string) bool {
+	t.Helper()
+	var log strings.Builder
+	ok := false
+	opts := orp.Options{
+		FS:       files.Overlay(files.OS),
+		Dialect:  dialect,
+		Log:      &log,
+		Compiled: func(*orp.Module) { ok = true },
+	}
+	if err := orp.CompileWith(strings.NewReader(src), opts); err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Logf("%s:\n%s", dialect, log.String())
+	}
+	return ok
+}
+
+func TestFixCompiles(t *testing.T) {
+	tests := []struct {
+		name     string
+		oberon90 bool // the source compiles with -dialect oberon90
+		src      string
+	}{
+		{"loop", true, `MODULE M;
+VAR n: INTEGER;
+
+PROCEDURE Count(n: INTEGER): INTEGER;
+  VAR i: INTEGER;
+BEGIN i := 0;
+  LOOP
+    IF i >= n THEN EXIT END;
+    INC(i);
+    IF i = 5 THEN EXIT END;
+    INC(i)
+  END;
+  RETURN i
+END Count;
+
+BEGIN n := Count(10)
+END M.`},
+		{"nested loops", true, `MODULE M;
+VAR i, j, k: INTEGER;
+BEGIN i := 0; k := 0;
+  LOOP
+    j := 0;
+    LOOP
+      IF j = i THEN EXIT END;
+      INC(j); INC(k)
+    END;
+    IF i = 10 THEN EXIT END;
+    INC(i)
+  END
+END M.`},
+		{"with", true, `MODULE M;
+TYPE
+  Node = POINTER TO NodeDesc;
+  NodeDesc = RECORD next: Node END;
+  Item = POINTER TO ItemDesc;
+  ItemDesc = RECORD (NodeDesc) key: INTEGER END;
+  Text = POINTER TO TextDesc;
+  TextDesc = RECORD (NodeDesc) len: INTEGER END;
+
+PROCEDURE Find(list: Node; key: INTEGER): Node;
+BEGIN
+  LOOP
+    IF list = NIL THEN RETURN NIL END;
+    WITH list: Item DO
+      IF list.key = key THEN EXIT END
+    ELSE
+    END;
+    list := list.next
+  END;
+  RETURN list
+END Find;
+
+PROCEDURE Size(n: Node): INTEGER;
+  VAR s: INTEGER;
+BEGIN s := 0;
+  WITH n: Item DO s := 4
+  | n: Text DO s := n.len
+  END;
+  RETURN s
+END Size;
+
+END M.`},
+		{"return", true, `MODULE M;
+PROCEDURE Sign(x: INTEGER): INTEGER;
+BEGIN
+  IF x < 0 THEN RETURN -1 END;
+  IF x = 0 THEN RETURN 0 END;
+  x := 1;
+  RETURN x
+END Sign;
+
+PROCEDURE Skip(VAR x: INTEGER);
+BEGIN
+  IF x = 0 THEN RETURN END;
+  x := x * 2
+END Skip;
+
+END M.`},
+		{"case and shortint", false, `MODULE M;
+VAR s: SHORTINT; l: LONGINT; r: REAL;
+
+PROCEDURE Name(i: INTEGER): CHAR;
+  VAR c: CHAR;
+BEGIN
+  CASE i OF
+    0, 2: c := "a"
+  | 1, 3..5: c := "b"
+  ELSE c := "z"
+  END;
+  RETURN c
+END Name;
+
+BEGIN s := 3; l := LONG(s) + 1; s := SHORT(l); r := LONG(1.5)
+END M.`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.oberon90 && !compiles(t, tt.src, orp.DialectOberon90) {
+				t.Fatal("source does not compile with -dialect oberon90")
+			}
+			out, notes := fixText([]byte(tt.src))
+			for _, n := range notes {
+				t.Errorf("pos %d %s", n.pos, n.msg)
+			}
+			if !compiles(t, string(out), orp.DialectOberon07) {
+				t.Errorf("result does not compile:\n%s", out)
+			}
+		})
+	}
+}
+
+func TestFixNotes(t *testing.T) {
+	src := `MODULE M;
+VAR i, n: INTEGER;
+BEGIN
+  LOOP
+    FOR i := 0 TO 9 DO
+      IF i = n THEN EXIT END
+    END
+  END
+END M.`
+	out, notes := fixText([]byte(src))
+	if len(notes) == 0 {
+		t.Errorf("no notes for EXIT in a FOR statement:\n%s", out)
+	}
+}
@@ -0,0 +1,529 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/fzipp/oberon-compiler/ors"
+)
+
+// Syntax of modules in earlier versions of Oberon, for oc fix
+//
+// The statements of the module and of its procedures are parsed into trees
+// that record the symbols a conversion rewrites. Expressions and types are
+// skipped. Of the declarations only the names are recorded, with the first
+// symbol of the type for variables and parameters.
+
+// A token is a symbol of the source text, from pos to end.
+type token struct {
+	sym      ors.Sym
+	pos, end int
+}
+
+// A span is a range of tokens, from a to b-1.
+type span struct {
+	a, b int
+}
+
+// A stmt is a statement, kind is its first symbol, SymIdent for
+// assignments and procedure calls.
+type stmt struct {
+	kind        ors.Sym
+	first, last int       // tokens
+	branches    []*branch // IF, WHILE, CASE and WITH; the body of REPEAT, FOR and LOOP
+	els         *branch   // ELSE of IF, CASE and WITH
+	expr        span      // result of RETURN, condition of REPEAT, expression of CASE
+	target      *stmt     // LOOP that EXIT ends; for RETURN, the procedure if converted
+	noSep       bool      // RETURN at the end of a procedure body without semicolon
+	removed     bool      // RETURN removed by the conversion of the IF containing it
+
+	exits  []*stmt // converted LOOPs and procedure that the statement may leave
+	exited bool    // exits computed
+}
+
+// A branch is a statement sequence with its head, e.g. ELSIF cond THEN.
+type branch struct {
+	head int  // first token of the head (IF, ELSIF, "|", ...), -1 if none
+	cond span // condition, case labels or WITH variable
+	typ  span // type of a WITH branch
+	body int  // last token of the head (THEN, DO, ":", ...)
+	seq  []*stmt
+}
+
+// A proc is a procedure or the module body.
+type proc struct {
+	name    string
+	pos     int
+	result  span // result type, empty for proper procedures and the module
+	module  bool
+	scope   *scope
+	varEnd  int // last semicolon of the variable declarations, -1 if none
+	declEnd int // first token after the variable declarations
+	body    []*stmt
+	returns []*stmt
+	procs   []*proc
+}
+
+// A scope maps the names declared in a procedure or module to "CONST",
+// "TYPE", "PROCEDURE" or the first symbol of the type of a variable.
+type scope struct {
+	outer *scope
+	decls map[string]string
+}
+
+func (s *scope) lookup(name string) string {
+	for ; s != nil; s = s.outer {
+		if d, ok := s.decls[name]; ok {
+			return d
+		}
+	}
+	return ""
+}
+
+// syntaxError is raised by the parser with panic.
+type syntaxError struct {
+	pos int
+	msg string
+}
+
+// tokenize scans the text as with -dialect oberon90.
+func (f *fixer) tokenize() error {
+	var log bytes.Buffer
+	s := ors.NewScanner(bytes.NewReader(f.text), &log)
+	s.Legacy = true
+	for {
+		sym := s.Get()
+		t := token{sym, s.Start(), s.Pos()}
+		if prev := len(f.toks) - 1; sym == ors.SymUpto && prev >= 0 && f.toks[prev].end == t.pos {
+			// 1..2, the scanner reads the first dot with the number
+			f.toks[prev].end--
+			t.pos--
+		}
+		f.toks = append(f.toks, t)
+		if sym == ors.SymEot {
+			break
+		}
+		if sym == ors.SymIdent {
+			f.idents[f.str(len(f.toks)-1)] = true
+		}
+	}
+	if s.ErrCnt > 0 {
+		return fmt.Errorf("cannot scan:%s", strings.ReplaceAll(log.String(), "\n ", ""))
+	}
+	return nil
+}
+
+func (f *fixer) sym() ors.Sym {
+	return f.toks[f.i].sym
+}
+
+func (f *fixer) next() {
+	if f.sym() != ors.SymEot {
+		f.i++
+	}
+}
+
+func (f *fixer) expect(sym ors.Sym, what string) {
+	if f.sym() != sym {
+		panic(syntaxError{f.toks[f.i].pos, what + " expected"})
+	}
+	f.next()
+}
+
+// str returns the text of token i.
+func (f *fixer) str(i int) string {
+	return string(f.text[f.toks[i].pos:f.toks[i].end])
+}
+
+// spanText returns the text of the tokens of s, with the blanks and
+// comments between them.
+func (f *fixer) spanText(s span) string {
+	if s.a >= s.b {
+		return ""
+	}
+	return string(f.text[f.toks[s.a].pos:f.toks[s.b-1].end])
+}
+
+// module parses the module and returns its body with the procedures.
+func (f *fixer) module() *proc {
+	f.expect(ors.SymModule, "MODULE")
+	if f.sym() == ors.SymTimes {
+		f.next()
+	}
+	m := &proc{name: f.str(f.i), pos: f.toks[f.i].pos, module: true, scope: &scope{decls: make(map[string]string)}}
+	f.expect(ors.SymIdent, "module name")
+	f.expect(ors.SymSemicolon, ";")
+	if f.sym() == ors.SymImport {
+		for f.sym() != ors.SymSemicolon && f.sym() != ors.SymEot {
+			f.next()
+		}
+		f.expect(ors.SymSemicolon, ";")
+	}
+	f.declarations(m)
+	if f.sym() == ors.SymBegin {
+		f.next()
+		m.body = f.statSeq(nil, m)
+	}
+	f.expect(ors.SymEnd, "END")
+	return m
+}
+
+// declarations parses the declarations of p.
+func (f *fixer) declarations(p *proc) {
+	p.varEnd, p.declEnd = -1, -1
+	for {
+		switch f.sym() {
+		case ors.SymConst, ors.SymType:
+			kind := strings.ToUpper(f.str(f.i))
+			f.next()
+			for f.sym() == ors.SymIdent {
+				p.scope.decls[f.str(f.i)] = kind
+				f.skipDecl()
+			}
+		case ors.SymVar:
+			f.next()
+			for f.sym() == ors.SymIdent {
+				names := f.identList()
+				f.expect(ors.SymColon, ":")
+				for _, name := range names {
+					p.scope.decls[name] = f.str(f.i)
+				}
+				f.skipDecl()
+				p.varEnd = f.i - 1
+			}
+		case ors.SymProcedure:
+			if p.declEnd < 0 {
+				p.declEnd = f.i
+			}
+			f.procDecl(p)
+			f.expect(ors.SymSemicolon, ";")
+		default:
+			if p.declEnd < 0 {
+				p.declEnd = f.i
+			}
+			return
+		}
+	}
+}
+
+// identList parses ident [*|-] {"," ident [*|-]} and returns the names.
+func (f *fixer) identList() (names []string) {
+	for {
+		names = append(names, f.str(f.i))
+		f.expect(ors.SymIdent, "identifier")
+		if f.sym() == ors.SymTimes || f.sym() == ors.SymMinus {
+			f.next()
+		}
+		if f.sym() != ors.SymComma {
+			return names
+		}
+		f.next()
+	}
+}
+
+// skipDecl skips the rest of a declaration up to its semicolon, or up to a
+// closing parenthesis in a formal parameter list.
+func (f *fixer) skipDecl() {
+	depth := 0
+	for {
+		switch f.sym() {
+		case ors.SymLparen, ors.SymLbrak, ors.SymLbrace, ors.SymRecord:
+			depth++
+		case ors.SymRparen, ors.SymRbrak, ors.SymRbrace, ors.SymEnd:
+			if depth == 0 {
+				return
+			}
+			depth--
+		case ors.SymSemicolon:
+			if depth == 0 {
+				f.next()
+				return
+			}
+		case ors.SymEot:
+			panic(syntaxError{f.toks[f.i].pos, "; expected"})
+		}
+		f.next()
+	}
+}
+
+// procDecl parses a procedure declaration of the module or procedure outer.
+func (f *fixer) procDecl(outer *proc) {
+	f.next()
+	forward, code := false, false
+	switch f.sym() {
+	case ors.SymArrow:
+		forward = true
+		f.next()
+	case ors.SymMinus:
+		code = true
+		f.next()
+	case ors.SymTimes, ors.SymPlus:
+		f.next()
+	}
+	if f.sym() == ors.SymLparen {
+		// receiver
+		f.skipParens()
+	}
+	p := &proc{name: f.str(f.i), pos: f.toks[f.i].pos, scope: &scope{outer: outer.scope, decls: make(map[string]string)}}
+	outer.scope.decls[p.name] = "PROCEDURE"
+	f.expect(ors.SymIdent, "procedure name")
+	if f.sym() == ors.SymTimes || f.sym() == ors.SymMinus {
+		f.next()
+	}
+	if f.sym() == ors.SymLparen {
+		f.next()
+		for f.sym() != ors.SymRparen && f.sym() != ors.SymEot {
+			if f.sym() == ors.SymVar {
+				f.next()
+			}
+			names := f.identList()
+			f.expect(ors.SymColon, ":")
+			for _, name := range names {
+				p.scope.decls[name] = f.str(f.i)
+			}
+			f.skipDecl()
+		}
+		f.expect(ors.SymRparen, ")")
+		if f.sym() == ors.SymColon {
+			f.next()
+			a := f.i
+			f.expect(ors.SymIdent, "type")
+			if f.sym() == ors.SymPeriod {
+				f.next()
+				f.expect(ors.SymIdent, "type")
+			}
+			p.result = span{a, f.i}
+		}
+	}
+	if forward {
+		f.note(p.pos, "forward declaration of "+p.name+", the procedures must be reordered")
+		return
+	}
+	if code {
+		f.note(p.pos, "code procedure "+p.name)
+		for f.sym() != ors.SymSemicolon && f.sym() != ors.SymEot {
+			f.next()
+		}
+		return
+	}
+	f.expect(ors.SymSemicolon, ";")
+	f.declarations(p)
+	if f.sym() == ors.SymBegin {
+		f.next()
+		p.body = f.statSeq(nil, p)
+	}
+	if f.sym() == ors.SymReturn {
+		// Oberon-07
+		st := f.statement(nil, p)
+		st.noSep = len(p.body) > 0
+		p.body = append(p.body, st)
+	}
+	f.expect(ors.SymEnd, "END")
+	f.expect(ors.SymIdent, "procedure name")
+	outer.procs = append(outer.procs, p)
+}
+
+// skipParens skips tokens up to the matching closing parenthesis.
+func (f *fixer) skipParens() {
+	f.next()
+	f.skipDecl()
+	for f.sym() == ors.SymSemicolon {
+		f.next()
+		f.skipDecl()
+	}
+	f.expect(ors.SymRparen, ")")
+}
+
+// statSeq parses a statement sequence in the LOOP loop of procedure p.
+func (f *fixer) statSeq(loop *stmt, p *proc) (seq []*stmt) {
+	for {
+		if st := f.statement(loop, p); st != nil {
+			seq = append(seq, st)
+		}
+		if f.sym() != ors.SymSemicolon {
+			return seq
+		}
+		f.next()
+	}
+}
+
+// statement parses a statement and returns nil for the empty statement.
+func (f *fixer) statement(loop *stmt, p *proc) *stmt {
+	st := &stmt{kind: f.sym(), first: f.i}
+	switch st.kind {
+	case ors.SymIdent:
+		f.expr()
+	case ors.SymIf, ors.SymWhile:
+		then := ors.SymThen
+		if st.kind == ors.SymWhile {
+			then = ors.SymDo
+		}
+		for {
+			b := &branch{head: f.i}
+			f.next()
+			b.cond = f.exprUntil(then)
+			b.body = f.i
+			f.expect(then, "THEN or DO")
+			b.seq = f.statSeq(loop, p)
+			st.branches = append(st.branches, b)
+			if f.sym() != ors.SymElsif {
+				break
+			}
+		}
+		f.elseBranch(st, loop, p)
+	case ors.SymRepeat:
+		f.next()
+		b := &branch{head: st.first, body: st.first}
+		b.seq = f.statSeq(loop, p)
+		st.branches = []*branch{b}
+		f.expect(ors.SymUntil, "UNTIL")
+		st.expr = f.expr()
+	case ors.SymFor:
+		f.next()
+		b := &branch{head: st.first, cond: f.exprUntil(ors.SymDo), body: f.i}
+		f.next()
+		b.seq = f.statSeq(loop, p)
+		st.branches = []*branch{b}
+		st.last = f.i
+		f.expect(ors.SymEnd, "END")
+	case ors.SymCase:
+		f.next()
+		st.expr = f.exprUntil(ors.SymOf)
+		f.next()
+		head := -1
+		for {
+			b := &branch{head: head, body: -1}
+			if f.sym() != ors.SymBar && f.sym() != ors.SymElse && f.sym() != ors.SymEnd {
+				b.cond = f.exprUntil(ors.SymColon)
+				b.body = f.i
+				f.next()
+				b.seq = f.statSeq(loop, p)
+			}
+			st.branches = append(st.branches, b)
+			if f.sym() != ors.SymBar {
+				break
+			}
+			head = f.i
+			f.next()
+		}
+		f.elseBranch(st, loop, p)
+	case ors.SymWith:
+		for {
+			b := &branch{head: f.i}
+			f.next()
+			b.cond = f.exprUntil(ors.SymColon)
+			f.next()
+			b.typ = f.exprUntil(ors.SymDo)
+			b.body = f.i
+			f.next()
+			b.seq = f.statSeq(loop, p)
+			st.branches = append(st.branches, b)
+			if f.sym() != ors.SymBar {
+				break
+			}
+		}
+		f.elseBranch(st, loop, p)
+	case ors.SymLoop:
+		f.next()
+		b := &branch{head: st.first, body: st.first}
+		b.seq = f.statSeq(st, p)
+		st.branches = []*branch{b}
+		st.last = f.i
+		f.expect(ors.SymEnd, "END")
+	case ors.SymExit:
+		if loop == nil {
+			panic(syntaxError{f.toks[f.i].pos, "EXIT not within LOOP"})
+		}
+		st.target = loop
+		f.next()
+	case ors.SymReturn:
+		f.next()
+		st.expr = f.expr()
+		p.returns = append(p.returns, st)
+	default:
+		return nil
+	}
+	if st.last == 0 {
+		st.last = f.i - 1
+	}
+	return st
+}
+
+// elseBranch parses [ELSE StatementSequence] END of st.
+func (f *fixer) elseBranch(st *stmt, loop *stmt, p *proc) {
+	if f.sym() == ors.SymElse {
+		st.els = &branch{head: f.i, body: f.i}
+		f.next()
+		st.els.seq = f.statSeq(loop, p)
+	}
+	st.last = f.i
+	f.expect(ors.SymEnd, "END")
+}
+
+// expr skips an expression that ends a statement.
+func (f *fixer) expr() span {
+	return f.exprUntil(ors.SymSemicolon, ors.SymEnd, ors.SymElse, ors.SymElsif,
+		ors.SymUntil, ors.SymBar, ors.SymReturn)
+}
+
+// exprUntil skips tokens up to one of the symbols stop outside of
+// parentheses, brackets and braces.
+func (f *fixer) exprUntil(stop ...ors.Sym) span {
+	a := f.i
+	depth := 0
+	for {
+		sym := f.sym()
+		if sym == ors.SymEot {
+			panic(syntaxError{f.toks[f.i].pos, "unexpected end of text"})
+		}
+		if depth == 0 {
+			for _, s := range stop {
+				if sym == s {
+					return span{a, f.i}
+				}
+			}
+		}
+		switch sym {
+		case ors.SymLparen, ors.SymLbrak, ors.SymLbrace:
+			depth++
+		case ors.SymRparen, ors.SymRbrak, ors.SymRbrace:
+			depth--
+		}
+		f.next()
+	}
+}
+
+// topLevel calls fn for the tokens of s outside of parentheses, brackets
+// and braces.
+func (f *fixer) topLevel(s span, fn func(i int)) {
+	depth := 0
+	for i := s.a; i < s.b; i++ {
+		switch f.toks[i].sym {
+		case ors.SymLparen, ors.SymLbrak, ors.SymLbrace:
+			if depth == 0 {
+				fn(i)
+			}
+			depth++
+		case ors.SymRparen, ors.SymRbrak, ors.SymRbrace:
+			depth--
+		default:
+			if depth == 0 {
+				fn(i)
+			}
+		}
+	}
+}
+
+// isDesignator reports whether s has no operators outside of
+// parentheses, brackets and braces.
+func (f *fixer) isDesignator(s span) bool {
+	ok := true
+	f.topLevel(s, func(i int) {
+		sym := f.toks[i].sym
+		if sym <= ors.SymIs || sym == ors.SymNot {
+			ok = false
+		}
+	})
+	return ok
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fzipp/oberon-compiler/org"
+	"github.com/fzipp/oberon-compiler/orp"
+)
+
+func graphUsage() {
+	fail(`
+Prints the import graph of modules, read from their source files (.Mod) or
+object files (.rsc), in Graphviz DOT format or as JSON. Imported modules
+that are not given are included as external modules (dashed in DOT).
+Modules importing SYSTEM are marked red in DOT; this is only known from
+source files. Aliases of imports are shown as edge labels.
+
+Usage:
+    oc graph [-json] [-reduce] [-order] file...
+
+Flags:
+    -json    Prints JSON instead of DOT.
+    -reduce  Omits imports implied by other imports (transitive reduction).
+    -order   Numbers the modules in the order of their initialisation
+             by the module loader.
+
+Examples:
+    oc graph *.Mod | dot -Tsvg > imports.svg
+    oc graph -reduce -order *.rsc`)
+}
+
+type graphModule struct {
+	Name     string        `json:"name"`
+	External bool          `json:"external,omitempty"` // imported, but not given
+	System   bool          `json:"system,omitempty"`   // imports SYSTEM
+	Imports  []graphImport `json:"-"`
+}
+
+type graphImport struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Alias string `json:"alias,omitempty"`
+}
+
+type importGraph struct {
+	Modules   []*graphModule `json:"modules"`
+	Imports   []graphImport  `json:"imports"`
+	InitOrder []string       `json:"initOrder,omitempty"`
+	mods      map[string]*graphModule
+}
+
+func graph(args []string) {
+	flags := flag.NewFlagSet("graph", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "prints JSON instead of DOT")
+	reduce := flags.Bool("reduce", false, "omits imports implied by other imports")
+	order := flags.Bool("order", false, "numbers modules in initialisation order")
+	flags.Usage = graphUsage
+	_ = flags.Parse(args)
+	if flags.NArg() < 1 {
+		graphUsage()
+	}
+
+	g := &importGraph{Imports: []graphImport{}, mods: make(map[string]*graphModule)}
+	for _, arg := range flags.Args() {
+		g.read(arg)
+	}
+	for _, m := range g.Modules {
+		for _, imp := range m.Imports {
+			if g.mods[imp.To] == nil {
+				g.add(&graphModule{Name: imp.To, External: true})
+			}
+		}
+	}
+	if *order {
+		g.initOrder()
+	}
+	if *reduce {
+		g.reduce()
+	}
+	for _, m := range g.Modules {
+		g.Imports = append(g.Imports, m.Imports...)
+	}
+	if *asJSON {
+		b, err := json.MarshalIndent(g, "", "  ")
+		check(err)
+		fmt.Println(string(b))
+	} else {
+		g.writeDOT()
+	}
+}
+
+func (g *importGraph) add(m *graphModule) {
+	if g.mods[m.Name] != nil {
+		fail("module " + m.Name + " given more than once")
+	}
+	g.mods[m.Name] = m
+	g.Modules = append(g.Modules, m)
+}
+
+// read adds the module of a source or object file with its imports.
+func (g *importGraph) read(path string) {
+	f, err := os.Open(path)
+	check(err)
+	defer f.Close()
+	m := &graphModule{}
+	if filepath.Ext(path) == ".rsc" {
+		obj, err := org.ReadObjFile(f)
+		if err != nil {
+			fail(path + ": " + err.Error())
+		}
+		m.Name = string(obj.Name)
+		for _, imp := range obj.Imports {
+			m.Imports = append(m.Imports, graphImport{From: m.Name, To: string(imp.Name)})
+		}
+	} else {
+		modId, imports, err := orp.ReadImports(f)
+		if err != nil {
+			fail(path + ": " + err.Error())
+		}
+		m.Name = string(modId)
+		for _, imp := range imports {
+			if imp.Name == "SYSTEM" {
+				m.System = true
+				continue
+			}
+			gi := graphImport{From: m.Name, To: string(imp.Name)}
+			if imp.Alias != imp.Name {
+				gi.Alias = string(imp.Alias)
+			}
+			m.Imports = append(m.Imports, gi)
+		}
+	}
+	g.add(m)
+}
+
+// reduce removes the imports of modules that are also imported indirectly.
+func (g *importGraph) reduce() {
+	reach := make(map[string]map[string]bool)
+	var reachable func(name string) map[string]bool
+	reachable = func(name string) map[string]bool {
+		if r, ok := reach[name]; ok {
+			return r
+		}
+		r := make(map[string]bool)
+		reach[name] = r // imports are acyclic
+		for _, imp := range g.mods[name].Imports {
+			r[imp.To] = true
+			for to := range reachable(imp.To) {
+				r[to] = true
+			}
+		}
+		return r
+	}
+	for _, m := range g.Modules {
+		var imports []graphImport
+		for _, imp := range m.Imports {
+			indirect := false
+			for _, other := range m.Imports {
+				if other.To != imp.To && reachable(other.To)[imp.To] {
+					indirect = true
+					break
+				}
+			}
+			if !indirect {
+				imports = append(imports, imp)
+			}
+		}
+		m.Imports = imports
+	}
+}
+
+// initOrder determines the order in which the module loader initialises
+// the modules: each module after its imports, in the order of its import
+// list, starting with the modules as given.
+func (g *importGraph) initOrder() {
+	done := make(map[string]bool)
+	var load func(m *graphModule)
+	load = func(m *graphModule) {
+		if done[m.Name] {
+			return
+		}
+		done[m.Name] = true
+		for _, imp := range m.Imports {
+			load(g.mods[imp.To])
+		}
+		g.InitOrder = append(g.InitOrder, m.Name)
+	}
+	for _, m := range g.Modules {
+		load(m)
+	}
+}
+
+func (g *importGraph) writeDOT() {
+	initNo := make(map[string]int)
+	for i, name := range g.InitOrder {
+		initNo[name] = i + 1
+	}
+	fmt.Println("digraph imports {")
+	for _, m := range g.Modules {
+		var attrs []string
+		if n, ok := initNo[m.Name]; ok {
+			attrs = append(attrs, fmt.Sprintf("label=%q", fmt.Sprintf("%d %s", n, m.Name)))
+		}
+		if m.External {
+			attrs = append(attrs, "style=dashed")
+		}
+		if m.System {
+			attrs = append(attrs, "color=red")
+		}
+		if len(attrs) > 0 {
+			fmt.Printf("\t%q [%s];\n", m.Name, strings.Join(attrs, ", "))
+		} else {
+			fmt.Printf("\t%q;\n", m.Name)
+		}
+	}
+	for _, imp := range g.Imports {
+		if imp.Alias != "" {
+			fmt.Printf("\t%q -> %q [label=%q];\n", imp.From, imp.To, imp.Alias)
+		} else {
+			fmt.Printf("\t%q -> %q;\n", imp.From, imp.To)
+		}
+	}
+	fmt.Println("}")
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"slices"
+
+	"github.com/fzipp/oberon-compiler/orp"
+)
+
+// languageFlags are the flags -x and -dialect that select the language
+// accepted by the compiler. They are shared by the compiler and the
+// subcommands that compile source files.
+type languageFlags struct {
+	extensions []string
+	dialect    string
+}
+
+// register defines the flags in a flag set.
+func (l *languageFlags) register(flags *flag.FlagSet) {
+	flags.Func("x", "enables a language extension", func(s string) error {
+		if !slices.Contains(orp.Extensions, s) {
+			return errors.New("unknown extension " + s)
+		}
+		l.extensions = append(l.extensions, s)
+		return nil
+	})
+	flags.Func("dialect", "selects the language dialect: oberon07, oberon2 or oberon90", func(s string) error {
+		if !slices.Contains(orp.Dialects, s) {
+			return errors.New("unknown dialect " + s)
+		}
+		l.dialect = s
+		return nil
+	})
+}
+
+// options sets the language of compiler options.
+func (l *languageFlags) options(opts *orp.Options) {
+	opts.Extensions = l.extensions
+	opts.Dialect = l.dialect
+}
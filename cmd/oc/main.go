@@ -5,7 +5,10 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/fzipp/oberon-compiler/disk"
+	"github.com/fzipp/oberon-compiler/org"
 	"github.com/fzipp/oberon-compiler/orp"
+	"github.com/fzipp/oberon-compiler/ors"
 )
 
 func usage() {
@@ -15,30 +18,147 @@ Compiles the source code of one or more Oberon modules (.Mod)
 to object files for RISC-5 (.rsc) and accompanying symbol files (.smb).
 
 Usage:
-    oc [-s] modfile...
+    oc [-s] [-fp] [-map] [-W warning]... [-x extension]... [-dialect name] [-system modules] [-d image] [-rom format [-romsize words]] modfile...
+    oc smbdiff old.smb new.smb
+    oc verify [dir]
+    oc graph [-json] [-reduce] [-order] file...
+    oc types [-dot] [-x extension]... [-dialect name] modfile...
+    oc vet [-x extension]... [-dialect name] modfile...
+    oc audit [-system modules] [-x extension]... [-dialect name] modfile...
+    oc doc [-md] [-o dir] [-x extension]... [-dialect name] file...
+    oc xref [-json | -html dir] [-name name] [-x extension]... [-dialect name] modfile...
+    oc fix [-w] modfile...
 
 Flags:
-    -s  Overwrites existing symbol file on changes.
+    -s        Overwrites existing symbol file on changes.
+    -fp       Writes symbol file with fingerprints of the exported objects.
+              Its key is kept if exports are only added, so importing
+              modules stay valid.
+    -map      Writes the memory layout of the module to a map file (.map).
+    -W        Enables a warning (name or all), disables it (no-name), or
+              reports it as error (error=name, or error for all enabled).
+              Warnings: unused-import, unused-var, unused-proc,
+              unused-const, unused-type, unread-param,
+              unassigned-var, constant-comparison, constant-condition,
+              shadowed-import, empty-for.
+    -x        Enables a language extension beyond Oberon-07.
+              Extensions: outer-vars (access to the variables of
+              enclosing procedures), dyn-arrays (NEW(p, n) for pointers
+              to open arrays), struct-consts (constant arrays and
+              records).
+    -dialect  Selects the language dialect: oberon07 (default), oberon2
+              for Oberon-07 with the type-bound procedures of Oberon-2, or
+              oberon90 for the LOOP, EXIT, WITH and RETURN statements and
+              SHORTINT of earlier versions of Oberon.
+    -system   Allows only the given modules (comma-separated) to import
+              SYSTEM; the import by other modules is a compile error.
+    -d        Reads source and symbol files from a Project Oberon disk image
+              and writes object and symbol files into it.
+    -rom      Writes the code of RISC-0 modules (MODULE*) as ROM image in the
+              given format: mem (hex words), hex (Intel HEX) or bin (raw).
+    -romsize  Size of the ROM image in words (default 512).
 
 Examples:
     oc Hello.Mod
     oc -s Hello.Mod
+    oc -fp Hello.Mod
+    oc -W all -W no-unread-param -W error=unused-import Hello.Mod
+    oc -x outer-vars Legacy.Mod
+    oc -x dyn-arrays Buffers.Mod
+    oc -x struct-consts Tables.Mod
+    oc -dialect oberon2 Shapes.Mod
+    oc -dialect oberon90 -x outer-vars Texts.Mod
+    oc -system Kernel,FileDir,Files,Modules *.Mod
     oc A.Mod B.Mod C.Mod
-    oc *.Mod`)
+    oc *.Mod
+    oc -d Oberon.dsk Kernel.Mod FileDir.Mod Files.Mod Modules.Mod
+    oc -rom mem BootLoad.Mod`)
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "smbdiff":
+			smbDiff(os.Args[2:])
+			return
+		case "verify":
+			verify(os.Args[2:])
+			return
+		case "graph":
+			graph(os.Args[2:])
+			return
+		case "types":
+			types(os.Args[2:])
+			return
+		case "vet":
+			vet(os.Args[2:])
+			return
+		case "audit":
+			audit(os.Args[2:])
+			return
+		case "doc":
+			doc(os.Args[2:])
+			return
+		case "xref":
+			xref(os.Args[2:])
+			return
+		case "fix":
+			fix(os.Args[2:])
+			return
+		}
+	}
+
 	newSF := flag.Bool("s", false, "overwrites existing symbol file on changes")
+	fp := flag.Bool("fp", false, "writes symbol file with fingerprints of exported objects")
+	mapFile := flag.Bool("map", false, "writes memory layout to map file")
+	var warnings warningFlags
+	flag.Var(&warnings, "W", "enables (name, all), disables (no-name) or promotes (error=name, error) warnings")
+	var lang languageFlags
+	lang.register(flag.CommandLine)
+	var sysMods []ors.Ident
+	flag.Func("system", "allows only the given modules (comma-separated) to import SYSTEM", func(s string) error {
+		sysMods = moduleList(s)
+		return nil
+	})
+	image := flag.String("d", "", "reads and writes files in a disk image")
+	romFormat := flag.String("rom", "", "writes ROM image of RISC-0 modules: mem, hex or bin")
+	romSize := flag.Int("romsize", 512, "size of ROM image in words")
 	flag.Usage = usage
 	flag.Parse()
 
 	if flag.NArg() < 1 {
 		usage()
 	}
+	switch *romFormat {
+	case "", org.ROMMem, org.ROMHex, org.ROMBin:
+	default:
+		fail("unknown ROM image format: " + *romFormat)
+	}
+	if *romSize <= 0 {
+		fail(fmt.Sprintf("invalid ROM size: %d", *romSize))
+	}
+
+	opts := orp.Options{
+		NewSF:        *newSF,
+		Fingerprints: *fp,
+		Map:          *mapFile,
+		ROMFormat:    *romFormat,
+		ROMSize:      int32(*romSize),
+		Warnings:     warnings.warnings(),
+
+		SystemModules: sysMods,
+	}
+	lang.options(&opts)
+	if *image != "" {
+		d, err := disk.Open(*image)
+		check(err)
+		defer d.Close()
+		opts.FS = d
+	}
 
 	printVersion()
 	for _, arg := range flag.Args() {
-		err := orp.CompileFile(arg, *newSF)
+		err := orp.CompileFileWith(arg, opts)
 		check(err)
 	}
 }
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/fzipp/oberon-compiler/orb"
+)
+
+func smbDiffUsage() {
+	fail(`
+Reports the changes of the exported declarations between two versions
+of a symbol file (.smb), i.e. the reasons why their keys differ.
+
+Usage:
+    oc smbdiff old.smb new.smb`)
+}
+
+func smbDiff(args []string) {
+	if len(args) != 2 {
+		smbDiffUsage()
+	}
+	oldFile, err := os.Open(args[0])
+	check(err)
+	defer oldFile.Close()
+	newFile, err := os.Open(args[1])
+	check(err)
+	defer newFile.Close()
+	changes, err := orb.DiffSymFiles(oldFile, newFile)
+	check(err)
+	for _, c := range changes {
+		fmt.Println(c)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/fzipp/oberon-compiler/files"
+	"github.com/fzipp/oberon-compiler/orb"
+	"github.com/fzipp/oberon-compiler/orp"
+)
+
+func typesUsage() {
+	fail(`
+Compiles modules without writing any files and prints their record types:
+the chain of base types, the extension level, the size, the offsets of
+all fields, and the size of the heap block and the offsets of the pointers
+as recorded in the type descriptor for the garbage collector. With -dot the
+record type hierarchy is printed in Graphviz DOT format instead.
+
+Modules are compiled in the given order, the symbol files of other
+imported modules are read from the current directory.
+
+The flags -x and -dialect select the language as for the compiler.
+
+Usage:
+    oc types [-dot] [-x extension]... [-dialect name] modfile...
+
+Examples:
+    oc types Texts.Mod Oberon.Mod
+    oc types -dot *.Mod | dot -Tsvg > types.svg`)
+}
+
+// recordType is a record type declared in one of the compiled modules.
+type recordType struct {
+	name   string
+	t      *orb.Type
+	chain  []string // names of the type and its base types
+	fields []recordField
+	td     []int32
+}
+
+type recordField struct {
+	off   int32
+	decl  string // field and type
+	owner string // type that declares the field
+}
+
+func types(args []string) {
+	flags := flag.NewFlagSet("types", flag.ExitOnError)
+	dot := flags.Bool("dot", false, "prints the record type hierarchy in DOT format")
+	var lang languageFlags
+	lang.register(flags)
+	flags.Usage = typesUsage
+	_ = flags.Parse(args)
+	if flags.NArg() < 1 {
+		typesUsage()
+	}
+
+	var recs []*recordType
+	var log bytes.Buffer
+	compiled := false
+	opts := orp.Options{
+		FS:  files.Overlay(files.OS),
+		Log: &log,
+		Compiled: func(m *orp.Module) {
+			compiled = true
+			for _, obj := range m.Records {
+				recs = append(recs, newRecordType(m, obj))
+			}
+		},
+	}
+	lang.options(&opts)
+	for _, arg := range flags.Args() {
+		log.Reset()
+		compiled = false
+		err := orp.CompileFileWith(arg, opts)
+		check(err)
+		if !compiled {
+			fail(strings.TrimSpace(log.String()))
+		}
+	}
+	if *dot {
+		writeTypesDOT(recs)
+	} else {
+		for _, r := range recs {
+			r.print()
+		}
+	}
+}
+
+func newRecordType(m *orp.Module, obj *orb.Object) *recordType {
+	b := m.Base
+	qualName := func(t *orb.Type) string {
+		if t.Mno > 0 {
+			return b.TypeString(t)
+		}
+		return string(m.Name) + "." + b.TypeString(t)
+	}
+	r := &recordType{name: string(m.Name) + "." + string(obj.Name), t: obj.Type, td: m.Gen.TypeDesc(obj.Type)}
+	for t := obj.Type; t != nil; t = t.Base {
+		owner := qualName(t)
+		r.chain = append(r.chain, owner)
+		var bot *orb.Object
+		if t.Base != nil {
+			bot = t.Base.Dsc
+		}
+		for fld := t.Dsc; fld != bot && fld != nil; fld = fld.Next {
+			f := recordField{off: fld.Val, owner: owner}
+			if fld.Name != "" {
+				f.decl = string(fld.Name) + ": " + b.TypeString(fld.Type)
+			} else {
+				f.decl = "(hidden pointer)"
+			}
+			r.fields = append(r.fields, f)
+		}
+	}
+	slices.SortStableFunc(r.fields, func(a, b recordField) int {
+		return cmp.Compare(a.off, b.off)
+	})
+	return r
+}
+
+func (r *recordType) print() {
+	fmt.Printf("%s: size %d, extension level %d\n", r.name, r.t.Size, r.t.NOfPar)
+	if len(r.chain) > 1 {
+		fmt.Printf("    base types: %s\n", strings.Join(r.chain[1:], " -> "))
+	}
+	for _, f := range r.fields {
+		if f.owner != r.name {
+			fmt.Printf("    %4d  %s  (%s)\n", f.off, f.decl, f.owner)
+		} else {
+			fmt.Printf("    %4d  %s\n", f.off, f.decl)
+		}
+	}
+	offs := slices.Clone(r.td[4:])
+	slices.Sort(offs)
+	var ptrs []string
+	for _, off := range offs {
+		ptrs = append(ptrs, fmt.Sprint(off))
+	}
+	if len(ptrs) == 0 {
+		ptrs = append(ptrs, "none")
+	}
+	fmt.Printf("    heap block %d, pointers at %s\n", r.td[0], strings.Join(ptrs, ", "))
+}
+
+func writeTypesDOT(recs []*recordType) {
+	fmt.Println("digraph types {")
+	fmt.Println("\trankdir=BT;")
+	declared := make(map[string]bool)
+	for _, r := range recs {
+		declared[r.name] = true
+		fmt.Printf("\t%q [shape=box, label=%q];\n", r.name,
+			fmt.Sprintf("%s\nsize %d, level %d", r.name, r.t.Size, r.t.NOfPar))
+	}
+	external := make(map[string]bool)
+	for _, r := range recs {
+		if len(r.chain) < 2 {
+			continue
+		}
+		base := r.chain[1]
+		if !declared[base] && !external[base] {
+			external[base] = true
+			fmt.Printf("\t%q [shape=box, style=dashed];\n", base)
+		}
+		fmt.Printf("\t%q -> %q;\n", r.name, base)
+	}
+	fmt.Println("}")
+}
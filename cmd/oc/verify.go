@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/fzipp/oberon-compiler/orb"
+	"github.com/fzipp/oberon-compiler/org"
+	"github.com/fzipp/oberon-compiler/ors"
+)
+
+func verifyUsage() {
+	fail(`
+Checks the object files (.rsc) in a directory for stale imports, i.e.
+imported modules whose keys differ from the keys recorded at compile time.
+Such modules fail to load with "key mismatch" on the target. For imported
+modules with fingerprints (oc -fp), the fingerprints of the imported
+objects used are checked as well. Every module that needs
+recompilation is reported with the chain of imports that makes it stale.
+
+Usage:
+    oc verify [dir]`)
+}
+
+// verifyModule is a module found in the directory checked by oc verify.
+type verifyModule struct {
+	obj    *org.ObjFile // nil if there is no object file
+	smbKey int32
+	smbFps map[ors.Ident]int32 // nil if the symbol file has no fingerprints
+	hasSmb bool
+	stale  string // reason why the module is stale; empty if up to date
+	state  int    // 0: not checked, 1: being checked, 2: checked
+}
+
+// key returns the key of the module as checked by the module loader,
+// i.e. the key of the object file, or of the symbol file if there is
+// no object file yet.
+func (m *verifyModule) key() int32 {
+	if m.obj != nil {
+		return m.obj.Key
+	}
+	return m.smbKey
+}
+
+func verify(args []string) {
+	dir := "."
+	if len(args) == 1 {
+		dir = args[0]
+	} else if len(args) > 1 {
+		verifyUsage()
+	}
+	mods := make(map[string]*verifyModule)
+	entries, err := os.ReadDir(dir)
+	check(err)
+	for _, e := range entries {
+		ext := filepath.Ext(e.Name())
+		if e.IsDir() || (ext != ".rsc" && ext != ".smb") {
+			continue
+		}
+		name := strings.TrimSuffix(e.Name(), ext)
+		m := mods[name]
+		if m == nil {
+			m = &verifyModule{}
+			mods[name] = m
+		}
+		f, err := os.Open(filepath.Join(dir, e.Name()))
+		check(err)
+		if ext == ".rsc" {
+			m.obj, err = org.ReadObjFile(f)
+		} else {
+			var header [2]int32
+			err = binary.Read(f, binary.LittleEndian, &header)
+			m.smbKey, m.hasSmb = header[1], true
+			if err == nil {
+				_, err = f.Seek(0, io.SeekStart)
+			}
+			if err == nil {
+				m.smbFps, err = orb.SymFileFingerprints(f)
+			}
+		}
+		f.Close()
+		if err != nil {
+			fail(e.Name() + ": " + err.Error())
+		}
+	}
+	names := make([]string, 0, len(mods))
+	for name := range mods {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	nStale := 0
+	for _, name := range names {
+		m := mods[name]
+		if m.obj == nil {
+			continue
+		}
+		verifyImports(name, m, mods)
+		if m.stale != "" {
+			fmt.Printf("%s needs recompilation: %s\n", name, m.stale)
+			nStale++
+		}
+	}
+	if nStale > 0 {
+		os.Exit(1)
+	}
+}
+
+// verifyImports determines whether a module is stale, either because the
+// key of an imported module differs from the one recorded in its object
+// file, because an imported object it uses has a different fingerprint,
+// or because an imported module is stale itself.
+func verifyImports(name string, m *verifyModule, mods map[string]*verifyModule) {
+	if m.state != 0 {
+		return
+	}
+	m.state = 1
+	if m.hasSmb && m.obj.Version != 0 && m.smbKey != m.obj.Key {
+		m.stale = fmt.Sprintf("%s.rsc has key %08X, %s.smb has key %08X",
+			name, uint32(m.obj.Key), name, uint32(m.smbKey))
+	}
+	for _, imp := range m.obj.Imports {
+		if m.stale != "" {
+			break
+		}
+		im := mods[string(imp.Name)]
+		if im == nil {
+			continue // not in this directory, e.g. the inner core
+		}
+		if imp.Key != im.key() {
+			m.stale = fmt.Sprintf("%s imports %s with key %08X, %s has key %08X",
+				name, imp.Name, uint32(imp.Key), imp.Name, uint32(im.key()))
+		} else if reason := m.staleFingerprints(imp.Name, im); reason != "" {
+			m.stale = name + " uses " + reason
+		} else if im.obj != nil {
+			verifyImports(string(imp.Name), im, mods)
+			if im.stale != "" {
+				m.stale = name + " -> " + im.stale
+			}
+		}
+	}
+	m.state = 2
+}
+
+// staleFingerprints checks the fingerprints of the objects of the
+// imported module im used by m against the fingerprints of its symbol
+// file. It returns the reason why m is stale, or "" if the objects are
+// unchanged or im has no fingerprints.
+func (m *verifyModule) staleFingerprints(imp ors.Ident, im *verifyModule) string {
+	if im.smbFps == nil {
+		return ""
+	}
+	for _, used := range m.obj.Fingerprints {
+		if used.Module == imp {
+			return used.Stale(im.smbFps)
+		}
+	}
+	return ""
+}
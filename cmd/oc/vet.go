@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/fzipp/oberon-compiler/files"
+	"github.com/fzipp/oberon-compiler/orp"
+	"github.com/fzipp/oberon-compiler/ors"
+)
+
+func vetUsage() {
+	fail(`
+Compiles modules without writing any files and reports suspicious code in
+the same format as compile errors:
+
+    constant-comparison  comparisons that are always TRUE or FALSE because
+                         of the range of the type, e.g. ch >= 0X
+    constant-condition   IF and ELSIF statements with a constant condition
+    shadowed-import      declarations that hide an imported module
+    empty-for            FOR statements with constant bounds whose body is
+                         never executed
+
+Assigning a local procedure to a procedure variable and dividing by a
+constant negative divisor are compile errors, which are reported as well.
+The checks are also available as warnings of the compiler (-W).
+
+Modules are compiled in the given order, the symbol files of other
+imported modules are read from the current directory. The exit status is 1
+if any module has errors.
+
+The flags -x and -dialect select the language as for the compiler.
+
+Usage:
+    oc vet [-x extension]... [-dialect name] modfile...
+
+Examples:
+    oc vet Texts.Mod Oberon.Mod`)
+}
+
+func vet(args []string) {
+	flags := flag.NewFlagSet("vet", flag.ExitOnError)
+	var lang languageFlags
+	lang.register(flags)
+	flags.Usage = vetUsage
+	_ = flags.Parse(args)
+	if flags.NArg() < 1 {
+		vetUsage()
+	}
+
+	warnings := make(ors.Warnings)
+	for _, name := range orp.VetChecks {
+		warnings[name] = ors.WarnError
+	}
+	var log bytes.Buffer
+	compiled := false
+	opts := orp.Options{
+		FS:       files.Overlay(files.OS),
+		Warnings: warnings,
+		Log:      &log,
+		Compiled: func(*orp.Module) {
+			compiled = true
+		},
+	}
+	lang.options(&opts)
+	failed := false
+	for _, arg := range flags.Args() {
+		log.Reset()
+		compiled = false
+		err := orp.CompileFileWith(arg, opts)
+		check(err)
+		if !compiled {
+			fmt.Print(log.String())
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/fzipp/oberon-compiler/orp"
+	"github.com/fzipp/oberon-compiler/ors"
+)
+
+// warningFlags is the value of the repeatable -W flag:
+//
+//	-W all         enables all warnings
+//	-W name        enables a warning
+//	-W no-name     disables a warning
+//	-W error=name  reports a warning as error
+//	-W error       reports all enabled warnings as errors
+type warningFlags struct {
+	levels ors.Warnings
+	errors bool
+}
+
+func (w *warningFlags) String() string {
+	return ""
+}
+
+func (w *warningFlags) Set(value string) error {
+	if w.levels == nil {
+		w.levels = make(ors.Warnings)
+	}
+	level := ors.WarnOn
+	name := value
+	if value == "error" {
+		w.errors = true
+		return nil
+	} else if n, ok := strings.CutPrefix(value, "error="); ok {
+		level, name = ors.WarnError, n
+	} else if n, ok := strings.CutPrefix(value, "no-"); ok {
+		level, name = ors.WarnOff, n
+	}
+	names := []string{name}
+	if name == "all" {
+		names = orp.Warnings
+	} else if !slices.Contains(orp.Warnings, name) {
+		return errors.New("unknown warning " + name)
+	}
+	for _, n := range names {
+		if level != ors.WarnOn || w.levels[n] != ors.WarnError {
+			w.levels[n] = level
+		}
+	}
+	return nil
+}
+
+// warnings returns the levels of the warnings as given by the flags.
+func (w *warningFlags) warnings() ors.Warnings {
+	if w.errors {
+		for n, level := range w.levels {
+			if level == ors.WarnOn {
+				w.levels[n] = ors.WarnError
+			}
+		}
+	}
+	return w.levels
+}
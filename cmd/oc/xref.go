@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/fzipp/oberon-compiler/files"
+	"github.com/fzipp/oberon-compiler/orb"
+	"github.com/fzipp/oberon-compiler/orp"
+	"github.com/fzipp/oberon-compiler/ors"
+)
+
+func xrefUsage() {
+	fail(`
+Compiles modules without writing any files and lists every declaration and
+use of the constants, types, variables, procedures, record fields and
+imported modules they refer to, by qualified name: the module, the
+enclosing procedures and the name, e.g. Texts.Write.W. Record fields are
+qualified by the record type that declares them, e.g. Texts.Writer.buf,
+also if they are selected from an extension. Uses of objects of imported
+modules are included, the declarations are only known from given modules.
+
+With -html a page with the highlighted source text is written for each
+module, where every use links to the declaration, and every declaration
+to the list of its uses in the index page.
+
+Modules are compiled in the given order, the symbol files of other
+imported modules are read from the current directory.
+
+The flags -x and -dialect select the language as for the compiler.
+
+Usage:
+    oc xref [-json | -html dir] [-name name] [-x extension]... [-dialect name] modfile...
+
+Flags:
+    -json  Prints JSON instead of text.
+    -html  Writes HTML pages into the given directory.
+    -name  Lists only the object with the given qualified name and its
+           members, e.g. the fields of a record type or the local objects
+           of a procedure.
+
+Examples:
+    oc xref -name Texts.Writer.buf *.Mod
+    oc xref -html xref *.Mod`)
+}
+
+// An xrefEntry lists the declarations and uses of an object.
+type xrefEntry struct {
+	Name string    `json:"name"`
+	Kind string    `json:"kind"`
+	Refs []xrefRef `json:"refs"`
+}
+
+type xrefRef struct {
+	Module string `json:"module"`
+	File   string `json:"file"`
+	Pos    int    `json:"pos"` // start of the identifier
+	Line   int    `json:"line"`
+	Col    int    `json:"col"`
+	Decl   bool   `json:"decl,omitempty"`
+
+	index int // of the module
+}
+
+// xrefModule is a compiled module with its source text.
+type xrefModule struct {
+	name string
+	file string
+	text []byte
+	refs []orp.Ref
+}
+
+func xref(args []string) {
+	flags := flag.NewFlagSet("xref", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "prints JSON instead of text")
+	htmlDir := flags.String("html", "", "writes HTML pages into directory")
+	name := flags.String("name", "", "lists only the object with the qualified name and its members")
+	var lang languageFlags
+	lang.register(flags)
+	flags.Usage = xrefUsage
+	_ = flags.Parse(args)
+	if flags.NArg() < 1 || (*asJSON && *htmlDir != "") {
+		xrefUsage()
+	}
+
+	fsys := files.Overlay(files.OS)
+	var mods []*xrefModule
+	var log bytes.Buffer
+	var m *orp.Module
+	opts := orp.Options{
+		FS:   fsys,
+		XRef: true,
+		Log:  &log,
+		Compiled: func(cm *orp.Module) {
+			m = cm
+		},
+	}
+	lang.options(&opts)
+	for _, arg := range flags.Args() {
+		log.Reset()
+		m = nil
+		err := orp.CompileFileWith(arg, opts)
+		check(err)
+		if m == nil {
+			fail(strings.TrimSpace(log.String()))
+		}
+		f, err := fsys.Open(arg)
+		check(err)
+		text, err := ors.ReadText(f)
+		f.Close()
+		check(err)
+		mods = append(mods, &xrefModule{name: string(m.Name), file: arg, text: text, refs: m.Refs})
+	}
+
+	entries := xrefEntries(mods, *name)
+	switch {
+	case *asJSON:
+		b, err := json.MarshalIndent(entries, "", "  ")
+		check(err)
+		fmt.Println(string(b))
+	case *htmlDir != "":
+		check(os.MkdirAll(*htmlDir, 0o755))
+		for i, m := range mods {
+			writeHTMLFile(filepath.Join(*htmlDir, m.name+".html"), func(w *bufio.Writer) {
+				xrefSource(w, mods, i, entries)
+			})
+		}
+		writeHTMLFile(filepath.Join(*htmlDir, "index.html"), func(w *bufio.Writer) {
+			xrefIndex(w, mods, entries)
+		})
+	default:
+		for _, e := range entries {
+			fmt.Printf("%s  %s\n", e.Name, e.Kind)
+			for _, r := range e.Refs {
+				decl := ""
+				if r.Decl {
+					decl = "  decl"
+				}
+				fmt.Printf("    %s:%d:%d  pos %d%s\n", r.File, r.Line, r.Col, r.Pos, decl)
+			}
+		}
+	}
+}
+
+// xrefEntries collects the refs of the modules by name, in the order of
+// the names. Declarations come first, then the uses in the order of the
+// modules and their positions. If name is not empty, only the object with
+// that name and its members are included.
+func xrefEntries(mods []*xrefModule, name string) []*xrefEntry {
+	byName := make(map[string]*xrefEntry)
+	var entries []*xrefEntry
+	for i, m := range mods {
+		for _, r := range m.refs {
+			if name != "" && r.Name != name && !strings.HasPrefix(r.Name, name+".") {
+				continue
+			}
+			e := byName[r.Name]
+			if e == nil {
+				e = &xrefEntry{Name: r.Name}
+				byName[r.Name] = e
+				entries = append(entries, e)
+			}
+			if e.Kind == "" || r.Decl {
+				e.Kind = objKind(r.Obj)
+			}
+			pos := identStart(m.text, r.Pos)
+			line, col := lineCol(m.text, pos)
+			e.Refs = append(e.Refs, xrefRef{
+				Module: m.name, File: m.file,
+				Pos: pos, Line: line, Col: col,
+				Decl:  r.Decl,
+				index: i,
+			})
+		}
+	}
+	slices.SortFunc(entries, func(a, b *xrefEntry) int { return cmp.Compare(a.Name, b.Name) })
+	for _, e := range entries {
+		slices.SortStableFunc(e.Refs, func(a, b xrefRef) int {
+			if a.Decl != b.Decl {
+				if a.Decl {
+					return -1
+				}
+				return 1
+			}
+			return cmp.Or(cmp.Compare(a.index, b.index), cmp.Compare(a.Pos, b.Pos))
+		})
+	}
+	return entries
+}
+
+func objKind(obj *orb.Object) string {
+	switch obj.Class {
+	case orb.ClassMod:
+		return "module"
+	case orb.ClassConst:
+		if obj.Type.Form == orb.FormProc {
+			return "procedure"
+		}
+		return "constant"
+	case orb.ClassTyp:
+		return "type"
+	case orb.ClassVar:
+		return "variable"
+	case orb.ClassPar:
+		return "parameter"
+	case orb.ClassFld:
+		return "field"
+	case orb.ClassSProc, orb.ClassSFunc:
+		return "procedure"
+	case orb.ClassMethod:
+		return "type-bound procedure"
+	}
+	return ""
+}
+
+// identStart returns the position of the identifier ending at pos.
+func identStart(text []byte, pos int) int {
+	pos = min(pos, len(text))
+	for pos > 0 && isIdentChar(text[pos-1]) {
+		pos--
+	}
+	return pos
+}
+
+func isIdentChar(ch byte) bool {
+	return ch >= '0' && ch <= '9' || ch >= 'A' && ch <= 'Z' || ch >= 'a' && ch <= 'z'
+}
+
+// lineCol returns the line and column of a position in a text, counted
+// from 1. Lines may end with CR, LF or CR LF.
+func lineCol(text []byte, pos int) (line, col int) {
+	line = 1
+	start := 0
+	for i := 0; i < pos; i++ {
+		if text[i] == '\r' || (text[i] == '\n' && (i == 0 || text[i-1] != '\r')) {
+			line++
+		}
+		if text[i] == '\r' || text[i] == '\n' {
+			start = i + 1
+		}
+	}
+	return line, pos - start + 1
+}
+
+func writeHTMLFile(path string, write func(w *bufio.Writer)) {
+	f, err := os.Create(path)
+	check(err)
+	w := bufio.NewWriter(f)
+	write(w)
+	check(w.Flush())
+	check(f.Close())
+}
+
+// refAnchor returns the id of the identifier at pos in a source page.
+func refAnchor(pos int) string {
+	return fmt.Sprintf("p%d", pos)
+}
+
+// xrefSource writes the source text of the module mods[i] as HTML page.
+// Uses link to the declaration, if it is in one of the modules,
+// declarations link to the list of uses in the index.
+func xrefSource(w *bufio.Writer, mods []*xrefModule, i int, entries []*xrefEntry) {
+	m := mods[i]
+	type link struct {
+		pos, end int
+		href     string
+		decl     bool
+	}
+	var links []link
+	for _, e := range entries {
+		decl := slices.IndexFunc(e.Refs, func(r xrefRef) bool { return r.Decl })
+		for _, r := range e.Refs {
+			if r.index != i {
+				continue
+			}
+			l := link{pos: r.Pos, end: r.Pos, decl: r.Decl}
+			for l.end < len(m.text) && isIdentChar(m.text[l.end]) {
+				l.end++
+			}
+			if r.Decl {
+				l.href = "index.html#" + e.Name
+			} else if decl >= 0 {
+				d := e.Refs[decl]
+				l.href = d.Module + ".html#" + refAnchor(d.Pos)
+			} else {
+				l.href = "index.html#" + e.Name
+			}
+			links = append(links, l)
+		}
+	}
+	slices.SortFunc(links, func(a, b link) int { return cmp.Compare(a.pos, b.pos) })
+
+	fmt.Fprintf(w, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n</head>\n<body>\n", html.EscapeString(m.file))
+	fmt.Fprintf(w, "<p><a href=\"index.html\">Index</a></p>\n<h1>%s</h1>\n<pre>", html.EscapeString(m.file))
+	text := string(m.text) // CR line ends are read as LF by browsers
+	pos := 0
+	for _, l := range links {
+		if l.pos < pos {
+			continue // duplicate
+		}
+		fmt.Fprint(w, html.EscapeString(text[pos:l.pos]))
+		ident := html.EscapeString(text[l.pos:l.end])
+		if l.decl {
+			ident = "<b>" + ident + "</b>"
+		}
+		fmt.Fprintf(w, "<a id=\"%s\" href=\"%s\">%s</a>", refAnchor(l.pos), html.EscapeString(l.href), ident)
+		pos = l.end
+	}
+	fmt.Fprint(w, html.EscapeString(text[pos:]))
+	fmt.Fprint(w, "</pre>\n</body>\n</html>\n")
+}
+
+// xrefIndex writes the index page with the modules and the declarations
+// and uses of all objects.
+func xrefIndex(w *bufio.Writer, mods []*xrefModule, entries []*xrefEntry) {
+	fmt.Fprint(w, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>Cross-reference</title>\n</head>\n<body>\n<h1>Modules</h1>\n<ul>\n")
+	for _, m := range mods {
+		fmt.Fprintf(w, "<li><a href=\"%s.html\">%s</a></li>\n", m.name, html.EscapeString(m.file))
+	}
+	fmt.Fprint(w, "</ul>\n<h1>Objects</h1>\n<dl>\n")
+	for _, e := range entries {
+		name := html.EscapeString(e.Name)
+		fmt.Fprintf(w, "<dt id=\"%s\">%s <i>%s</i></dt>\n", name, name, e.Kind)
+		for _, r := range e.Refs {
+			decl := ""
+			if r.Decl {
+				decl = " decl"
+			}
+			fmt.Fprintf(w, "<dd><a href=\"%s.html#%s\">%s:%d:%d</a>%s</dd>\n",
+				r.Module, refAnchor(r.Pos), html.EscapeString(r.File), r.Line, r.Col, decl)
+		}
+	}
+	fmt.Fprint(w, "</dl>\n</body>\n</html>\n")
+}
@@ -0,0 +1,662 @@
+// Based on NW/PR 12.1.2014 / 15.3.2017  FileDir, Files and Kernel in Oberon-07
+
+// Package disk reads and writes the file system of Project Oberon disk
+// images, as used by the RISC emulators.
+//
+// The on-disk format is the one of the Oberon modules FileDir and Files:
+// the directory is a B-tree of file names whose root page is at sector
+// address DirRootAdr, and each file starts with a header sector that
+// contains its name, length and date, a table of its data sectors and an
+// extension table of index sectors for the data sectors of long files.
+// Disk addresses are sector numbers multiplied by 29.
+//
+// A Disk implements files.FileSystem, so that the compiler can read
+// source and symbol files from an image and write object and symbol
+// files into it.
+package disk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+)
+
+const (
+	FnLength    = 32
+	SectorSize  = 1024
+	secTabSize  = 64
+	exTabSize   = 12
+	indexSize   = SectorSize / 4
+	headerSize  = 352
+	dirRootAdr  = 29
+	dirPgSize   = 24
+	n           = dirPgSize / 2
+	dirMark     = 0x9B1EA38D
+	headerMark  = 0x9BA71D86
+	mapSize     = 0x10000 // number of sectors
+	maxFileSecs = secTabSize + exTabSize*indexSize
+)
+
+// Images that contain only the file system start with the directory
+// root page (sector 1). Full SD card images contain the file system
+// at 512-byte block fsOffset.
+const fsOffset = 0x80000 * 512
+
+var byteOrder = binary.LittleEndian
+
+var errBadName = errors.New("bad file name")
+
+type fileHeader struct {
+	// first page of each file on disk
+	mark         uint32
+	name         string
+	aleng, bleng int32
+	date         int32
+	ext          [exTabSize]int32
+	sec          [secTabSize]int32
+}
+
+type dirEntry struct {
+	// B-tree node
+	name string
+	adr  int32 // sec no of file header
+	p    int32 // sec no of descendant in directory
+}
+
+type dirPage struct {
+	mark uint32
+	m    int32
+	p0   int32 // sec no of left descendant in directory
+	e    [dirPgSize]dirEntry
+}
+
+// Disk is an open Project Oberon disk image.
+type Disk struct {
+	f      *os.File
+	base   int64 // file offset of sector 0
+	secMap [mapSize]bool
+}
+
+// Open opens the disk image with the given file name for reading and
+// writing.
+func Open(name string) (*Disk, error) {
+	f, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	d := &Disk{f: f, base: fsOffset}
+	var mark [4]byte
+	_, err = f.ReadAt(mark[:], 0)
+	if err == nil && byteOrder.Uint32(mark[:]) == dirMark {
+		d.base = -SectorSize
+	}
+	err = d.initSecMap()
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return d, nil
+}
+
+// Close closes the disk image.
+func (d *Disk) Close() error {
+	return d.f.Close()
+}
+
+// Kernel: sector access and allocation
+
+func (d *Disk) sectorOffset(adr int32) (int64, error) {
+	if adr <= 0 || adr%29 != 0 || adr/29 >= mapSize {
+		return 0, fmt.Errorf("bad sector address %d", adr)
+	}
+	return d.base + int64(adr/29)*SectorSize, nil
+}
+
+func (d *Disk) getSector(adr int32, buf []byte) error {
+	off, err := d.sectorOffset(adr)
+	if err != nil {
+		return err
+	}
+	_, err = d.f.ReadAt(buf[:SectorSize], off)
+	return err
+}
+
+func (d *Disk) putSector(adr int32, buf []byte) error {
+	off, err := d.sectorOffset(adr)
+	if err != nil {
+		return err
+	}
+	_, err = d.f.WriteAt(buf[:SectorSize], off)
+	return err
+}
+
+func (d *Disk) markSector(adr int32) {
+	if adr > 0 && adr/29 < mapSize {
+		d.secMap[adr/29] = true
+	}
+}
+
+func (d *Disk) allocSector(hint int32) (int32, error) {
+	// find free sector, starting after hint
+	s := hint / 29
+	for k := 0; k < mapSize; k++ {
+		s++
+		if s == mapSize {
+			s = 1
+		}
+		if !d.secMap[s] {
+			d.secMap[s] = true
+			return s * 29, nil
+		}
+	}
+	return 0, errors.New("disk full")
+}
+
+// initSecMap marks the sectors of the directory and of all files
+// reachable from it as used, like FileDir.Init at boot time.
+func (d *Disk) initSecMap() error {
+	d.secMap[0] = true
+	d.secMap[1] = true
+	return d.traverseDir(dirRootAdr, func(e *dirEntry) error {
+		return d.markFile(e.adr)
+	})
+}
+
+func (d *Disk) markFile(adr int32) error {
+	hd, err := d.getHeader(adr)
+	if err != nil {
+		return err
+	}
+	d.markSector(adr)
+	secs, err := d.fileSectors(hd)
+	if err != nil {
+		return err
+	}
+	for _, sec := range secs {
+		d.markSector(sec)
+	}
+	if hd.aleng >= secTabSize {
+		for i := int32(0); i <= (hd.aleng-secTabSize)/indexSize; i++ {
+			d.markSector(hd.ext[i])
+		}
+	}
+	return nil
+}
+
+// FileDir: directory pages
+
+func (d *Disk) getPage(adr int32) (*dirPage, error) {
+	buf := make([]byte, SectorSize)
+	err := d.getSector(adr, buf)
+	if err != nil {
+		return nil, err
+	}
+	a := &dirPage{
+		mark: byteOrder.Uint32(buf[0:]),
+		m:    int32(byteOrder.Uint32(buf[4:])),
+		p0:   int32(byteOrder.Uint32(buf[8:])),
+	}
+	if a.mark != dirMark || a.m < 0 || a.m > dirPgSize {
+		return nil, fmt.Errorf("bad directory page at sector address %d", adr)
+	}
+	for i := range a.m {
+		b := buf[64+i*40:]
+		a.e[i] = dirEntry{
+			name: fileName(b[:FnLength]),
+			adr:  int32(byteOrder.Uint32(b[32:])),
+			p:    int32(byteOrder.Uint32(b[36:])),
+		}
+	}
+	return a, nil
+}
+
+func (d *Disk) putPage(adr int32, a *dirPage) error {
+	buf := make([]byte, SectorSize)
+	byteOrder.PutUint32(buf[0:], a.mark)
+	byteOrder.PutUint32(buf[4:], uint32(a.m))
+	byteOrder.PutUint32(buf[8:], uint32(a.p0))
+	for i := range a.m {
+		b := buf[64+i*40:]
+		copy(b[:FnLength], a.e[i].name)
+		byteOrder.PutUint32(b[32:], uint32(a.e[i].adr))
+		byteOrder.PutUint32(b[36:], uint32(a.e[i].p))
+	}
+	d.markSector(adr)
+	return d.putSector(adr, buf)
+}
+
+func fileName(b []byte) string {
+	for i, ch := range b {
+		if ch == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
+
+func (d *Disk) search(name string) (int32, error) {
+	dadr := int32(dirRootAdr)
+	for {
+		a, err := d.getPage(dadr)
+		if err != nil {
+			return 0, err
+		}
+		L := int32(0)
+		R := a.m
+		// binary search
+		for L < R {
+			i := (L + R) / 2
+			if name <= a.e[i].name {
+				R = i
+			} else {
+				L = i + 1
+			}
+		}
+		if (R < a.m) && (name == a.e[R].name) {
+			return a.e[R].adr, nil // found
+		}
+		if R == 0 {
+			dadr = a.p0
+		} else {
+			dadr = a.e[R-1].p
+		}
+		if dadr == 0 {
+			return 0, nil // not found
+		}
+	}
+}
+
+func (d *Disk) insert(name string, dpg0 int32, h *bool, v *dirEntry, fad int32) error {
+	// h = "tree has become higher and v is ascending element"
+	a, err := d.getPage(dpg0)
+	if err != nil {
+		return err
+	}
+	L := int32(0)
+	R := a.m
+	for L < R {
+		i := (L + R) / 2
+		if name <= a.e[i].name {
+			R = i
+		} else {
+			L = i + 1
+		}
+	}
+	if (R < a.m) && (name == a.e[R].name) {
+		// replace
+		a.e[R].adr = fad
+		return d.putPage(dpg0, a)
+	}
+	// not on this page
+	var dpg1 int32
+	if R == 0 {
+		dpg1 = a.p0
+	} else {
+		dpg1 = a.e[R-1].p
+	}
+	var u dirEntry
+	if dpg1 == 0 {
+		// not in tree, insert
+		u = dirEntry{name: name, adr: fad, p: 0}
+		*h = true
+	} else {
+		err = d.insert(name, dpg1, h, &u, fad)
+		if err != nil {
+			return err
+		}
+	}
+	if *h {
+		// insert u to the left of e[R]
+		if a.m < dirPgSize {
+			*h = false
+			copy(a.e[R+1:a.m+1], a.e[R:a.m])
+			a.e[R] = u
+			a.m++
+		} else {
+			// split page and assign the middle element to v
+			a.m = n
+			a.mark = dirMark
+			if R < n {
+				// insert in left half
+				*v = a.e[n-1]
+				copy(a.e[R+1:n], a.e[R:n-1])
+				a.e[R] = u
+				err = d.putPage(dpg0, a)
+				if err != nil {
+					return err
+				}
+				dpg0, err = d.allocSector(dpg0)
+				if err != nil {
+					return err
+				}
+				copy(a.e[:n], a.e[n:2*n])
+			} else {
+				// insert in right half
+				err = d.putPage(dpg0, a)
+				if err != nil {
+					return err
+				}
+				dpg0, err = d.allocSector(dpg0)
+				if err != nil {
+					return err
+				}
+				R -= n
+				if R == 0 {
+					*v = u
+					copy(a.e[:n], a.e[n:2*n])
+				} else {
+					*v = a.e[n]
+					copy(a.e[:R-1], a.e[n+1:n+R])
+					a.e[R-1] = u
+					copy(a.e[R:n], a.e[n+R:2*n])
+				}
+			}
+			a.p0 = v.p
+			v.p = dpg0
+		}
+		return d.putPage(dpg0, a)
+	}
+	return nil
+}
+
+func (d *Disk) insertName(name string, fad int32) error {
+	h := false
+	var U dirEntry
+	err := d.insert(name, dirRootAdr, &h, &U, fad)
+	if err != nil || !h {
+		return err
+	}
+	// root overflow
+	a, err := d.getPage(dirRootAdr)
+	if err != nil {
+		return err
+	}
+	oldRoot, err := d.allocSector(dirRootAdr)
+	if err != nil {
+		return err
+	}
+	err = d.putPage(oldRoot, a)
+	if err != nil {
+		return err
+	}
+	a = &dirPage{mark: dirMark, m: 1, p0: oldRoot}
+	a.e[0] = U
+	return d.putPage(dirRootAdr, a)
+}
+
+func (d *Disk) traverseDir(dpg int32, handle func(e *dirEntry) error) error {
+	a, err := d.getPage(dpg)
+	if err != nil {
+		return err
+	}
+	d.markSector(dpg)
+	if a.p0 != 0 {
+		err = d.traverseDir(a.p0, handle)
+		if err != nil {
+			return err
+		}
+	}
+	for i := range a.m {
+		err = handle(&a.e[i])
+		if err != nil {
+			return err
+		}
+		if a.e[i].p != 0 {
+			err = d.traverseDir(a.e[i].p, handle)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Names returns the names of all files on the disk in alphabetical order.
+func (d *Disk) Names() ([]string, error) {
+	var names []string
+	err := d.traverseDir(dirRootAdr, func(e *dirEntry) error {
+		names = append(names, e.name)
+		return nil
+	})
+	return names, err
+}
+
+// Files: file headers and data sectors
+
+func (d *Disk) getHeader(adr int32) (*fileHeader, error) {
+	buf := make([]byte, SectorSize)
+	err := d.getSector(adr, buf)
+	if err != nil {
+		return nil, err
+	}
+	return decodeHeader(adr, buf)
+}
+
+func decodeHeader(adr int32, buf []byte) (*fileHeader, error) {
+	hd := &fileHeader{
+		mark:  byteOrder.Uint32(buf[0:]),
+		name:  fileName(buf[4 : 4+FnLength]),
+		aleng: int32(byteOrder.Uint32(buf[36:])),
+		bleng: int32(byteOrder.Uint32(buf[40:])),
+		date:  int32(byteOrder.Uint32(buf[44:])),
+	}
+	if hd.mark != headerMark || hd.aleng < 0 || hd.aleng >= maxFileSecs || hd.bleng < 0 || hd.bleng > SectorSize {
+		return nil, fmt.Errorf("bad file header at sector address %d", adr)
+	}
+	for i := range hd.ext {
+		hd.ext[i] = int32(byteOrder.Uint32(buf[48+i*4:]))
+	}
+	for i := range hd.sec {
+		hd.sec[i] = int32(byteOrder.Uint32(buf[96+i*4:]))
+	}
+	return hd, nil
+}
+
+func (hd *fileHeader) encode(buf []byte) {
+	byteOrder.PutUint32(buf[0:], hd.mark)
+	clear(buf[4 : 4+FnLength])
+	copy(buf[4:4+FnLength], hd.name)
+	byteOrder.PutUint32(buf[36:], uint32(hd.aleng))
+	byteOrder.PutUint32(buf[40:], uint32(hd.bleng))
+	byteOrder.PutUint32(buf[44:], uint32(hd.date))
+	for i, adr := range hd.ext {
+		byteOrder.PutUint32(buf[48+i*4:], uint32(adr))
+	}
+	for i, adr := range hd.sec {
+		byteOrder.PutUint32(buf[96+i*4:], uint32(adr))
+	}
+}
+
+// fileSectors returns the addresses of the sectors 0 .. aleng of a file;
+// sector 0 is the header sector.
+func (d *Disk) fileSectors(hd *fileHeader) ([]int32, error) {
+	secs := make([]int32, hd.aleng+1)
+	idx := make([]byte, SectorSize)
+	for a := int32(0); a <= hd.aleng; a++ {
+		if a < secTabSize {
+			secs[a] = hd.sec[a]
+		} else {
+			i := (a - secTabSize) % indexSize
+			if i == 0 {
+				err := d.getSector(hd.ext[(a-secTabSize)/indexSize], idx)
+				if err != nil {
+					return nil, err
+				}
+			}
+			secs[a] = int32(byteOrder.Uint32(idx[i*4:]))
+		}
+	}
+	return secs, nil
+}
+
+// ReadFile returns the contents of the named file. If the file does not
+// exist, the error wraps fs.ErrNotExist.
+func (d *Disk) ReadFile(name string) ([]byte, error) {
+	adr, err := d.search(name)
+	if err != nil {
+		return nil, err
+	}
+	if adr == 0 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	hd, err := d.getHeader(adr)
+	if err != nil {
+		return nil, err
+	}
+	secs, err := d.fileSectors(hd)
+	if err != nil {
+		return nil, err
+	}
+	length := hd.aleng*SectorSize + hd.bleng - headerSize
+	if length < 0 {
+		return nil, fmt.Errorf("%s: bad file length", name)
+	}
+	data := make([]byte, 0, length)
+	buf := make([]byte, SectorSize)
+	for a, sec := range secs {
+		err = d.getSector(sec, buf)
+		if err != nil {
+			return nil, err
+		}
+		lo, hi := int32(0), int32(SectorSize)
+		if a == 0 {
+			lo = headerSize
+		}
+		if int32(a) == hd.aleng {
+			hi = hd.bleng
+		}
+		if lo < hi {
+			data = append(data, buf[lo:hi]...)
+		}
+	}
+	return data, nil
+}
+
+// WriteFile writes data to the named file, replacing an existing file of
+// the same name. As in Oberon, the sectors of a replaced file are not
+// reused before they are reclaimed when the system is booted.
+func (d *Disk) WriteFile(name string, data []byte) error {
+	if !validName(name) {
+		return &fs.PathError{Op: "write", Path: name, Err: errBadName}
+	}
+	total := int32(len(data)) + headerSize
+	nOfSecs := (total + SectorSize - 1) / SectorSize
+	if int(nOfSecs) > maxFileSecs {
+		return &fs.PathError{Op: "write", Path: name, Err: errors.New("file too long")}
+	}
+	hd := &fileHeader{
+		mark:  headerMark,
+		name:  name,
+		aleng: nOfSecs - 1,
+		bleng: total - (nOfSecs-1)*SectorSize,
+		date:  clock(time.Now()),
+	}
+	secs := make([]int32, nOfSecs)
+	hint := int32(0)
+	for a := range secs {
+		sec, err := d.allocSector(hint)
+		if err != nil {
+			return err
+		}
+		secs[a] = sec
+		hint = sec
+		if a < secTabSize {
+			hd.sec[a] = sec
+		}
+	}
+	// index sectors of extension table
+	for i := int32(0); secTabSize+i*indexSize < nOfSecs; i++ {
+		idx := make([]byte, SectorSize)
+		for j := int32(0); j < indexSize && secTabSize+i*indexSize+j < nOfSecs; j++ {
+			byteOrder.PutUint32(idx[j*4:], uint32(secs[secTabSize+i*indexSize+j]))
+		}
+		adr, err := d.allocSector(hint)
+		if err != nil {
+			return err
+		}
+		hd.ext[i] = adr
+		hint = adr
+		err = d.putSector(adr, idx)
+		if err != nil {
+			return err
+		}
+	}
+	buf := make([]byte, SectorSize)
+	data = append(make([]byte, headerSize), data...)
+	for a, sec := range secs {
+		clear(buf)
+		copy(buf, data[a*SectorSize:])
+		if a == 0 {
+			hd.encode(buf)
+		}
+		err := d.putSector(sec, buf)
+		if err != nil {
+			return err
+		}
+	}
+	return d.insertName(name, secs[0])
+}
+
+// validName reports whether name is a valid Oberon file name: a letter
+// followed by letters, digits or periods, shorter than FnLength.
+func validName(name string) bool {
+	if len(name) == 0 || len(name) >= FnLength {
+		return false
+	}
+	for i, ch := range []byte(name) {
+		isLetter := ('A' <= ch && ch <= 'Z') || ('a' <= ch && ch <= 'z')
+		isDigit := '0' <= ch && ch <= '9'
+		if !isLetter && (i == 0 || (!isDigit && ch != '.')) {
+			return false
+		}
+	}
+	return true
+}
+
+// clock returns t in the format of Kernel.Clock.
+func clock(t time.Time) int32 {
+	return int32(((((t.Year()%100*16+int(t.Month()))*32+t.Day())*32+t.Hour())*64+t.Minute())*64 + t.Second())
+}
+
+// Open opens the named file for reading. It implements files.FileSystem.
+func (d *Disk) Open(name string) (io.ReadCloser, error) {
+	data, err := d.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// Create creates the named file. The file is written to the disk when it
+// is closed. It implements files.FileSystem.
+func (d *Disk) Create(name string) (io.WriteCloser, error) {
+	if !validName(name) {
+		return nil, &fs.PathError{Op: "create", Path: name, Err: errBadName}
+	}
+	return &fileWriter{d: d, name: name}, nil
+}
+
+type fileWriter struct {
+	d      *Disk
+	name   string
+	data   []byte
+	closed bool
+}
+
+func (w *fileWriter) Write(p []byte) (int, error) {
+	w.data = append(w.data, p...)
+	return len(p), nil
+}
+
+func (w *fileWriter) Close() error {
+	if w.closed {
+		return fs.ErrClosed
+	}
+	w.closed = true
+	return w.d.WriteFile(w.name, w.data)
+}
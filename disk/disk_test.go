@@ -0,0 +1,240 @@
+package disk
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// newImage creates an empty file system image whose first sector is
+// the directory root page and opens it.
+func newImage(t *testing.T) (*Disk, string) {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "test.dsk")
+	root := make([]byte, SectorSize)
+	byteOrder.PutUint32(root[0:], dirMark)
+	err := os.WriteFile(name, root, 0o666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = d.Close() })
+	return d, name
+}
+
+func reopen(t *testing.T, d *Disk, name string) *Disk {
+	t.Helper()
+	err := d.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err = Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = d.Close() })
+	return d
+}
+
+func contents(name string, size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(len(name) + i*7)
+	}
+	return data
+}
+
+func checkHeader(t *testing.T, d *Disk, name string, size int) {
+	t.Helper()
+	adr, err := d.search(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if adr == 0 {
+		t.Fatalf("%s: not in directory", name)
+	}
+	hd, err := d.getHeader(adr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := int32(size) + headerSize
+	wantAleng := (total+SectorSize-1)/SectorSize - 1
+	wantBleng := total - wantAleng*SectorSize
+	if hd.name != name || hd.aleng != wantAleng || hd.bleng != wantBleng {
+		t.Errorf("%s: header has name %q, aleng %d, bleng %d; want %q, %d, %d",
+			name, hd.name, hd.aleng, hd.bleng, name, wantAleng, wantBleng)
+	}
+	if hd.sec[0] != adr {
+		t.Errorf("%s: sec[0] = %d, want header address %d", name, hd.sec[0], adr)
+	}
+}
+
+func TestWriteReadFiles(t *testing.T) {
+	d, name := newImage(t)
+	// Enough files to split the root page and some of its descendants,
+	// written in an order that inserts into left and right halves.
+	var names []string
+	sizes := map[string]int{}
+	for i := range 200 {
+		k := (i * 37) % 200
+		fn := fmt.Sprintf("File%03d.Mod", k)
+		names = append(names, fn)
+		sizes[fn] = (k * 131) % 3000
+	}
+	for _, fn := range names {
+		err := d.WriteFile(fn, contents(fn, sizes[fn]))
+		if err != nil {
+			t.Fatalf("WriteFile(%s): %v", fn, err)
+		}
+	}
+	root, err := d.getPage(dirRootAdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.p0 == 0 {
+		t.Fatal("directory root page was not split")
+	}
+
+	d = reopen(t, d, name)
+	got, err := d.Names()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := slices.Clone(names)
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Fatalf("Names() = %v, want %v", got, want)
+	}
+	for _, fn := range names {
+		data, err := d.ReadFile(fn)
+		if err != nil {
+			t.Fatalf("ReadFile(%s): %v", fn, err)
+		}
+		if !bytes.Equal(data, contents(fn, sizes[fn])) {
+			t.Errorf("%s: contents differ", fn)
+		}
+		checkHeader(t, d, fn, sizes[fn])
+	}
+}
+
+func TestReplaceFile(t *testing.T) {
+	d, name := newImage(t)
+	for i := range 30 {
+		fn := fmt.Sprintf("M%02d.rsc", i)
+		err := d.WriteFile(fn, contents(fn, 100+i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	const fn = "M17.rsc"
+	oldAdr, err := d.search(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("replaced contents")
+	err = d.WriteFile(fn, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d = reopen(t, d, name)
+	adr, err := d.search(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if adr == oldAdr {
+		t.Errorf("directory entry still refers to the old header at %d", oldAdr)
+	}
+	got, err := d.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("ReadFile(%s) = %q, want %q", fn, got, data)
+	}
+	checkHeader(t, d, fn, len(data))
+	names, err := d.Names()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 30 || slices.Index(names, fn) < 0 {
+		t.Errorf("Names() = %v, want 30 names including %s", names, fn)
+	}
+	// The other files are not affected by the replacement.
+	for i := range 30 {
+		other := fmt.Sprintf("M%02d.rsc", i)
+		if other == fn {
+			continue
+		}
+		got, err := d.ReadFile(other)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, contents(other, 100+i)) {
+			t.Errorf("%s: contents differ", other)
+		}
+	}
+}
+
+func TestLongFile(t *testing.T) {
+	d, name := newImage(t)
+	// more sectors than the sector table holds, so that the extension
+	// table is used
+	size := (secTabSize+indexSize+10)*SectorSize + 123
+	err := d.WriteFile("Long.Bin", contents("Long.Bin", size))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = d.WriteFile("Short.Txt", []byte("short"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d = reopen(t, d, name)
+	data, err := d.ReadFile("Long.Bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, contents("Long.Bin", size)) {
+		t.Error("Long.Bin: contents differ")
+	}
+	checkHeader(t, d, "Long.Bin", size)
+	// Sectors in use after reopening are not allocated again.
+	err = d.WriteFile("After.Txt", []byte("after"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = d.ReadFile("Long.Bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, contents("Long.Bin", size)) {
+		t.Error("Long.Bin: contents differ after writing another file")
+	}
+}
+
+func TestReadMissingFile(t *testing.T) {
+	d, _ := newImage(t)
+	_, err := d.ReadFile("Missing.Mod")
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("ReadFile of missing file: got error %v, want fs.ErrNotExist", err)
+	}
+}
+
+func TestBadName(t *testing.T) {
+	d, _ := newImage(t)
+	for _, fn := range []string{"", "1abc", "a b", "a_b", "ThisFileNameIsLongerThanThirtyTwo"} {
+		err := d.WriteFile(fn, nil)
+		if err == nil {
+			t.Errorf("WriteFile(%q) succeeded, want error", fn)
+		}
+	}
+}
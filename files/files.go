@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"io"
 	"math/bits"
+	"os"
 )
 
 var byteOrder = binary.LittleEndian
@@ -108,3 +109,60 @@ func WriteNum(w io.ByteWriter, x int32) {
 	}
 	WriteByte(w, byte(x)%0x80)
 }
+
+// FileSystem is the file system in which the compiler finds source files
+// and the symbol files of imported modules, and to which it writes symbol
+// files and object files.
+type FileSystem interface {
+	Open(name string) (io.ReadCloser, error)
+	Create(name string) (io.WriteCloser, error)
+}
+
+// OS is the file system of the host operating system. Names are relative
+// to the current directory.
+var OS FileSystem = osFileSystem{}
+
+type osFileSystem struct{}
+
+func (osFileSystem) Open(name string) (io.ReadCloser, error) {
+	return os.Open(name)
+}
+
+func (osFileSystem) Create(name string) (io.WriteCloser, error) {
+	return os.Create(name)
+}
+
+// Overlay returns a file system that reads files from fsys, but keeps the
+// files it creates in memory, where they take precedence over the files of
+// fsys. It allows to compile modules, including the symbol files imported
+// by later modules, without writing any files.
+func Overlay(fsys FileSystem) FileSystem {
+	return &overlay{fsys: fsys, files: make(map[string][]byte)}
+}
+
+type overlay struct {
+	fsys  FileSystem
+	files map[string][]byte
+}
+
+func (o *overlay) Open(name string) (io.ReadCloser, error) {
+	if data, ok := o.files[name]; ok {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	return o.fsys.Open(name)
+}
+
+func (o *overlay) Create(name string) (io.WriteCloser, error) {
+	return &overlayFile{o: o, name: name}, nil
+}
+
+type overlayFile struct {
+	bytes.Buffer
+	o    *overlay
+	name string
+}
+
+func (f *overlayFile) Close() error {
+	f.o.files[f.name] = f.Bytes()
+	return nil
+}
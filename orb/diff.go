@@ -0,0 +1,319 @@
+package orb
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/fzipp/oberon-compiler/ors"
+)
+
+// A Change is a difference between the exported declarations of two
+// versions of a symbol file, as reported by DiffSymFiles.
+type Change struct {
+	Old, New string // descriptions of the declaration; empty if added or removed
+}
+
+func (c Change) String() string {
+	if c.Old == "" {
+		return "added   " + c.New
+	}
+	if c.New == "" {
+		return "removed " + c.Old
+	}
+	return "changed " + c.New + "\n    was " + c.Old
+}
+
+// DiffSymFiles decodes two symbol files and returns the changes of the
+// exported constants, types, record fields, type-bound procedures,
+// variables and procedures that lead from the old to the new version, i.e.
+// the declarations that make up the difference between the keys of the
+// two files.
+func DiffSymFiles(old, new io.Reader) (changes []Change, err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = rec.(error)
+		}
+	}()
+	oldDecls := readDecls(old)
+	newDecls := readDecls(new)
+	oldDesc := make(map[string]string)
+	for _, d := range oldDecls {
+		oldDesc[d.key] = d.desc
+	}
+	newDesc := make(map[string]string)
+	for _, d := range newDecls {
+		newDesc[d.key] = d.desc
+		if od, ok := oldDesc[d.key]; !ok {
+			changes = append(changes, Change{New: d.desc})
+		} else if od != d.desc {
+			changes = append(changes, Change{Old: od, New: d.desc})
+		}
+	}
+	for _, d := range oldDecls {
+		if _, ok := newDesc[d.key]; !ok {
+			changes = append(changes, Change{Old: d.desc})
+		}
+	}
+	return changes, nil
+}
+
+// decl is the description of an exported declaration or record field;
+// key identifies the declaration across versions.
+type decl struct {
+	key, desc string
+}
+
+func readDecls(r io.Reader) []decl {
+	b := decodeBase()
+	mod := b.readSymFile(bufio.NewReader(r), "", "")
+	d := &declWriter{b: b, mod: mod}
+	var decls []decl
+	for _, obj := range Exports(mod) {
+		decls = append(decls, d.decls(obj)...)
+	}
+	return decls
+}
+
+// decodeBase returns a Base for decoding a symbol file outside of
+// a compilation.
+func decodeBase() *Base {
+	b := NewBase(ors.NewScanner(strings.NewReader(""), io.Discard), nil)
+	b.Init()
+	b.OpenScope()
+	return b
+}
+
+// Exports returns the objects of an imported module in order
+// of declaration.
+func Exports(mod *Object) []*Object {
+	var objs []*Object
+	for obj := mod.Dsc; obj != nil; obj = obj.Next {
+		objs = append([]*Object{obj}, objs...)
+	}
+	return objs
+}
+
+type declWriter struct {
+	b        *Base
+	mod      *Object
+	keys     bool // qualify names of imported types with the module key
+	visiting []*Type
+}
+
+func (d *declWriter) decls(obj *Object) []decl {
+	var decls []decl
+	name := string(obj.Name)
+	switch obj.Class {
+	case ClassConst:
+		if obj.Type.Form == FormProc {
+			decls = append(decls, decl{"PROCEDURE " + name,
+				fmt.Sprintf("PROCEDURE %s%s; entry %d", name, d.signature(obj.Type), obj.Val)})
+		} else if obj.Type.Form >= FormArray {
+			decls = append(decls, decl{"CONST " + name,
+				fmt.Sprintf("CONST %s = %s; entry %d", name, d.constVal(obj), obj.Val)})
+		} else {
+			decls = append(decls, decl{"CONST " + name,
+				fmt.Sprintf("CONST %s = %s", name, d.constVal(obj))})
+		}
+	case ClassTyp:
+		t := obj.Type
+		if t.Form == FormRecord && t.TypObj == obj {
+			desc := "RECORD"
+			if t.Base != nil {
+				desc += " (" + d.typ(t.Base) + ")"
+			}
+			desc += fmt.Sprintf("; size %d, extension level %d, descriptor entry %d", t.Size, t.NOfPar, t.Len)
+			if n := NumMethods(t); n > 0 {
+				desc += fmt.Sprintf(", methods %d", n)
+			}
+			decls = append(decls, decl{"TYPE " + name, "TYPE " + name + " = " + desc})
+			decls = append(decls, d.fields(name, t)...)
+			decls = append(decls, d.methods(name, t)...)
+		} else {
+			decls = append(decls, decl{"TYPE " + name,
+				"TYPE " + name + " = " + d.typeDesc(t)})
+		}
+	case ClassVar:
+		decls = append(decls, decl{"VAR " + name,
+			fmt.Sprintf("VAR %s: %s; entry %d", name, d.typ(obj.Type), obj.Val)})
+	}
+	return decls
+}
+
+func (d *declWriter) fields(recName string, t *Type) []decl {
+	var decls []decl
+	var bot *Object
+	if t.Base != nil {
+		bot = t.Base.Dsc
+	}
+	prefix := ""
+	if recName != "" {
+		prefix = recName + "."
+	}
+	var flds []*Object
+	for fld := t.Dsc; fld != bot && fld != nil; fld = fld.Next {
+		flds = append(flds, fld)
+	}
+	slices.SortStableFunc(flds, func(a, b *Object) int {
+		return cmp.Compare(a.Val, b.Val)
+	})
+	for _, fld := range flds {
+		if fld.Name != "" {
+			decls = append(decls, decl{"FIELD " + prefix + string(fld.Name),
+				fmt.Sprintf("%s%s: %s; offset %d", prefix, fld.Name, d.typ(fld.Type), fld.Val)})
+		} else {
+			decls = append(decls, decl{fmt.Sprintf("FIELD %s(%d)", prefix, fld.Val),
+				fmt.Sprintf("%s(hidden pointer); offset %d", prefix, fld.Val)})
+		}
+	}
+	return decls
+}
+
+// methods returns the type-bound procedures declared for the record type
+// t, in the order of their method numbers.
+func (d *declWriter) methods(recName string, t *Type) []decl {
+	var ms []*Object
+	for m := t.Methods; m != nil; m = m.Next {
+		ms = append(ms, m)
+	}
+	slices.SortFunc(ms, func(a, b *Object) int {
+		return cmp.Compare(a.Val, b.Val)
+	})
+	var decls []decl
+	for _, m := range ms {
+		rcv := d.typ(m.Type.Dsc.Type)
+		if m.Type.Dsc.Class == ClassPar {
+			rcv = "VAR " + rcv
+		}
+		decls = append(decls, decl{"METHOD " + recName + "." + string(m.Name),
+			fmt.Sprintf("PROCEDURE (%s) %s%s; method %d", rcv, m.Name, d.signature(MethodSignature(m)), m.Val)})
+	}
+	return decls
+}
+
+func (d *declWriter) constVal(obj *Object) string {
+	switch obj.Type.Form {
+	case FormBool:
+		if obj.Val != 0 {
+			return "TRUE"
+		}
+		return "FALSE"
+	case FormChar:
+		return fmt.Sprintf("%XX", obj.Val)
+	case FormReal:
+		return fmt.Sprint(math.Float32frombits(uint32(obj.Val)))
+	case FormSet:
+		var elems []string
+		for i := range 32 {
+			if obj.Val&(1<<i) != 0 {
+				elems = append(elems, fmt.Sprint(i))
+			}
+		}
+		return "{" + strings.Join(elems, ", ") + "}"
+	case FormNilTyp:
+		return "NIL"
+	case FormString:
+		return "string"
+	case FormArray, FormRecord:
+		return d.typ(obj.Type) + "[...]"
+	}
+	return fmt.Sprint(obj.Val)
+}
+
+// ConstString returns the value of a constant as in the changes reported
+// by DiffSymFiles. The values of string constants and the elements of
+// structured constants are not known.
+func (b *Base) ConstString(obj *Object) string {
+	d := &declWriter{b: b}
+	return d.constVal(obj)
+}
+
+// TypeString returns the name of a named type, qualified with the name of
+// its module if it is imported, or the description of an anonymous type,
+// as in the changes reported by DiffSymFiles.
+func (b *Base) TypeString(t *Type) string {
+	d := &declWriter{b: b}
+	return d.typ(t)
+}
+
+// typ returns the name of a named type, or the description of an
+// anonymous type.
+func (d *declWriter) typ(t *Type) string {
+	if t.TypObj != nil && t.TypObj.Type == t {
+		if t.Mno > 0 && (d.mod == nil || t.Mno != d.mod.Lev) {
+			for mod := d.b.TopScope.Next; mod != nil; mod = mod.Next {
+				if mod.Class == ClassMod && mod.Lev == t.Mno {
+					if d.keys {
+						return fmt.Sprintf("%s(%08X).%s", mod.OrgName, uint32(mod.Val), t.TypObj.Name)
+					}
+					return string(mod.OrgName) + "." + string(t.TypObj.Name)
+				}
+			}
+		}
+		return string(t.TypObj.Name)
+	}
+	return d.typeDesc(t)
+}
+
+func (d *declWriter) typeDesc(t *Type) string {
+	for _, v := range d.visiting {
+		if v == t {
+			return "..."
+		}
+	}
+	d.visiting = append(d.visiting, t)
+	defer func() { d.visiting = d.visiting[:len(d.visiting)-1] }()
+	switch t.Form {
+	case FormPointer:
+		return "POINTER TO " + d.typ(t.Base)
+	case FormProc:
+		return "PROCEDURE" + d.signature(t)
+	case FormArray:
+		if t.Len < 0 {
+			return "ARRAY OF " + d.typ(t.Base)
+		}
+		return fmt.Sprintf("ARRAY %d OF %s", t.Len, d.typ(t.Base))
+	case FormRecord:
+		var sb strings.Builder
+		sb.WriteString("RECORD")
+		if t.Base != nil {
+			sb.WriteString(" (" + d.typ(t.Base) + ")")
+		}
+		for _, f := range d.fields("", t) {
+			sb.WriteString(" " + f.desc + ";")
+		}
+		sb.WriteString(fmt.Sprintf(" END; size %d", t.Size))
+		return sb.String()
+	case FormNilTyp:
+		return "NIL"
+	case FormNoTyp:
+		return "no type"
+	case FormString:
+		return "string"
+	}
+	return d.typ(t)
+}
+
+func (d *declWriter) signature(t *Type) string {
+	var pars []string
+	for par := t.Dsc; par != nil; par = par.Next {
+		if par.Class == ClassPar && !par.Rdo {
+			pars = append(pars, "VAR "+d.typ(par.Type))
+		} else {
+			pars = append(pars, d.typ(par.Type))
+		}
+	}
+	sig := ""
+	if len(pars) > 0 {
+		sig = "(" + strings.Join(pars, "; ") + ")"
+	}
+	if t.Base != nil && t.Base.Form != FormNoTyp {
+		sig += ": " + d.typ(t.Base)
+	}
+	return sig
+}
@@ -0,0 +1,114 @@
+package orb
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+
+	"github.com/fzipp/oberon-compiler/files"
+	"github.com/fzipp/oberon-compiler/ors"
+)
+
+// The comments of a module and its exported objects are stored in a section
+// at the end of the symbol file, after the objects and the fingerprints,
+// so that the documentation of a module is available from its symbol file
+// alone: the tag docTag, the comment of the module, and pairs of the name
+// of an object and its comment, ended by an empty name. The section is
+// only present if there are comments. It is not covered by the key, so
+// that changing a comment does not invalidate the clients of a module.
+// Other readers of symbol files stop at the end of the objects and are
+// not affected.
+
+const docTag = 'D'
+
+var errBadSymFile = errors.New("invalid symbol file")
+
+// hasDocs reports whether the module being compiled or one of its exported
+// objects has a comment.
+func (b *Base) hasDocs() bool {
+	if b.Doc != "" {
+		return true
+	}
+	for obj := b.TopScope.Next; obj != nil; obj = obj.Next {
+		if obj.Expo && obj.Doc != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Base) writeDocs(w *bytes.Buffer) {
+	files.Write(w, docTag)
+	files.WriteString(w, b.Doc)
+	for obj := b.TopScope.Next; obj != nil; obj = obj.Next {
+		if obj.Expo && obj.Doc != "" {
+			files.WriteString(w, string(obj.Name))
+			files.WriteString(w, obj.Doc)
+		}
+	}
+	files.WriteString(w, "")
+}
+
+// readDocs reads the comments of a symbol file, if present, and attaches
+// them to the module mod and its objects.
+func readDocs(r *bufio.Reader, mod *Object) {
+	tag, err := r.Peek(1)
+	if err != nil || tag[0] != docTag {
+		return
+	}
+	_ = files.ReadByte(r)
+	mod.Doc = files.ReadString(r)
+	name := files.ReadString(r)
+	for name != "" {
+		doc := files.ReadString(r)
+		for obj := mod.Dsc; obj != nil; obj = obj.Next {
+			if string(obj.Name) == name {
+				obj.Doc = doc
+			}
+		}
+		name = files.ReadString(r)
+	}
+}
+
+// docsChanged reports whether two symbol files with the same exported
+// declarations differ in their comments.
+func docsChanged(old, new []byte) bool {
+	changes, err := DiffSymFiles(bytes.NewReader(old), bytes.NewReader(new))
+	if err != nil || len(changes) > 0 {
+		return false
+	}
+	oldMod := decodeBase().readSymFile(bufio.NewReader(bytes.NewReader(old)), "", "")
+	newMod := decodeBase().readSymFile(bufio.NewReader(bytes.NewReader(new)), "", "")
+	if oldMod.Doc != newMod.Doc {
+		return true
+	}
+	oldDocs := make(map[ors.Ident]string)
+	for obj := oldMod.Dsc; obj != nil; obj = obj.Next {
+		oldDocs[obj.Name] = obj.Doc
+	}
+	for obj := newMod.Dsc; obj != nil; obj = obj.Next {
+		if oldDocs[obj.Name] != obj.Doc {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadSymFile decodes a symbol file outside of a compilation. It returns
+// the Base the module is read into, to resolve the names of imported types
+// with TypeString, and the module with its exported objects and comments.
+// The objects are listed by Exports.
+func ReadSymFile(r io.Reader) (b *Base, mod *Object, err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = rec.(error)
+		}
+	}()
+	b = decodeBase()
+	mod = b.readSymFile(bufio.NewReader(r), "", "")
+	if b.ors.ErrCnt > 0 {
+		return nil, nil, errBadSymFile
+	}
+	return b, mod, nil
+}
@@ -0,0 +1,253 @@
+package orb
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/fzipp/oberon-compiler/files"
+	"github.com/fzipp/oberon-compiler/ors"
+)
+
+// Symbol files with fingerprints (version FingerprintVersionKey) are
+// followed by a list of the fingerprints of the exported objects, in
+// declaration order: the name of the object and its fingerprint, ended
+// by an empty name.
+//
+// The fingerprint of an object covers everything a client may depend on:
+// its type, value, entry number, and for records the size, extension
+// level, descriptor entry, field offsets and type-bound procedures. Named
+// types are identified by their name, and those of other modules also by
+// the key of their module.
+//
+// The key of a symbol file with fingerprints is kept if exports are only
+// added, i.e. the fingerprints of the existing objects are unchanged, so
+// that the module loader, which checks a single key per imported module,
+// accepts clients compiled against earlier versions. Any other change
+// yields a new key. In addition, every client records the fingerprints of
+// the imported objects it uses, see UsedFingerprints, which are checked by
+// ModuleFingerprints.Stale.
+
+type fingerprint struct {
+	name string
+	fp   int32
+}
+
+// fingerprints computes the fingerprints of the exported objects of a
+// symbol file.
+func fingerprints(symFile []byte) []fingerprint {
+	b := decodeBase()
+	mod := b.readSymFile(bufio.NewReader(bytes.NewReader(symFile)), "", "")
+	d := &declWriter{b: b, mod: mod, keys: true}
+	var fps []fingerprint
+	for _, obj := range Exports(mod) {
+		var descs []string
+		for _, dc := range d.decls(obj) {
+			descs = append(descs, dc.desc)
+		}
+		fps = append(fps, fingerprint{
+			name: string(obj.Name),
+			fp:   int32(crc32.ChecksumIEEE([]byte(strings.Join(descs, "\n")))),
+		})
+	}
+	return fps
+}
+
+// readFingerprints returns the fingerprints stored in a symbol file, or
+// nil if it is a symbol file without fingerprints.
+func readFingerprints(symFile []byte) []fingerprint {
+	b := decodeBase()
+	b.readSymFile(bufio.NewReader(bytes.NewReader(symFile)), "", "")
+	return b.fps
+}
+
+// readFingerprintList reads the fingerprints following the objects of
+// a symbol file.
+func readFingerprintList(r *bufio.Reader) []fingerprint {
+	fps := []fingerprint{}
+	for {
+		if _, err := r.Peek(1); err != nil {
+			break // symbol file being written, see fingerprints
+		}
+		name := files.ReadString(r)
+		if name == "" {
+			break
+		}
+		fps = append(fps, fingerprint{name: name, fp: files.ReadInt(r)})
+	}
+	return fps
+}
+
+// keepsFingerprints reports whether every object of the old symbol file
+// is still exported with the same fingerprint, i.e. whether exports were
+// only added. It is false if the old symbol file has no fingerprints.
+func keepsFingerprints(oldSymFile []byte, fps []fingerprint) bool {
+	oldFps := readFingerprints(oldSymFile)
+	if oldFps == nil {
+		return false
+	}
+	newFps := make(map[string]int32)
+	for _, f := range fps {
+		newFps[f.name] = f.fp
+	}
+	for _, f := range oldFps {
+		if fp, ok := newFps[f.name]; !ok || fp != f.fp {
+			return false
+		}
+	}
+	return true
+}
+
+// ModuleFingerprints are fingerprints of objects of a module, by name.
+type ModuleFingerprints struct {
+	Module ors.Ident
+	FPs    map[ors.Ident]int32
+}
+
+// Stale checks the fingerprints used, as recorded by a client, against
+// the current fingerprints of the module. It returns the reason why the
+// client is invalid, or "" if the objects it uses are unchanged.
+func (used *ModuleFingerprints) Stale(current map[ors.Ident]int32) string {
+	names := make([]ors.Ident, 0, len(used.FPs))
+	for name := range used.FPs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		fp, ok := current[name]
+		if !ok {
+			return fmt.Sprintf("%s.%s, which is no longer exported", used.Module, name)
+		}
+		if fp != used.FPs[name] {
+			return fmt.Sprintf("%s.%s with fingerprint %08X, now %08X",
+				used.Module, name, uint32(used.FPs[name]), uint32(fp))
+		}
+	}
+	return ""
+}
+
+// SymFileFingerprints returns the fingerprints of the exported objects
+// stored in a symbol file, or nil if it is a symbol file without
+// fingerprints.
+func SymFileFingerprints(r io.Reader) (fps map[ors.Ident]int32, err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = rec.(error)
+		}
+	}()
+	symFile, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return fingerprintMap(readFingerprints(symFile)), nil
+}
+
+func fingerprintMap(fps []fingerprint) map[ors.Ident]int32 {
+	if fps == nil {
+		return nil
+	}
+	m := make(map[ors.Ident]int32, len(fps))
+	for _, f := range fps {
+		m[ors.Ident(f.name)] = f.fp
+	}
+	return m
+}
+
+// UsedFingerprints returns the fingerprints of the imported objects that
+// are used by the module being compiled, for each imported module with
+// fingerprints. An object is used if it is referenced by name, or if it is
+// a named type that is part of the type of a used object, since a client
+// may depend on its layout without naming it. Modules whose types are only
+// re-exported by other modules are included, with the fingerprints of
+// their symbol files.
+func (b *Base) UsedFingerprints() []ModuleFingerprints {
+	used := make(map[*Object]map[ors.Ident]bool)
+	use := func(mod *Object, name ors.Ident) {
+		if used[mod] == nil {
+			used[mod] = make(map[ors.Ident]bool)
+		}
+		used[mod][name] = true
+	}
+	visited := make(map[*Type]bool)
+	var useType func(t *Type)
+	useType = func(t *Type) {
+		if t == nil || visited[t] {
+			return
+		}
+		visited[t] = true
+		if t.TypObj != nil && t.TypObj.Type == t && t.Mno > 0 {
+			if mod := b.module(t.Mno); mod != nil {
+				use(mod, t.TypObj.Name)
+			}
+		}
+		useType(t.Base)
+		if t.Form == FormRecord || t.Form == FormProc {
+			for obj := t.Dsc; obj != nil; obj = obj.Next {
+				useType(obj.Type)
+			}
+		}
+		for m := t.Methods; m != nil; m = m.Next {
+			useType(m.Type)
+		}
+	}
+	var mods []*Object
+	for mod := b.TopScope.Next; mod != nil; mod = mod.Next {
+		if mod.Class != ClassMod || mod.Lev <= 0 {
+			continue
+		}
+		mods = append(mods, mod)
+		for obj := mod.Dsc; obj != nil; obj = obj.Next {
+			if obj.Uses > 0 {
+				use(mod, obj.Name)
+				useType(obj.Type)
+			}
+		}
+	}
+	var mfps []ModuleFingerprints
+	for _, mod := range mods {
+		if used[mod] == nil {
+			continue
+		}
+		fps := b.moduleFingerprints(mod.OrgName)
+		if fps == nil {
+			continue // checked by the module key
+		}
+		mf := ModuleFingerprints{Module: mod.OrgName, FPs: make(map[ors.Ident]int32)}
+		for name := range used[mod] {
+			if fp, ok := fps[name]; ok {
+				mf.FPs[name] = fp
+			}
+		}
+		mfps = append(mfps, mf)
+	}
+	return mfps
+}
+
+// module returns the imported module with the given module number.
+func (b *Base) module(mno int32) *Object {
+	for mod := b.TopScope.Next; mod != nil; mod = mod.Next {
+		if mod.Class == ClassMod && mod.Lev == mno {
+			return mod
+		}
+	}
+	return nil
+}
+
+// moduleFingerprints returns the fingerprints of the symbol file of an
+// imported module, or nil if it has none. The symbol files of modules
+// that are only re-imported are read on demand.
+func (b *Base) moduleFingerprints(orgName ors.Ident) map[ors.Ident]int32 {
+	if fps, ok := b.modFps[orgName]; ok {
+		return fps
+	}
+	var fps map[ors.Ident]int32
+	if data, err := b.readFile(string(orgName) + ".smb"); err == nil {
+		fps = fingerprintMap(readFingerprints(data))
+	}
+	b.modFps[orgName] = fps
+	return fps
+}
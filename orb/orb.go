@@ -17,15 +17,15 @@ import (
 	"errors"
 	"io"
 	"io/fs"
-	"os"
 
 	"github.com/fzipp/oberon-compiler/files"
 	"github.com/fzipp/oberon-compiler/ors"
 )
 
 const (
-	VersionKey = 1
-	maxTypTab  = 64
+	VersionKey            = 1
+	FingerprintVersionKey = 2 // symbol file with fingerprints of the exported objects
+	maxTypTab             = 64
 )
 
 type Class byte
@@ -41,6 +41,7 @@ const (
 	ClassSProc
 	ClassSFunc
 	ClassMod
+	ClassMethod // type-bound procedure of a record type
 )
 
 type Form int
@@ -74,6 +75,9 @@ type Object struct {
 	Name    ors.Ident
 	OrgName ors.Ident
 	Val     int32
+	Pos     int    // position of the declaration in the source text
+	Uses    int32  // number of references, except as target of an assignment
+	Doc     string // comment preceding the declaration
 }
 
 type Type struct {
@@ -86,6 +90,9 @@ type Type struct {
 	TypObj *Object
 	Base   *Type // for arrays, records, pointers
 	Size   int32 // in bytes; always multiple of 4, except for FormByte, FormBool and FormChar
+
+	Methods *Object // for records: type-bound procedures declared for the type, see NumMethods
+	NOfMeth int32   // for records: size of the method table, without those of the base types
 }
 
 // Object classes and the meaning of "Val":
@@ -99,6 +106,7 @@ type Type struct {
 //    ClassSProc    inline code number
 //    ClassSFunc    inline code number
 //    ClassMod      key
+//    ClassMethod   method number, index in the method table
 //
 //  Type forms and the meaning of "Dsc" and "Base":
 //    Form         Dsc      Base
@@ -107,14 +115,21 @@ type Type struct {
 //    FormProc     params   result type
 //    FormArray    -        type of elements
 //    FormRecord   fields   extension
+//
+// A type-bound procedure (ClassMethod) has the type of the procedure, whose
+// first parameter is the receiver. For the module being compiled, its Dsc
+// is the procedure object (ClassConst) with the address of the code.
 
 type Base struct {
 	ors *ors.Scanner
+	fs  files.FileSystem
 
 	TopScope *Object
 	universe *Object
 	System   *Object
 
+	Doc string // comment of the module being compiled, for its symbol file
+
 	ByteType *Type
 	BoolType *Type
 	CharType *Type
@@ -128,10 +143,12 @@ type Base struct {
 	nOfMod int32
 	ref    Form
 	typTab [maxTypTab]*Type
+	fps    []fingerprint                     // of the last symbol file read; nil if none
+	modFps map[ors.Ident]map[ors.Ident]int32 // of the imported modules, see moduleFingerprints
 }
 
-func NewBase(s *ors.Scanner) *Base {
-	b := &Base{ors: s}
+func NewBase(s *ors.Scanner, fsys files.FileSystem) *Base {
+	b := &Base{ors: s, fs: fsys}
 
 	b.ByteType = b.newType(FormByte, FormInt, 1)
 	b.BoolType = b.newType(FormBool, FormBool, 1)
@@ -205,6 +222,21 @@ func NewBase(s *ors.Scanner) *Base {
 	return b
 }
 
+// EnterLegacy adds the predeclared identifiers of earlier versions of
+// Oberon to the universe: SHORTINT, like LONGINT a synonym to INTEGER, and
+// the functions LONG and SHORT, which convert between them and between
+// REAL and LONGREAL.
+func (b *Base) EnterLegacy() {
+	sys, intObj := b.System, b.IntType.TypObj
+	b.System = b.universe.Next
+	b.enter("SHORT", ClassSFunc, b.IntType, 221)
+	b.enter("LONG", ClassSFunc, b.IntType, 211)
+	b.enter("SHORTINT", ClassTyp, b.IntType, 0)
+	b.IntType.TypObj = intObj
+	b.universe.Next = b.System
+	b.System = sys
+}
+
 func (b *Base) NewObj(id ors.Ident, class Class) (obj *Object) {
 	// insert new Object with name id
 	x := b.TopScope
@@ -218,6 +250,8 @@ func (b *Base) NewObj(id ors.Ident, class Class) (obj *Object) {
 			Next:  nil,
 			Rdo:   false,
 			Dsc:   nil,
+			Pos:   b.ors.Pos(),
+			Doc:   b.ors.Comment,
 		}
 		x.Next = obj
 	} else {
@@ -239,6 +273,9 @@ func (b *Base) ThisObj() (x *Object) {
 			break
 		}
 	}
+	if x != nil {
+		x.Uses++
+	}
 	return x
 }
 
@@ -249,6 +286,9 @@ func (b *Base) ThisImport(mod *Object) (obj *Object) {
 			for (obj != nil) && (obj.Name != b.ors.Id) {
 				obj = obj.Next
 			}
+			if obj != nil {
+				obj.Uses++
+			}
 		} else {
 			obj = nil
 		}
@@ -266,6 +306,29 @@ func (b *Base) ThisField(rec *Type) *Object {
 	return fld
 }
 
+// NumMethods returns the size of the method table of the record type rec,
+// including the type-bound procedures of its base types and those that
+// are not exported.
+func NumMethods(rec *Type) int32 {
+	n := int32(0)
+	for ; rec != nil; rec = rec.Base {
+		n = max(n, rec.NOfMeth)
+	}
+	return n
+}
+
+// MethodSignature returns the procedure type of the calls of the
+// type-bound procedure m, i.e. without the receiver.
+func MethodSignature(m *Object) *Type {
+	return &Type{
+		Form:   FormProc,
+		Size:   m.Type.Size,
+		Base:   m.Type.Base,
+		Dsc:    m.Type.Dsc.Next,
+		NOfPar: m.Type.NOfPar - 1,
+	}
+}
+
 func (b *Base) OpenScope() {
 	b.TopScope = &Object{
 		Class: ClassHead,
@@ -305,6 +368,7 @@ func (b *Base) thisModule(name, orgName ors.Ident, decl bool, key int32) *Object
 				Lev:     b.nOfMod,
 				Dsc:     nil,
 				Next:    nil,
+				Pos:     b.ors.Pos(),
 			}
 			b.nOfMod++
 			if decl {
@@ -368,6 +432,24 @@ func (b *Base) inType(r *bufio.Reader, thisMod *Object) (t *Type) {
 			class := Class(files.Read(r))
 			var last *Object
 			for class != 0 {
+				if class == ClassMethod {
+					// type-bound procedures, and the size of the method table
+					m := &Object{
+						Class: class,
+						Name:  ors.Ident(files.ReadString(r)),
+						Expo:  true,
+					}
+					if m.Name != "" {
+						m.Type = b.inType(r, thisMod)
+						m.Val = files.ReadNum(r)
+						m.Next = t.Methods
+						t.Methods = m
+					} else {
+						t.NOfMeth = files.ReadNum(r)
+					}
+					class = Class(files.Read(r))
+					continue
+				}
 				// fields
 				fld := &Object{
 					Class: class,
@@ -456,57 +538,72 @@ func (b *Base) Import(modId, modId1 ors.Ident) {
 		thisMod.Rdo = true
 	} else {
 		fname := string(modId1) + ".smb"
-		f, err := os.Open(fname)
+		f, err := b.fs.Open(fname)
 		if err == nil {
 			defer f.Close()
-			r := bufio.NewReader(f)
-			_ = files.ReadInt(r)
-			key := files.ReadInt(r)
-			modName := files.ReadString(r)
-			_ = modName
-			thisMod := b.thisModule(modId, modId1, true, key)
-			thisMod.Rdo = true
-			versionKey := files.Read(r) // version key
-			if versionKey != VersionKey {
-				b.ors.Mark("wrong version")
+			b.readSymFile(bufio.NewReader(f), modId, modId1)
+		} else {
+			b.ors.Mark("import not available")
+		}
+	}
+}
+
+// readSymFile reads the exported objects of a symbol file into the object
+// list of the module modId1, imported under the alias modId. If modId1 is
+// empty, the module name stored in the symbol file is used for both.
+func (b *Base) readSymFile(r *bufio.Reader, modId, modId1 ors.Ident) *Object {
+	_ = files.ReadInt(r)
+	key := files.ReadInt(r)
+	modName := ors.Ident(files.ReadString(r))
+	if modId1 == "" {
+		modId, modId1 = modName, modName
+	}
+	thisMod := b.thisModule(modId, modId1, true, key)
+	thisMod.Rdo = true
+	versionKey := files.Read(r) // version key
+	if versionKey != VersionKey && versionKey != FingerprintVersionKey {
+		b.ors.Mark("wrong version")
+	}
+	class := Class(files.Read(r))
+	for class != 0 {
+		obj := &Object{
+			Class: class,
+			Name:  ors.Ident(files.ReadString(r)),
+			Type:  b.inType(r, thisMod),
+			Lev:   -thisMod.Lev,
+		}
+		if class == ClassTyp {
+			t := obj.Type
+			t.TypObj = obj
+			// fixup bases of previously declared pointer types
+			k := files.Read(r)
+			for k != 0 {
+				b.typTab[k].Base = t
+				k = files.Read(r)
 			}
-			class := Class(files.Read(r))
-			for class != 0 {
-				obj := &Object{
-					Class: class,
-					Name:  ors.Ident(files.ReadString(r)),
-					Type:  b.inType(r, thisMod),
-					Lev:   -thisMod.Lev,
-				}
-				if class == ClassTyp {
-					t := obj.Type
-					t.TypObj = obj
-					// fixup bases of previously declared pointer types
-					k := files.Read(r)
-					for k != 0 {
-						b.typTab[k].Base = t
-						k = files.Read(r)
-					}
+		} else {
+			if class == ClassConst {
+				if obj.Type.Form == FormReal {
+					obj.Val = files.ReadInt(r)
 				} else {
-					if class == ClassConst {
-						if obj.Type.Form == FormReal {
-							obj.Val = files.ReadInt(r)
-						} else {
-							obj.Val = files.ReadNum(r)
-						}
-					} else if class == ClassVar {
-						obj.Val = files.ReadNum(r)
-						obj.Rdo = true
-					}
+					obj.Val = files.ReadNum(r)
 				}
-				obj.Next = thisMod.Dsc
-				thisMod.Dsc = obj
-				class = Class(files.Read(r))
+			} else if class == ClassVar {
+				obj.Val = files.ReadNum(r)
+				obj.Rdo = true
 			}
-		} else {
-			b.ors.Mark("import not available")
 		}
+		obj.Next = thisMod.Dsc
+		thisMod.Dsc = obj
+		class = Class(files.Read(r))
+	}
+	b.fps = nil
+	if versionKey == FingerprintVersionKey {
+		b.fps = readFingerprintList(r)
 	}
+	b.modFps[modId1] = fingerprintMap(b.fps)
+	readDocs(r, thisMod)
+	return thisMod
 }
 
 // -------------------------------- Export ---------------------------------
@@ -576,7 +673,9 @@ func (b *Base) outType(w io.ByteWriter, t *Type) {
 				b.outType(w, b.NoType)
 				bot = nil
 			}
-			if obj != nil {
+			if t.Mno > 0 {
+				files.WriteNum(w, t.Len) // exno in the module that declares it
+			} else if obj != nil {
 				files.WriteNum(w, int32(obj.ExNo))
 			} else {
 				files.Write(w, 0)
@@ -596,6 +695,20 @@ func (b *Base) outType(w io.ByteWriter, t *Type) {
 				}
 				fld = fld.Next
 			}
+			// type-bound procedures
+			for m := t.Methods; m != nil; m = m.Next {
+				if m.Expo {
+					files.Write(w, int32(ClassMethod))
+					files.WriteString(w, string(m.Name))
+					b.outType(w, m.Type)
+					files.WriteNum(w, m.Val) // method number
+				}
+			}
+			if n := NumMethods(t); n > 0 {
+				files.Write(w, int32(ClassMethod))
+				files.Write(w, 0)
+				files.WriteNum(w, n)
+			}
 			files.Write(w, 0)
 		} else if t.Form == FormProc {
 			b.outType(w, t.Base)
@@ -622,13 +735,26 @@ func (b *Base) outType(w io.ByteWriter, t *Type) {
 	}
 }
 
-func (b *Base) Export(modId ors.Ident, newSF bool) (int32, bool) {
+// Export writes the symbol file of module modId, if it does not exist yet
+// or if it has changed and newSF is set. It returns the key (checksum) of
+// the symbol file, whether it was written, and the changes of the exported
+// declarations if the key differs from the one of an existing symbol file.
+//
+// If fp is set, the symbol file contains the fingerprints of the exported
+// objects. If an existing symbol file with fingerprints only gains
+// exports, its key is kept and the symbol file is written regardless of
+// newSF. Any other change yields a new key and requires newSF.
+func (b *Base) Export(modId ors.Ident, newSF, fp bool) (key int32, written bool, changes []Change) {
 	b.ref = FormRecord + 1
 	w := &bytes.Buffer{}
 	files.WriteInt(w, 0) // placeholder
 	files.WriteInt(w, 0) // placeholder for key to be inserted at the end
 	files.WriteString(w, string(modId))
-	files.Write(w, VersionKey)
+	if fp {
+		files.Write(w, FingerprintVersionKey)
+	} else {
+		files.Write(w, VersionKey)
+	}
 	obj := b.TopScope.Next
 	for obj != nil {
 		if obj.Expo {
@@ -648,7 +774,7 @@ func (b *Base) Export(modId ors.Ident, newSF bool) (int32, bool) {
 				}
 				files.Write(w, 0)
 			} else if obj.Class == ClassConst {
-				if obj.Type.Form == FormProc {
+				if obj.Type.Form == FormProc || obj.Type.Form >= FormArray {
 					files.WriteNum(w, int32(obj.ExNo))
 				} else if obj.Type.Form == FormReal {
 					files.WriteInt(w, obj.Val)
@@ -661,6 +787,20 @@ func (b *Base) Export(modId ors.Ident, newSF bool) (int32, bool) {
 		}
 		obj = obj.Next
 	}
+	var fps []fingerprint
+	if fp {
+		files.Write(w, 0)
+		fps = fingerprints(w.Bytes())
+		for _, f := range fps {
+			files.WriteString(w, f.name)
+			files.WriteInt(w, f.fp)
+		}
+	}
+	keyLen := w.Len() // the comments are not covered by the key
+	if b.hasDocs() {
+		files.Write(w, 0) // end of objects or fingerprints
+		b.writeDocs(w)
+	}
 	padLen := 4 - int(w.Len()%4)
 	for range padLen {
 		files.Write(w, 0)
@@ -669,58 +809,95 @@ func (b *Base) Export(modId ors.Ident, newSF bool) (int32, bool) {
 		b.typTab[b.ref] = nil
 	}
 	// compute key (checksum)
-	r := bytes.NewReader(w.Bytes())
+	keyPart := make([]byte, (keyLen+3)/4*4)
+	copy(keyPart, w.Bytes()[:keyLen])
+	r := bytes.NewReader(keyPart)
 	sum := int32(0)
 	x, err := files.ReadIntWithEOF(r)
 	for err != io.EOF {
 		sum += x
 		x, err = files.ReadIntWithEOF(r)
 	}
+	filename := string(modId) + ".smb"
+	old, err := b.readFile(filename)
+	notExist := errors.Is(err, fs.ErrNotExist)
+	oldKey := sum + 1
+	if err == nil {
+		oldR := bytes.NewReader(old)
+		_ = files.ReadInt(oldR)
+		oldKey = files.ReadInt(oldR)
+	} else if !notExist {
+		panic(err)
+	}
+	keep := fp && !notExist && sum != oldKey && keepsFingerprints(old, fps)
+	if keep {
+		sum = oldKey
+	}
 	// insert checksum
 	sumBuf := bytes.Buffer{}
 	files.WriteInt(&sumBuf, sum)
 	copy(w.Bytes()[4:], sumBuf.Bytes())
-	filename := string(modId) + ".smb"
-	oldKey, err := readKey(filename)
-	notExist := errors.Is(err, fs.ErrNotExist)
-	if notExist {
-		oldKey = sum + 1
-	} else if err != nil {
-		panic(err)
+	changed := keep && !bytes.Equal(old, w.Bytes())
+	if sum == oldKey && !notExist && !changed && docsChanged(old, w.Bytes()) {
+		b.writeFile(filename, w.Bytes())
 	}
-	if sum != oldKey {
-		if newSF || notExist {
-			f, err2 := os.Create(filename)
-			if err2 != nil {
-				panic(err2)
-			}
-			defer f.Close()
-			_, err2 = io.Copy(f, w)
-			if err2 != nil {
-				panic(err2)
-			}
-			return sum, true
+	if sum != oldKey || changed {
+		if !notExist {
+			changes = symFileChanges(old, w.Bytes())
+		}
+		if newSF || notExist || changed {
+			b.writeFile(filename, w.Bytes())
+			return sum, true, changes
 		} else {
 			b.ors.Mark("new symbol file inhibited")
 		}
 	}
-	return sum, false
+	return sum, false, changes
 }
 
-func readKey(smbFilename string) (key int32, err error) {
-	f, err := os.Open(smbFilename)
+// symFileChanges returns the changes between the existing symbol file
+// and its new version. A symbol file that cannot be decoded yields no
+// changes, as they are only reported for information.
+func symFileChanges(oldSymFile, newSymFile []byte) []Change {
+	changes, err := DiffSymFiles(bytes.NewReader(oldSymFile), bytes.NewReader(newSymFile))
 	if err != nil {
-		return 0, err
+		return nil
+	}
+	return changes
+}
+
+func (b *Base) readFile(filename string) ([]byte, error) {
+	f, err := b.fs.Open(filename)
+	if err != nil {
+		return nil, err
 	}
 	defer f.Close()
-	_ = files.ReadInt(f)
-	key = files.ReadInt(f)
-	return key, nil
+	data, err := io.ReadAll(f)
+	if err != nil {
+		panic(err)
+	}
+	return data, nil
+}
+
+func (b *Base) writeFile(filename string, data []byte) {
+	f, err := b.fs.Create(filename)
+	if err != nil {
+		panic(err)
+	}
+	_, err = f.Write(data)
+	if err != nil {
+		panic(err)
+	}
+	err = f.Close()
+	if err != nil {
+		panic(err)
+	}
 }
 
 func (b *Base) Init() {
 	b.TopScope = b.universe
 	b.nOfMod = 1
+	b.modFps = make(map[ors.Ident]map[ors.Ident]int32)
 }
 
 func (b *Base) newType(ref, form Form, size int32) *Type {
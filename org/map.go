@@ -0,0 +1,214 @@
+package org
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/fzipp/oberon-compiler/orb"
+	"github.com/fzipp/oberon-compiler/ors"
+)
+
+// ProcCode is the code range of a procedure, as listed in the map file.
+type ProcCode struct {
+	Name  string // qualified with the names of enclosing procedures
+	Entry int32  // code offset in bytes
+	End   int32  // code offset in bytes, exclusive
+	Frame int32  // size of the stack frame in bytes, including parameters
+}
+
+// WriteMap writes the memory layout of the module as text file <modId>.map.
+// It lists the type descriptors, global variables, strings, procedures,
+// commands and entries with their offsets and sizes, in the order in which
+// the module loader places them in memory. Offsets of data and strings are
+// relative to the start of the data area (SB), offsets of code to the start
+// of the code. To be called after Close, before the scope of the module
+// is closed.
+func (g *Generator) WriteMap(modId ors.Ident, key int32, procs []ProcCode) {
+	f, err := g.fs.Create(string(modId) + ".map")
+	if err != nil {
+		panic(err)
+	}
+	w := bufio.NewWriter(f)
+	g.writeMap(w, modId, key, procs)
+	err = w.Flush()
+	if err != nil {
+		panic(err)
+	}
+	err = f.Close()
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (g *Generator) writeMap(w io.Writer, modId ors.Ident, key int32, procs []ProcCode) {
+	printf := func(format string, a ...any) {
+		_, err := fmt.Fprintf(w, format, a...)
+		if err != nil {
+			panic(err)
+		}
+	}
+	type item struct {
+		off, size int32
+		desc      string
+	}
+	var data []item
+	var cmds, ents []*orb.Object
+	nOfImps := int32(0)
+	comSize := int32(4)
+	nOfPtrs := int32(0)
+	for obj := g.orb.TopScope.Next; obj != nil; obj = obj.Next {
+		if obj.Class == orb.ClassMod && obj.Dsc != g.orb.System {
+			nOfImps++
+		} else if obj.Class == orb.ClassVar {
+			data = append(data, item{obj.Val, obj.Type.Size,
+				"VAR " + exported(obj) + ": " + g.orb.TypeString(obj.Type)})
+			nOfPtrs += g.nOfPtrs(obj.Type)
+		} else if obj.Class == orb.ClassTyp && obj.Type.Form == orb.FormRecord && obj.Type.TypObj == obj {
+			data = append(data, item{obj.Type.Len - g.tdPrefix(), int32(len(g.TypeDesc(obj.Type))+1)*4 + g.tdPrefix(),
+				"TD " + exported(obj)})
+		} else if obj.ExNo != 0 && obj.Class == orb.ClassConst && obj.Type.Form == orb.FormProc &&
+			obj.Type.NOfPar == 0 && obj.Type.Base == g.orb.NoType {
+			cmds = append(cmds, obj)
+			comSize += (int32(len(obj.Name))+4)/4*4 + 4
+		}
+		if obj.ExNo != 0 {
+			ents = append(ents, obj)
+		}
+	}
+	slices.SortFunc(data, func(a, b item) int { return cmp.Compare(a.off, b.off) })
+	codeOrg := g.varSize + g.strx
+	impOrg := codeOrg + g.PC*4
+	cmdOrg := impOrg + nOfImps*4
+	entOrg := cmdOrg + comSize
+	nOfEnt := int32(len(ents)) + 1
+	ptrOrg := entOrg + nOfEnt*4
+
+	risc := "RISC-5"
+	if g.version == 0 {
+		risc = "RISC-0"
+	}
+	printf("MODULE %s  key %08X  %s\n\n", modId, uint32(key), risc)
+	printf("  offset    size  section\n")
+	printf("%8d%8d  type descriptors and variables\n", 0, g.varSize)
+	printf("%8d%8d  strings\n", g.varSize, g.strx)
+	printf("%8d%8d  code\n", codeOrg, g.PC*4)
+	printf("%8d%8d  imports\n", impOrg, nOfImps*4)
+	printf("%8d%8d  commands\n", cmdOrg, comSize)
+	printf("%8d%8d  entries\n", entOrg, nOfEnt*4)
+	printf("%8d%8d  pointer references\n", ptrOrg, (nOfPtrs+1)*4)
+	printf("%8d          total\n", ptrOrg+(nOfPtrs+1)*4)
+
+	printf("\nDATA (offsets from SB)\n")
+	for _, d := range data {
+		printf("%8d%8d  %s\n", d.off, d.size, d.desc)
+	}
+
+	printf("\nSTRINGS (offsets from SB)\n")
+	for i := int32(0); i < g.strx; {
+		if t := g.localTD(g.varSize + i + g.tdPrefix()); t != nil {
+			n := int32(len(g.TypeDesc(t))+1)*4 + g.tdPrefix()
+			printf("%8d%8d  TD %s (local)\n", g.varSize+i, n, t.TypObj.Name)
+			i += n
+			continue
+		}
+		if t := g.methodTable(i); t != nil {
+			n := orb.NumMethods(t) * 4
+			printf("%8d%8d  method table of %s\n", g.varSize+i, n, t.TypObj.Name)
+			i += n
+			continue
+		}
+		if n := g.constData[i]; n > 0 {
+			printf("%8d%8d  structured constant\n", g.varSize+i, n)
+			i += n
+			continue
+		}
+		if lev, ok := g.displayLevel(i); ok {
+			printf("%8d%8d  display entry of level %d\n", g.varSize+i, 4, lev)
+			i += 4
+			continue
+		}
+		j := i
+		for j < g.strx && g.str[j] != 0 {
+			j++
+		}
+		n := (j - i + 4) / 4 * 4
+		printf("%8d%8d  %q\n", g.varSize+i, n, g.str[i:j])
+		i += n
+	}
+
+	printf("\nCODE (offsets from the start of the code)\n")
+	printf("   entry     end   frame\n")
+	procs = slices.Clone(procs)
+	body := ProcCode{Name: "module body", Entry: g.entry, End: g.PC * 4}
+	if g.version != 0 {
+		body.Frame = 4
+	}
+	procs = append(procs, body)
+	slices.SortFunc(procs, func(a, b ProcCode) int { return cmp.Compare(a.Entry, b.Entry) })
+	for _, pc := range procs {
+		printf("%8d%8d%8d  %s\n", pc.Entry, pc.End, pc.Frame, pc.Name)
+	}
+
+	printf("\nCOMMANDS\n")
+	for _, obj := range cmds {
+		printf("%8d          %s\n", obj.Val, obj.Name)
+	}
+
+	printf("\nENTRIES\n")
+	printf("     no.  offset\n")
+	printf("%8d%8d  module body (code)\n", 0, g.entry)
+	for _, obj := range ents {
+		switch {
+		case obj.Class == orb.ClassConst && obj.Type.Form >= orb.FormArray:
+			printf("%8d%8d  CONST %s (data)\n", obj.ExNo, g.varSize+obj.Val, obj.Name)
+		case obj.Class == orb.ClassConst:
+			printf("%8d%8d  PROCEDURE %s (code)\n", obj.ExNo, obj.Val, obj.Name)
+		case obj.Class == orb.ClassVar:
+			printf("%8d%8d  VAR %s (data)\n", obj.ExNo, obj.Val, obj.Name)
+		case obj.Class == orb.ClassTyp:
+			printf("%8d%8d  TD %s (data)\n", obj.ExNo, obj.Type.Len, obj.Name)
+		}
+	}
+}
+
+// localTD returns the local type whose descriptor is at offset adr, or nil.
+func (g *Generator) localTD(adr int32) *orb.Type {
+	for _, t := range g.localTDs {
+		if t.Len == adr {
+			return t
+		}
+	}
+	return nil
+}
+
+// methodTable returns the record type whose method table is at offset off
+// in the strings, or nil.
+func (g *Generator) methodTable(off int32) *orb.Type {
+	for t, o := range g.methodTabs {
+		if o == off {
+			return t
+		}
+	}
+	return nil
+}
+
+// displayLevel returns the level of the display entry at offset off in the
+// strings, if any.
+func (g *Generator) displayLevel(off int32) (int32, bool) {
+	for lev, o := range g.display {
+		if o == off {
+			return lev, true
+		}
+	}
+	return 0, false
+}
+
+func exported(obj *orb.Object) string {
+	if obj.Expo {
+		return string(obj.Name) + "*"
+	}
+	return string(obj.Name)
+}
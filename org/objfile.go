@@ -0,0 +1,119 @@
+package org
+
+import (
+	"bufio"
+	"errors"
+	"io"
+
+	"github.com/fzipp/oberon-compiler/files"
+	"github.com/fzipp/oberon-compiler/orb"
+	"github.com/fzipp/oberon-compiler/ors"
+)
+
+// ObjFile is the content of an object file (.rsc) as written by
+// Generator.Close.
+type ObjFile struct {
+	Name     ors.Ident
+	Key      int32
+	Version  int32 // 0 for RISC-0 (MODULE*), 1 for RISC-5
+	Size     int32 // of the loaded module in bytes
+	Imports  []Import
+	TDs      []int32 // type descriptors
+	VarSize  int32   // size of the global variables, excluding type descriptors
+	Strings  []byte
+	Code     []int32
+	Commands []Command
+	Entries  []int32 // by entry number; 0 is the body, code for procedures, data for variables and types
+	Ptrs     []int32 // offsets of global pointer variables
+	FixOrgP  int32   // heads of the fixup chains for procedures,
+	FixOrgD  int32   // data
+	FixOrgT  int32   // and type descriptors
+	Body     int32   // entry of the module body
+
+	// fingerprints of the imported objects used, for imported modules
+	// with fingerprints, see orb.UsedFingerprints
+	Fingerprints []orb.ModuleFingerprints
+}
+
+// An Import is a module imported by the module of an object file,
+// together with the key of its symbol file at compile time.
+type Import struct {
+	Name ors.Ident
+	Key  int32
+}
+
+// A Command is an exported parameterless procedure.
+type Command struct {
+	Name ors.Ident
+	Adr  int32
+}
+
+var errObjFile = errors.New("not an object file")
+
+// ReadObjFile decodes an object file.
+func ReadObjFile(r io.Reader) (obj *ObjFile, err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = rec.(error)
+		}
+	}()
+	br := bufio.NewReader(r)
+	obj = &ObjFile{}
+	obj.Name = ors.Ident(files.ReadString(br))
+	obj.Key = files.ReadInt(br)
+	obj.Version = files.Read(br)
+	obj.Size = files.ReadInt(br)
+	name := files.ReadString(br)
+	for name != "" {
+		obj.Imports = append(obj.Imports, Import{Name: ors.Ident(name), Key: files.ReadInt(br)})
+		name = files.ReadString(br)
+	}
+	n := files.ReadInt(br) / 4
+	for range n {
+		obj.TDs = append(obj.TDs, files.ReadInt(br))
+	}
+	obj.VarSize = files.ReadInt(br)
+	n = files.ReadInt(br)
+	for range n {
+		obj.Strings = append(obj.Strings, files.ReadByte(br))
+	}
+	n = files.ReadInt(br)
+	for range n {
+		obj.Code = append(obj.Code, files.ReadInt(br))
+	}
+	name = files.ReadString(br)
+	for name != "" {
+		obj.Commands = append(obj.Commands, Command{Name: ors.Ident(name), Adr: files.ReadInt(br)})
+		name = files.ReadString(br)
+	}
+	n = files.ReadInt(br)
+	for range n {
+		obj.Entries = append(obj.Entries, files.ReadInt(br))
+	}
+	ptr := files.ReadInt(br)
+	for ptr >= 0 {
+		obj.Ptrs = append(obj.Ptrs, ptr)
+		ptr = files.ReadInt(br)
+	}
+	obj.FixOrgP = files.ReadInt(br)
+	obj.FixOrgD = files.ReadInt(br)
+	obj.FixOrgT = files.ReadInt(br)
+	obj.Body = files.ReadInt(br)
+	if files.ReadByte(br) != 'O' {
+		return nil, errObjFile
+	}
+	if _, err := br.Peek(1); err == nil {
+		name = files.ReadString(br)
+		for name != "" {
+			mf := orb.ModuleFingerprints{Module: ors.Ident(name), FPs: make(map[ors.Ident]int32)}
+			name = files.ReadString(br)
+			for name != "" {
+				mf.FPs[ors.Ident(name)] = files.ReadInt(br)
+				name = files.ReadString(br)
+			}
+			obj.Fingerprints = append(obj.Fingerprints, mf)
+			name = files.ReadString(br)
+		}
+	}
+	return obj, nil
+}
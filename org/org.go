@@ -6,9 +6,12 @@ package org
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
 	"io"
 	"math"
-	"os"
+	"slices"
 
 	"github.com/fzipp/oberon-compiler/files"
 	"github.com/fzipp/oberon-compiler/orb"
@@ -42,6 +45,7 @@ const (
 	classReg  orb.Class = 10
 	classRegI orb.Class = 11
 	classCond orb.Class = 12
+	classMeth orb.Class = 13
 )
 
 // frequently used opcodes
@@ -91,12 +95,35 @@ const (
 //	classReg     regno
 //	classRegI    regno  off     -
 //	classCond    cond   Fchain  Tchain
+//	classMeth    regno  -       n           (receiver in n registers, see Method)
+//
+// For an open array, dsc is the frame offset of the length of its first
+// dimension in the array descriptor; the lengths of the other open
+// dimensions follow. For an open array in a heap block (heap), allocated
+// by NewArray, the length is in the word before its elements instead.
+//
+// For an open array or record parameter of an enclosing procedure (see
+// outerItem), lev is the level of that procedure, whose frame holds the
+// array descriptor at dsc, or with tag set, the type tag at dsc. For any
+// variable or parameter of an enclosing procedure, outer is its object,
+// whose address is reloaded where it is not kept in a register.
 type Item struct {
-	Mode orb.Class
-	Type *orb.Type
-	A, B int32
-	r    int32
-	Rdo  bool // read only
+	Mode  orb.Class
+	Type  *orb.Type
+	A, B  int32
+	r     int32
+	dsc   int32
+	heap  bool
+	lev   int32
+	tag   bool
+	outer *orb.Object
+	Rdo   bool // read only
+}
+
+// Tagged reports whether x is a record parameter, which is passed with
+// the type tag of its dynamic type.
+func (x *Item) Tagged() bool {
+	return x.Mode == orb.ClassPar && x.Type.Form == orb.FormRecord || x.tag
 }
 
 // Generator
@@ -106,6 +133,7 @@ type Item struct {
 type Generator struct {
 	ors *ors.Scanner
 	orb *orb.Base
+	fs  files.FileSystem
 
 	PC      int32 // program counter
 	varSize int32 // data index
@@ -125,11 +153,35 @@ type Generator struct {
 	code   [maxCode]int32
 	data   [maxTD]int32 // type descriptors
 	str    [maxStrx]byte
+
+	globalTDs []*orb.Type // record types declared at the module level, see BuildTD
+	localTDs  []*orb.Type // record types declared in procedures, see BuildLocalTD
+
+	// Methods reserves a word before each type descriptor for the address
+	// of the method table of the type, see initMethods.
+	Methods bool
+
+	methodTabs map[*orb.Type]int32 // offsets of the method tables in the strings
+	constData  map[int32]int32     // sizes of the structured constants by offset in the strings
+
+	// OuterVars allows the access to variables of enclosing procedures
+	// through a display, see outerItem.
+	OuterVars bool
+
+	display        map[int32]int32 // offsets of the display entries in the strings, by level
+	accessed       map[int32]bool  // levels whose frames are accessed by nested procedures
+	dspLev, dspOff int32           // display entry set by EnterDisplay, restored by Return
+
+	// ROMFormat selects a ROM image that Close writes for RISC-0 modules
+	// (MODULE*) before the object file, see writeROM. ROMSize is the size
+	// of the image in words.
+	ROMFormat string
+	ROMSize   int32
 }
 
-func NewGenerator(s *ors.Scanner, b *orb.Base) *Generator {
+func NewGenerator(s *ors.Scanner, b *orb.Base, fsys files.FileSystem) *Generator {
 	return &Generator{
-		ors: s, orb: b,
+		ors: s, orb: b, fs: fsys,
 		relMap: [...]int32{1, 9, 5, 6, 14, 13},
 	}
 }
@@ -332,6 +384,8 @@ func (g *Generator) load(x *Item) {
 			g.put1(opMov, g.rh, 0, 0)
 			x.r = g.rh
 			g.incR()
+		} else if x.Mode == classMeth {
+			g.ors.Mark("method must be called")
 		}
 		x.Mode = classReg
 	}
@@ -389,7 +443,9 @@ func (g *Generator) loadTypTagAdr(t *orb.Type) {
 	var x Item
 	x.Mode = orb.ClassVar
 	x.A = t.Len
-	x.r = -t.Mno
+	if t.Mno > 0 {
+		x.r = -t.Mno // imported; the descriptors of local types are global
+	}
 	g.loadAdr(&x)
 }
 
@@ -438,21 +494,129 @@ func (g *Generator) MakeStringItem(x *Item, length int32) {
 	}
 }
 
+// MakeConstData places data, the value of a structured constant, with the
+// strings and returns its offset. Structured constants are read-only
+// variables there, see MakeItem.
+func (g *Generator) MakeConstData(data []byte) int32 {
+	off := g.strx
+	if g.strx+int32(len(data)) < maxStrx {
+		g.strx += int32(copy(g.str[g.strx:], data))
+		for g.strx%4 != 0 {
+			g.str[g.strx] = 0
+			g.strx++
+		}
+		g.constData[off] = g.strx - off
+	} else {
+		g.ors.Mark("too many strings")
+	}
+	return off
+}
+
+// StringData returns the characters of the string constant x, including
+// the terminating 0X.
+func (g *Generator) StringData(x *Item) []byte {
+	return slices.Clone(g.str[x.A : x.A+x.B])
+}
+
 func (g *Generator) MakeItem(x *Item, y *orb.Object, curLev int32) {
-	x.Mode = y.Class
-	x.Type = y.Type
-	x.A = y.Val
-	x.Rdo = y.Rdo
-	if y.Class == orb.ClassPar {
+	*x = Item{Mode: y.Class, Type: y.Type, A: y.Val, Rdo: y.Rdo}
+	if y.Class == orb.ClassConst && y.Type.Form >= orb.FormArray {
+		// structured constant, imported like a variable
+		x.Mode = orb.ClassVar
+		x.Rdo = true
+		x.r = y.Lev
+		if y.Lev == 0 {
+			x.A = g.varSize + y.Val
+		}
+	} else if y.Class == orb.ClassPar {
 		x.B = 0
+		if y.Type.Form == orb.FormArray && y.Type.Len < 0 {
+			x.dsc = y.Val + 4
+		}
 	} else if y.Class == orb.ClassConst && y.Type.Form == orb.FormString {
 		x.B = y.Lev // len
 	} else {
 		x.r = y.Lev
 	}
 	if (y.Lev > 0) && (y.Lev != curLev) && (y.Class != orb.ClassConst) {
-		g.ors.Mark("not accessible")
+		if g.OuterVars {
+			g.outerItem(x, y)
+		} else {
+			g.ors.Mark("not accessible")
+		}
+	}
+}
+
+// outerItem makes x address the variable or parameter y of an enclosing
+// procedure. The frame of the procedure at each level whose variables are
+// accessed by nested procedures is in its display entry, see EnterDisplay.
+// The descriptors of open array parameters and the type tags of record
+// parameters are loaded from that frame when needed, see loadFrameWord.
+func (g *Generator) outerItem(x *Item, y *orb.Object) {
+	g.loadOuterAdr(y)
+	if y.Class == orb.ClassPar {
+		x.A = 0
+		if y.Type.Form == orb.FormArray && y.Type.Len < 0 || y.Type.Form == orb.FormRecord {
+			x.lev = y.Lev
+			x.dsc = y.Val + 4
+			x.tag = y.Type.Form == orb.FormRecord
+		}
+	}
+	x.Mode = classRegI
+	x.r = g.rh
+	g.incR()
+	x.outer = y
+	g.accessed[y.Lev] = true
+}
+
+// loadOuterAdr loads the base address of the variable or parameter y of
+// an enclosing procedure into RH: the frame of the procedure for a
+// variable, the address of the argument for a parameter.
+func (g *Generator) loadOuterAdr(y *orb.Object) {
+	g.getSB(0)
+	g.put2(opLdr, g.rh, g.rh, g.displayAdr(y.Lev))
+	if y.Class == orb.ClassPar {
+		g.put2(opLdr, g.rh, g.rh, y.Val)
+	}
+}
+
+// reloadOuter loads the address of x, a variable or parameter of an
+// enclosing procedure, into a new register.
+func (g *Generator) reloadOuter(x *Item) {
+	g.loadOuterAdr(x.outer)
+	x.Mode = classRegI
+	x.r = g.rh
+	g.incR()
+}
+
+// loadFrameWord loads the word at offset off of the frame that holds the
+// array descriptor or type tag of x into RH: the current frame, or the
+// frame of an enclosing procedure for a parameter accessed through the
+// display.
+func (g *Generator) loadFrameWord(x *Item, off int32) {
+	if x.lev > 0 {
+		g.getSB(0)
+		g.put2(opLdr, g.rh, g.rh, g.displayAdr(x.lev))
+		g.put2(opLdr, g.rh, g.rh, off)
+	} else {
+		g.put2(opLdr, g.rh, sp, off+g.frame)
+	}
+}
+
+// displayAdr returns the address of the display entry for level lev,
+// which is allocated with the strings on first use.
+func (g *Generator) displayAdr(lev int32) int32 {
+	off, ok := g.display[lev]
+	if !ok {
+		if g.strx+4 >= maxStrx {
+			g.ors.Mark("too many strings")
+		} else {
+			off = g.strx
+			g.strx += 4
+		}
+		g.display[lev] = off
 	}
+	return g.varSize + off
 }
 
 // Code generation for Selectors, Variables, Constants
@@ -480,7 +644,12 @@ func (g *Generator) Index(x, y *Item) {
 		if (y.A < 0) || (y.A >= lim) {
 			g.ors.Mark("bad index")
 		}
-		if x.Mode == orb.ClassVar || x.Mode == classRegI {
+		if x.Mode == orb.ClassVar && x.r < 0 {
+			// imported, x.A is the entry number, as in Field
+			g.loadAdr(x)
+			x.Mode = classRegI
+			x.A = y.A * s
+		} else if x.Mode == orb.ClassVar || x.Mode == classRegI {
 			x.A = y.A*s + x.A
 		} else if x.Mode == orb.ClassPar {
 			x.B = y.A*s + x.B
@@ -491,17 +660,38 @@ func (g *Generator) Index(x, y *Item) {
 			// check array bounds
 			if lim >= 0 {
 				g.put1a(opCmp, g.rh, y.r, lim)
+			} else if x.heap {
+				g.put2(opLdr, g.rh, x.r, x.A-4)
+				g.put0(opCmp, g.rh, y.r, g.rh)
 			} else {
 				// open array
-				if x.Mode == orb.ClassVar || x.Mode == orb.ClassPar {
-					g.put2(opLdr, g.rh, sp, x.A+4+g.frame)
-					g.put0(opCmp, g.rh, y.r, g.rh)
-				} else {
-					g.ors.Mark("error in Index")
-				}
+				g.loadFrameWord(x, x.dsc)
+				g.put0(opCmp, g.rh, y.r, g.rh)
 			}
 			g.trap(10, 1) // BCC
 		}
+		if t := x.Type.Base; t.Form == orb.FormArray && t.Len < 0 {
+			// the elements are open arrays, multiply by the lengths of their dimensions
+			x.dsc += 4
+			off := x.dsc
+			for t.Base.Form == orb.FormArray && t.Base.Len < 0 {
+				g.loadFrameWord(x, off)
+				g.put0(opMul, y.r, y.r, g.rh)
+				off += 4
+				t = t.Base
+			}
+			// the size of the innermost dimension is rounded to words, as
+			// for arrays of fixed length
+			g.loadFrameWord(x, off)
+			s = t.Base.Size
+			if s%4 != 0 {
+				g.put1a(opMul, g.rh, g.rh, s)
+				g.put1(opAdd, g.rh, g.rh, 3)
+				g.put1(opAnd, g.rh, g.rh, -4)
+				s = 1
+			}
+			g.put0(opMul, y.r, y.r, g.rh)
+		}
 		if s == 4 {
 			g.put1(opLsl, y.r, y.r, 2)
 		} else if s > 1 {
@@ -563,6 +753,10 @@ func (g *Generator) DeRef(x *Item) {
 	x.Mode = classRegI
 	x.A = 0
 	x.B = 0
+	x.heap = x.Type.Form == orb.FormPointer && x.Type.Base.Form == orb.FormArray
+	if x.heap {
+		x.A = WordSize // the elements follow the length
+	}
 }
 
 func (g *Generator) q(t *orb.Type, dcw *int32) {
@@ -575,28 +769,26 @@ func (g *Generator) q(t *orb.Type, dcw *int32) {
 	}
 }
 
-func (g *Generator) findPtrFlds(typ *orb.Type, off int32, dcw *int32) {
+func (g *Generator) findPtrFlds(typ *orb.Type, off int32, offs []int32) []int32 {
 	if typ.Form == orb.FormPointer || typ.Form == orb.FormNilTyp {
-		g.data[*dcw] = off
-		*dcw++
+		offs = append(offs, off)
 	} else if typ.Form == orb.FormRecord {
 		fld := typ.Dsc
 		for fld != nil {
-			g.findPtrFlds(fld.Type, fld.Val+off, dcw)
+			offs = g.findPtrFlds(fld.Type, fld.Val+off, offs)
 			fld = fld.Next
 		}
 	} else if typ.Form == orb.FormArray {
 		s := typ.Base.Size
 		for i := int32(0); i < typ.Len; i++ {
-			g.findPtrFlds(typ.Base, i*s+off, dcw)
+			offs = g.findPtrFlds(typ.Base, i*s+off, offs)
 		}
 	}
+	return offs
 }
 
-func (g *Generator) BuildTD(t *orb.Type, dc *int32) {
-	// dcw = word address
-	dcw := *dc / 4
-	s := t.Size // convert size for heap allocation
+// heapSize converts the size of a record for heap allocation.
+func heapSize(s int32) int32 {
 	if s <= 24 {
 		s = 32
 	} else if s <= 56 {
@@ -606,8 +798,18 @@ func (g *Generator) BuildTD(t *orb.Type, dc *int32) {
 	} else {
 		s = (s + 263) / 256 * 256
 	}
+	return s
+}
+
+func (g *Generator) BuildTD(t *orb.Type, dc *int32) {
+	if g.Methods {
+		g.data[*dc/4] = 0 // address of the method table, set by the module body
+		*dc += 4
+	}
+	// dcw = word address
+	dcw := *dc / 4
 	t.Len = *dc // len used as address
-	g.data[dcw] = s
+	g.data[dcw] = heapSize(t.Size)
 	dcw++
 	k := t.NOfPar // extension level!
 	if k > 3 {
@@ -620,7 +822,10 @@ func (g *Generator) BuildTD(t *orb.Type, dc *int32) {
 			k++
 		}
 	}
-	g.findPtrFlds(t, 0, &dcw)
+	for _, off := range g.findPtrFlds(t, 0, nil) {
+		g.data[dcw] = off
+		dcw++
+	}
 	g.data[dcw] = -1
 	dcw++
 	g.tdx = dcw
@@ -629,6 +834,165 @@ func (g *Generator) BuildTD(t *orb.Type, dc *int32) {
 		g.ors.Mark("too many record types")
 		g.tdx = 0
 	}
+	g.globalTDs = append(g.globalTDs, t)
+}
+
+// BuildLocalTD builds the type descriptor of a record type declared in a
+// procedure. The global variables are allocated by then, so it is placed
+// with the strings, and len is used as its address as with BuildTD. The
+// loader fixes up only the extension tables at the start of the data, so
+// those of local types are set by the module body, see Header.
+func (g *Generator) BuildLocalTD(t *orb.Type) {
+	if slices.Contains(g.localTDs, t) {
+		return // declared again by an alias
+	}
+	var td []int32
+	if g.Methods {
+		td = append(td, 0) // address of the method table, set by the module body
+	}
+	td = append(td, heapSize(t.Size))
+	k := t.NOfPar
+	if k > 3 {
+		g.ors.Mark("ext level too large")
+	} else {
+		for i := int32(1); i <= 3; i++ {
+			if i <= k {
+				td = append(td, 0) // set by the module body
+			} else {
+				td = append(td, -1)
+			}
+		}
+	}
+	td = g.findPtrFlds(t, 0, td)
+	td = append(td, -1)
+	if g.strx+int32(len(td))*4 >= maxStrx {
+		g.ors.Mark("too many record types")
+		return
+	}
+	t.Len = g.varSize + g.strx + g.tdPrefix()
+	for _, w := range td {
+		binary.LittleEndian.PutUint32(g.str[g.strx:], uint32(w))
+		g.strx += 4
+	}
+	g.localTDs = append(g.localTDs, t)
+}
+
+// initLocalTDs sets the extension tables of the type descriptors of local
+// types to the addresses of the descriptors of their base types and their
+// own.
+func (g *Generator) initLocalTDs() {
+	for _, t := range g.localTDs {
+		for b := t; b.Base != nil; b = b.Base {
+			var x Item
+			x.Mode = orb.ClassVar
+			x.A = t.Len + b.NOfPar*4
+			g.loadAdr(&x)
+			g.loadTypTagAdr(b)
+			g.put2(opStr, g.rh-1, g.rh-2, 0)
+			g.rh -= 2
+		}
+	}
+}
+
+// tdPrefix returns the size of the words before each type descriptor.
+func (g *Generator) tdPrefix() int32 {
+	if g.Methods {
+		return 4
+	}
+	return 0
+}
+
+// initMethods allocates the method tables of the record types with
+// type-bound procedures with the strings, stores their addresses in the
+// words before the type descriptors, and fills them with the addresses of
+// the procedures of the module, or copies the entries of the tables of
+// imported base types, which are set up by the bodies of their modules.
+func (g *Generator) initMethods() {
+	if !g.Methods {
+		return
+	}
+	for _, t := range append(g.globalTDs, g.localTDs...) {
+		n := orb.NumMethods(t)
+		if n == 0 {
+			continue
+		}
+		if g.strx+n*4 >= maxStrx {
+			g.ors.Mark("too many strings")
+			return
+		}
+		g.methodTabs[t] = g.strx
+		var x Item
+		x.Mode = orb.ClassVar
+		x.A = g.varSize + g.strx
+		for range n {
+			binary.LittleEndian.PutUint32(g.str[g.strx:], 0)
+			g.strx += 4
+		}
+		g.loadAdr(&x)
+		g.loadTypTagAdr(t)
+		g.put2(opStr, x.r, g.rh-1, -4)
+		g.rh--
+		for i := int32(0); i < n; i++ {
+			b := t
+			for b != nil && b.Mno <= 0 && methodNo(b, i) == nil {
+				b = b.Base
+			}
+			if b == nil || b.Mno > 0 && i >= orb.NumMethods(b) {
+				continue // no procedure with this number, the entry is not used
+			}
+			if b.Mno <= 0 {
+				var y Item
+				m := methodNo(b, i)
+				y.Mode = orb.ClassConst
+				y.Type = m.Type
+				y.A = m.Dsc.Val
+				g.load(&y)
+			} else {
+				// imported base type
+				g.loadTypTagAdr(b)
+				g.put2(opLdr, g.rh-1, g.rh-1, -4)
+				g.put2(opLdr, g.rh-1, g.rh-1, i*4)
+			}
+			g.put2(opStr, g.rh-1, x.r, i*4)
+			g.rh--
+		}
+		g.rh--
+	}
+}
+
+// methodNo returns the type-bound procedure with method number mno bound
+// to the record type t, or nil.
+func methodNo(t *orb.Type, mno int32) *orb.Object {
+	for m := t.Methods; m != nil; m = m.Next {
+		if m.Val == mno {
+			return m
+		}
+	}
+	return nil
+}
+
+// TypeDesc returns the type descriptor built by BuildTD or BuildLocalTD for
+// a record type of the module being compiled: the size of the heap block,
+// the extension table of the base types (as fixups for the loader, or zeros
+// for local types) and the offsets of the pointer fields, without the
+// terminating -1.
+func (g *Generator) TypeDesc(t *orb.Type) []int32 {
+	if slices.Contains(g.localTDs, t) {
+		var td []int32
+		for i := t.Len - g.varSize; ; i += 4 {
+			w := int32(binary.LittleEndian.Uint32(g.str[i:]))
+			if w == -1 && len(td) >= 4 {
+				return td
+			}
+			td = append(td, w)
+		}
+	}
+	i := t.Len / 4
+	j := i + 4
+	for g.data[j] != -1 {
+		j++
+	}
+	return g.data[i:j]
 }
 
 func (g *Generator) TypeTest(x *Item, t *orb.Type, varPar, isGuard bool) {
@@ -640,7 +1004,9 @@ func (g *Generator) TypeTest(x *Item, t *orb.Type, varPar, isGuard bool) {
 	} else {
 		var pc0 int32
 		// fetch tag into RH
-		if varPar {
+		if x.tag {
+			g.loadFrameWord(x, x.dsc)
+		} else if varPar {
 			g.put2(opLdr, g.rh, sp, x.A+4+g.frame)
 		} else {
 			g.load(x)
@@ -662,7 +1028,7 @@ func (g *Generator) TypeTest(x *Item, t *orb.Type, varPar, isGuard bool) {
 			}
 		} else {
 			g.setCC(x, opEQ)
-			if !varPar {
+			if !varPar || x.tag {
 				g.rh--
 			}
 		}
@@ -1116,16 +1482,28 @@ func (g *Generator) StoreStruct(x, y *Item) {
 	if y.Type.Size != 0 {
 		g.loadAdr(x)
 		g.loadAdr(y)
+		words, tail := int32(1), int32(0) // words copied by the loop, bytes after it
 		if (x.Type.Form == orb.FormArray) && (x.Type.Len > 0) {
 			if y.Type.Len >= 0 {
-				if x.Type.Size == y.Type.Size {
-					g.put1a(opMov, g.rh, 0, (y.Type.Size+3)/4)
+				if y.Type.Len == x.Type.Len {
+					words = (y.Type.Size + 3) / 4
+				} else if y.Type.Len < x.Type.Len {
+					// y shorter than x: the elements beyond LEN(y) are kept
+					n := y.Type.Len * y.Type.Base.Size
+					words, tail = n/4, n%4
 				} else {
-					g.ors.Mark("different length/size, not implemented")
+					g.ors.Mark("source array longer than destination")
+				}
+				if words > 0 {
+					g.put1a(opMov, g.rh, 0, words)
 				}
 			} else {
 				// y  open array
-				g.put2(opLdr, g.rh, sp, y.A+4)
+				if y.heap {
+					g.put2(opLdr, g.rh, y.r, -4)
+				} else {
+					g.loadFrameWord(y, y.dsc)
+				}
 				s := y.Type.Base.Size // element size
 				pc0 := g.PC
 				g.put3(opBC, opEQ, 0)
@@ -1147,12 +1525,18 @@ func (g *Generator) StoreStruct(x, y *Item) {
 		} else {
 			g.ors.Mark("inadmissible assignment")
 		}
-		g.put2(opLdr, g.rh+1, y.r, 0)
-		g.put1(opAdd, y.r, y.r, 4)
-		g.put2(opStr, g.rh+1, x.r, 0)
-		g.put1(opAdd, x.r, x.r, 4)
-		g.put1(opSub, g.rh, g.rh, 1)
-		g.put3(opBC, opNE, -6)
+		if words > 0 {
+			g.put2(opLdr, g.rh+1, y.r, 0)
+			g.put1(opAdd, y.r, y.r, 4)
+			g.put2(opStr, g.rh+1, x.r, 0)
+			g.put1(opAdd, x.r, x.r, 4)
+			g.put1(opSub, g.rh, g.rh, 1)
+			g.put3(opBC, opNE, -6)
+		}
+		for i := range tail {
+			g.put2(opLdr+1, g.rh+1, y.r, i)
+			g.put2(opStr+1, g.rh+1, x.r, i)
+		}
 	}
 	g.rh = 0
 }
@@ -1167,7 +1551,11 @@ func (g *Generator) CopyString(x, y *Item) {
 		}
 	} else if g.check {
 		// open array len, frame = 0
-		g.put2(opLdr, g.rh, sp, x.A+4)
+		if x.heap {
+			g.put2(opLdr, g.rh, x.r, -4)
+		} else {
+			g.loadFrameWord(x, x.dsc)
+		}
 		g.put1(opCmp, g.rh, g.rh, y.B)
 		g.trap(opLT, 3)
 	}
@@ -1183,14 +1571,30 @@ func (g *Generator) CopyString(x, y *Item) {
 
 // Code generation for parameters
 
-func (g *Generator) OpenArrayParam(x *Item) {
+func (g *Generator) OpenArrayParam(x *Item, fType *orb.Type) {
 	g.loadAdr(x)
-	if x.Type.Len >= 0 {
-		g.put1a(opMov, g.rh, 0, x.Type.Len)
-	} else {
-		g.put2(opLdr, g.rh, sp, x.A+4+g.frame)
+	g.arrayLens(x, fType)
+}
+
+// arrayLens loads the lengths of the dimensions of the array x that are
+// open in the formal parameter type fType. With the address of x they form
+// the array descriptor of the parameter.
+func (g *Generator) arrayLens(x *Item, fType *orb.Type) {
+	t := x.Type
+	off := x.dsc
+	for fType.Form == orb.FormArray && fType.Len < 0 {
+		if t.Len >= 0 {
+			g.put1a(opMov, g.rh, 0, t.Len)
+		} else if x.heap {
+			g.put2(opLdr, g.rh, x.r, -4)
+		} else {
+			g.loadFrameWord(x, off)
+			off += 4
+		}
+		g.incR()
+		fType = fType.Base
+		t = t.Base
 	}
-	g.incR()
 }
 
 func (g *Generator) VarParam(x *Item, fType *orb.Type) {
@@ -1198,14 +1602,12 @@ func (g *Generator) VarParam(x *Item, fType *orb.Type) {
 	g.loadAdr(x)
 	if (fType.Form == orb.FormArray) && (fType.Len < 0) {
 		// open array
-		if x.Type.Len >= 0 {
-			g.put1a(opMov, g.rh, 0, x.Type.Len)
-		} else {
-			g.put2(opLdr, g.rh, sp, x.A+4+g.frame)
-		}
-		g.incR()
+		g.arrayLens(x, fType)
 	} else if fType.Form == orb.FormRecord {
-		if xmd == orb.ClassPar {
+		if x.tag {
+			g.loadFrameWord(x, x.dsc)
+			g.incR()
+		} else if xmd == orb.ClassPar {
 			g.put2(opLdr, g.rh, sp, x.A+4+g.frame)
 			g.incR()
 		} else {
@@ -1224,10 +1626,29 @@ func (g *Generator) StringParam(x *Item) {
 	g.incR() // len
 }
 
+// RecordReceiver loads the record x as receiver of a type-bound procedure,
+// its address and type tag. If x is dereferenced, the tag is that of the
+// heap block, otherwise that of the static type of x.
+func (g *Generator) RecordReceiver(x *Item, deref bool) {
+	g.loadAdr(x)
+	if deref {
+		g.put2(opLdr, g.rh, x.r, -8)
+		g.incR()
+	} else {
+		g.loadTypTagAdr(x.Type)
+	}
+}
+
 // For Statements
 
 func (g *Generator) For0(x, y *Item) {
 	g.load(y)
+	if x.outer != nil {
+		// the address of x is not kept during the loop, see For1 and For2
+		g.put0(opMov, x.r, 0, y.r)
+		y.r = x.r
+		g.rh = y.r + 1
+	}
 }
 
 func (g *Generator) For1(x, y, z, w *Item) (L int32) {
@@ -1247,11 +1668,17 @@ func (g *Generator) For1(x, y, z, w *Item) (L int32) {
 		g.ors.Mark("zero increment")
 		g.put3(opBC, opMI, 0)
 	}
+	if x.outer != nil {
+		g.reloadOuter(x)
+	}
 	g.Store(x, y)
 	return L
 }
 
 func (g *Generator) For2(x, y, w *Item) {
+	if x.outer != nil {
+		g.reloadOuter(x)
+	}
 	g.load(x)
 	g.rh--
 	g.put1a(opAdd, x.r, x.r, w.A)
@@ -1324,8 +1751,48 @@ func (g *Generator) restoreRegs(r int32) {
 	g.frame -= 4 * r
 }
 
+// Method makes x the type-bound procedure with method number mno, whose
+// receiver is loaded into the top n registers: a pointer (n = 1), or the
+// address and the type tag of a record (n = 2). The address of the procedure
+// is taken from the method table of the type of the receiver, or, for super
+// calls, it is the procedure proc of the module or the entry of the method
+// table of the imported record type td.
+func (g *Generator) Method(x *Item, n, mno int32, proc *orb.Object, td *orb.Type) {
+	r := g.rh - n
+	if proc != nil {
+		var y Item
+		y.Mode = orb.ClassConst
+		y.Type = proc.Type
+		y.A = proc.Val
+		g.load(&y)
+	} else {
+		tag := g.rh
+		if td != nil {
+			g.loadTypTagAdr(td)
+			g.rh--
+		} else if n == 1 {
+			if g.check {
+				g.put1(opCmp, r, r, 0)
+				g.trap(opEQ, 4)
+			}
+			g.put2(opLdr, tag, r, -8)
+		} else {
+			tag = r + 1
+		}
+		g.put2(opLdr, g.rh, tag, -4) // method table
+		g.put2(opLdr, g.rh, g.rh, mno*4)
+		g.incR()
+	}
+	x.Mode = classMeth
+	x.r = r
+	x.B = n
+}
+
 func (g *Generator) PrepCall(x *Item) (r int32) {
 	// x.Type.Form == FormProc
+	if x.Mode == classMeth {
+		return g.prepMethodCall(x)
+	}
 	if x.Mode > orb.ClassPar {
 		g.load(x)
 	}
@@ -1337,6 +1804,26 @@ func (g *Generator) PrepCall(x *Item) (r int32) {
 	return r
 }
 
+// prepMethodCall saves the registers below the receiver of the type-bound
+// procedure x, pushes the address of the procedure to be popped by Call,
+// and moves the receiver to the first registers.
+func (g *Generator) prepMethodCall(x *Item) (r int32) {
+	r = x.r
+	if r > 0 {
+		g.saveRegs(r)
+	}
+	g.put1(opSub, sp, sp, 4)
+	g.put2(opStr, g.rh-1, sp, 0)
+	g.frame += 4
+	if r > 0 {
+		for i := int32(0); i < x.B; i++ {
+			g.put0(opMov, i, 0, r+i)
+		}
+	}
+	g.rh = x.B
+	return r + 1
+}
+
 func (g *Generator) Call(x *Item, r int32) {
 	// x.Type.Form == FormProc
 	if x.Mode == orb.ClassConst {
@@ -1405,10 +1892,39 @@ func (g *Generator) Enter(parBlkSize, locBlkSize int32, interrupt bool) {
 	}
 }
 
+// FrameAccessed reports whether the variables of the procedure at level lev
+// are accessed by its nested procedures, which are compiled before its body.
+func (g *Generator) FrameAccessed(lev int32) bool {
+	accessed := g.accessed[lev]
+	delete(g.accessed, lev)
+	return accessed
+}
+
+// EnterDisplay is called after Enter for a procedure whose variables are
+// accessed by nested procedures. It saves the display entry for level lev
+// at off in the frame and sets it to the frame; Return restores it.
+func (g *Generator) EnterDisplay(lev, off int32) {
+	adr := g.displayAdr(lev)
+	g.getSB(0)
+	g.put2(opLdr, g.rh+1, g.rh, adr)
+	g.put2(opStr, g.rh+1, sp, off)
+	g.put2(opStr, sp, g.rh, adr)
+	g.dspLev = lev
+	g.dspOff = off
+}
+
 func (g *Generator) Return(form orb.Form, x *Item, size int32, interrupt bool) {
 	if form != orb.FormNoTyp {
 		g.load(x)
 	}
+	if g.dspLev != 0 {
+		// keep R0, which holds a result loaded here or by Result
+		g.rh = 1
+		g.getSB(0)
+		g.put2(opLdr, g.rh+1, sp, g.dspOff)
+		g.put2(opStr, g.rh+1, g.rh, g.displayAdr(g.dspLev))
+		g.dspLev = 0
+	}
 	if !interrupt {
 		// procedure epilog
 		g.put2(opLdr, lnk, sp, 0)
@@ -1425,6 +1941,26 @@ func (g *Generator) Return(form orb.Form, x *Item, size int32, interrupt bool) {
 	g.rh = 0
 }
 
+// Result loads the result x of a RETURN statement before the end of the
+// procedure (-dialect oberon90), unless form is FormNoTyp, and jumps to the
+// end, linked in L, where Return is called with FormNoTyp. With L nil the
+// statement is at the end.
+func (g *Generator) Result(form orb.Form, x *Item, L *int32) {
+	if form != orb.FormNoTyp {
+		g.load(x)
+		g.rh--
+	}
+	if L != nil {
+		g.FJump(L)
+	}
+}
+
+// Trap emits an unconditional trap num, such as a type guard trap for a
+// WITH statement without ELSE that has no matching branch.
+func (g *Generator) Trap(num int32) {
+	g.trap(7, num)
+}
+
 // In-line code procedures
 
 func (g *Generator) Increment(upOrDown int32, x, y *Item) {
@@ -1522,6 +2058,99 @@ func (g *Generator) New(x *Item) {
 	g.rh = 0
 }
 
+// NewArray allocates an array of y elements for the pointer x to an open
+// array, NEW(x, y). Like New, it calls Kernel.New with trap 0, with the
+// address of x in R0 and a type tag in R1, here the address of a word on
+// the stack with the size of the heap block. The block holds the length,
+// the elements, which x points to, and a descriptor with the layout of a
+// type descriptor: the size of the block, an empty extension table and the
+// offsets of the pointers in all elements, ended by -1. After the
+// allocation the descriptor is filled in and becomes the type tag of the
+// block, so that the garbage collector marks, traces and frees the array
+// like a record.
+//
+//	x-8  tag = x+d      x      length n
+//	x-4  mark           x+4    elements, n*size
+//	                    x+d    descriptor, d = (4 + n*size) rounded to words
+//
+// The block size is rounded like the sizes of records, see heapSize, as
+// Kernel.New allocates blocks of 32, 64 and 128 bytes and multiples of
+// 256 bytes, and the garbage collector takes the size from the tag.
+func (g *Generator) NewArray(x, y *Item) {
+	t := x.Type.Base.Base // element type
+	offs := g.findPtrFlds(t, 0, nil)
+	g.loadAdr(x)
+	g.load(y)
+	g.put1(opCmp, y.r, y.r, 0)
+	g.trap(opLT, 1)
+	r := g.rh
+	g.rh += 2 // r, and r+1 for comparisons
+	g.put1(opSub, sp, sp, 16)
+	g.put2(opStr, x.r, sp, 0)
+	g.put2(opStr, y.r, sp, 4)
+	// offset of the descriptor
+	g.put1a(opMul, r, y.r, t.Size)
+	g.put1(opAdd, r, r, 7)
+	g.put1(opAnd, r, r, -4)
+	g.put2(opStr, r, sp, 12)
+	// block size: header, length, elements and descriptor
+	if len(offs) > 0 {
+		g.put1a(opMul, y.r, y.r, int32(len(offs))*4)
+		g.put0(opAdd, r, r, y.r)
+	}
+	g.put1(opAdd, r, r, 28)
+	L := int32(0)
+	for _, size := range []int32{32, 64, 128} {
+		g.put1(opCmp, r+1, r, size)
+		g.put3(opBC, opGT, 2)
+		g.put1(opMov, r, 0, size)
+		g.FJump(&L)
+	}
+	g.put1(opAdd, r, r, 255)
+	g.put1(opAnd, r, r, -256)
+	g.FixLink(L)
+	g.put2(opStr, r, sp, 8)
+	if x.r != 0 {
+		g.put0(opMov, 0, 0, x.r)
+	}
+	g.put1(opAdd, 1, sp, 8)
+	g.trap(7, 0)
+	// the registers are not preserved by Kernel.New
+	g.rh = 5
+	g.put2(opLdr, 0, sp, 0)
+	g.put2(opLdr, 0, 0, 0)
+	Lnil := g.PC
+	g.put3(opBC, opEQ, 0) // NIL, no memory
+	g.put2(opLdr, 1, sp, 4)
+	g.put2(opStr, 1, 0, 0)
+	g.put2(opLdr, 2, sp, 12)
+	g.put0(opAdd, 2, 0, 2)
+	g.put2(opLdr, 3, sp, 8)
+	g.put2(opStr, 3, 2, 0)
+	g.put2(opStr, 2, 0, -8)
+	g.put1(opAdd, 2, 2, 16)
+	if len(offs) > 0 {
+		g.put1(opMov, 3, 0, WordSize) // offset of the element
+		L0 := g.PC
+		g.put1(opSub, 1, 1, 1)
+		L1 := g.PC
+		g.put3(opBC, opMI, 0)
+		for _, off := range offs {
+			g.put1a(opAdd, 4, 3, off)
+			g.put2(opStr, 4, 2, 0)
+			g.put1(opAdd, 2, 2, 4)
+		}
+		g.put1a(opAdd, 3, 3, t.Size)
+		g.BJump(L0)
+		g.FixLink(L1)
+	}
+	g.put1(opMov, 3, 0, -1)
+	g.put2(opStr, 3, 2, 0)
+	g.FixLink(Lnil)
+	g.put1(opAdd, sp, sp, 16)
+	g.rh = 0
+}
+
 func (g *Generator) Pack(x, y *Item) {
 	z := *x
 	g.load(x)
@@ -1662,16 +2291,27 @@ func (g *Generator) Ord(x *Item) {
 	}
 }
 
-func (g *Generator) Len(x *Item) {
-	if x.Type.Len >= 0 {
-		if x.Mode == classRegI {
-			g.rh--
-		}
+// Len loads the length of the dimension dim of the array x, counted
+// from 0.
+func (g *Generator) Len(x *Item, dim int32) {
+	t := x.Type
+	for i := int32(0); i < dim; i++ {
+		t = t.Base
+	}
+	if x.Mode == classRegI {
+		g.rh--
+	}
+	if t.Len >= 0 {
 		x.Mode = orb.ClassConst
-		x.A = x.Type.Len
+		x.A = t.Len
+	} else if x.heap {
+		g.put2(opLdr, g.rh, x.r, x.A-4)
+		x.Mode = classReg
+		x.r = g.rh
+		g.incR()
 	} else {
 		// open array
-		g.put2(opLdr, g.rh, sp, x.A+4+g.frame)
+		g.loadFrameWord(x, x.dsc+4*dim)
 		x.Mode = classReg
 		x.r = g.rh
 		g.incR()
@@ -1771,6 +2411,13 @@ func (g *Generator) Open(v int32) {
 	g.PC = 0
 	g.tdx = 0
 	g.strx = 0
+	g.globalTDs = nil
+	g.localTDs = nil
+	g.methodTabs = make(map[*orb.Type]int32)
+	g.constData = make(map[int32]int32)
+	g.display = make(map[int32]int32)
+	g.accessed = make(map[int32]bool)
+	g.dspLev = 0
 	g.rh = 0
 	g.fixOrgP = 0
 	g.fixOrgD = 0
@@ -1799,6 +2446,8 @@ func (g *Generator) Header() {
 		g.put1(opSub, sp, sp, 4)
 		g.put2(opStr, lnk, sp, 0)
 	}
+	g.initLocalTDs()
+	g.initMethods()
 }
 
 func (g *Generator) nOfPtrs(typ *orb.Type) (n int32) {
@@ -1846,6 +2495,12 @@ func (g *Generator) Close(modId ors.Ident, key, nOfEnt int32) {
 		g.put1(opAdd, sp, sp, 4)
 		g.put3(opBR, 7, lnk)
 	}
+	if g.version == 0 && g.ROMFormat != "" {
+		if !g.checkROM() {
+			return
+		}
+		g.writeROM(modId)
+	}
 	obj := g.orb.TopScope.Next
 	nOfImps := 0
 	comSize := 4
@@ -1871,11 +2526,10 @@ func (g *Generator) Close(modId ors.Ident, key, nOfEnt int32) {
 
 	// write code file
 	name := string(modId) + ".rsc"
-	f, err := os.Create(name)
+	f, err := g.fs.Create(name)
 	if err != nil {
 		panic(err)
 	}
-	defer f.Close()
 	w := bufio.NewWriter(f)
 	files.WriteString(w, string(modId))
 	files.WriteInt(w, key)
@@ -1929,6 +2583,8 @@ func (g *Generator) Close(modId ors.Ident, key, nOfEnt int32) {
 		if obj.ExNo != 0 {
 			if ((obj.Class == orb.ClassConst) && (obj.Type.Form == orb.FormProc)) || (obj.Class == orb.ClassVar) {
 				files.WriteInt(w, obj.Val)
+			} else if (obj.Class == orb.ClassConst) && (obj.Type.Form >= orb.FormArray) {
+				files.WriteInt(w, g.varSize+obj.Val)
 			} else if obj.Class == orb.ClassTyp {
 				if obj.Type.Form == orb.FormRecord {
 					files.WriteInt(w, obj.Type.Len%0x10000)
@@ -1953,10 +2609,122 @@ func (g *Generator) Close(modId ors.Ident, key, nOfEnt int32) {
 	files.WriteInt(w, g.fixOrgT)
 	files.WriteInt(w, g.entry)
 	files.Write(w, 'O')
+	// fingerprints of the imported objects used, after the end of the
+	// object file as read by the module loader, see orb.UsedFingerprints
+	if fps := g.orb.UsedFingerprints(); len(fps) > 0 {
+		for _, mf := range fps {
+			files.WriteString(w, string(mf.Module))
+			names := make([]ors.Ident, 0, len(mf.FPs))
+			for name := range mf.FPs {
+				names = append(names, name)
+			}
+			slices.Sort(names)
+			for _, name := range names {
+				files.WriteString(w, string(name))
+				files.WriteInt(w, mf.FPs[name])
+			}
+			files.Write(w, 0)
+		}
+		files.Write(w, 0)
+	}
 	err = w.Flush()
 	if err != nil {
 		panic(err)
 	}
+	err = f.Close()
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Formats of the ROM images, see Generator.ROMFormat.
+const (
+	ROMMem = "mem" // one word per line in hex, as loaded by Verilog $readmemh
+	ROMHex = "hex" // Intel HEX with byte addresses, words in little-endian order
+	ROMBin = "bin" // raw binary, words in little-endian order
+)
+
+// checkROM reports whether the code of a RISC-0 module fits into a ROM
+// image of ROMSize words. Code that depends on the module loader is
+// rejected: fixups for imported procedures, type descriptors and strings,
+// which are not part of the image.
+func (g *Generator) checkROM() bool {
+	if g.fixOrgP != 0 || g.fixOrgD != 0 || g.fixOrgT != 0 {
+		g.ors.Mark("ROM code needs loader fixups")
+	} else if g.tdx != 0 || len(g.localTDs) != 0 {
+		g.ors.Mark("ROM code cannot have type descriptors")
+	} else if g.strx != 0 {
+		g.ors.Mark("ROM code cannot have strings")
+	} else if g.PC > g.ROMSize {
+		g.ors.Mark("code too large for ROM")
+	} else {
+		return true
+	}
+	return false
+}
+
+// writeROM writes the code of a RISC-0 module as an image for the boot
+// ROM or block RAM of the FPGA, padded with zeros to ROMSize words.
+// The name of the file is the module name with the format as extension.
+func (g *Generator) writeROM(modId ors.Ident) {
+	f, err := g.fs.Create(string(modId) + "." + g.ROMFormat)
+	if err != nil {
+		panic(err)
+	}
+	w := bufio.NewWriter(f)
+	rom := make([]int32, g.ROMSize)
+	copy(rom, g.code[:g.PC])
+	switch g.ROMFormat {
+	case ROMMem:
+		for _, x := range rom {
+			_, err = fmt.Fprintf(w, "%08X\n", uint32(x))
+			if err != nil {
+				panic(err)
+			}
+		}
+	case ROMHex:
+		writeIntelHex(w, rom)
+	case ROMBin:
+		for _, x := range rom {
+			files.WriteInt(w, x)
+		}
+	}
+	err = w.Flush()
+	if err != nil {
+		panic(err)
+	}
+	err = f.Close()
+	if err != nil {
+		panic(err)
+	}
+}
+
+func writeIntelHex(w io.Writer, rom []int32) {
+	record := func(typ byte, adr int, data []byte) {
+		sum := byte(len(data)) + byte(adr>>8) + byte(adr) + typ
+		line := fmt.Sprintf(":%02X%04X%02X", len(data), adr&0xFFFF, typ)
+		for _, b := range data {
+			line += fmt.Sprintf("%02X", b)
+			sum += b
+		}
+		_, err := fmt.Fprintf(w, "%s%02X\n", line, -sum)
+		if err != nil {
+			panic(err)
+		}
+	}
+	buf := &bytes.Buffer{}
+	for _, x := range rom {
+		files.WriteInt(buf, x)
+	}
+	data := buf.Bytes()
+	for adr := 0; adr < len(data); adr += 16 {
+		if adr > 0 && adr%0x10000 == 0 {
+			// extended linear address
+			record(4, 0, []byte{byte(adr >> 24), byte(adr >> 16)})
+		}
+		record(0, adr, data[adr:min(adr+16, len(data))])
+	}
+	record(1, 0, nil) // end of file
 }
 
 func log2(m int32, e *int32) int32 {
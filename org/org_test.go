@@ -0,0 +1,654 @@
+package org_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/fzipp/oberon-compiler/orp"
+)
+
+func TestStoreShorterArray(t *testing.T) {
+	src := `MODULE T; IMPORT SYSTEM;
+VAR
+  a3: ARRAY 8 OF CHAR; b3: ARRAY 3 OF CHAR;
+  a6: ARRAY 12 OF BYTE; b6: ARRAY 6 OF BYTE;
+  a4: ARRAY 8 OF CHAR; b4: ARRAY 4 OF CHAR;
+  ai: ARRAY 4 OF INTEGER; bi: ARRAY 2 OF INTEGER;
+  i: INTEGER;
+
+BEGIN
+  FOR i := 0 TO 7 DO a3[i] := "x"; a4[i] := "x" END;
+  FOR i := 0 TO 11 DO a6[i] := 255 END;
+  FOR i := 0 TO 3 DO ai[i] := -1 END;
+  FOR i := 0 TO 2 DO b3[i] := CHR(ORD("a") + i) END;
+  FOR i := 0 TO 5 DO b6[i] := i END;
+  FOR i := 0 TO 3 DO b4[i] := CHR(ORD("a") + i) END;
+  bi[0] := 10; bi[1] := 20;
+  (* padding bytes after the elements *)
+  SYSTEM.PUT(SYSTEM.ADR(b3) + 3, 70X);
+  SYSTEM.PUT(SYSTEM.ADR(b6) + 6, 77X); SYSTEM.PUT(SYSTEM.ADR(b6) + 7, 77X);
+  a3 := b3; a6 := b6; a4 := b4; ai := bi
+END T.`
+	m, varOffset := runModule(t, src, orp.Options{})
+	bytesOf := func(name string, n int) string {
+		off := modAdr + varOffset(name)
+		return string(m.mem[off : off+int32(n)])
+	}
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"a3", "abcxxxxx"},
+		{"a6", "\x00\x01\x02\x03\x04\x05\xff\xff\xff\xff\xff\xff"},
+		{"a4", "abcdxxxx"},
+		{"ai", "\x0a\x00\x00\x00\x14\x00\x00\x00\xff\xff\xff\xff\xff\xff\xff\xff"},
+	}
+	for _, tt := range tests {
+		if got := bytesOf(tt.name, len(tt.want)); got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestOuterParams(t *testing.T) {
+	src := `MODULE T;
+TYPE
+  R = RECORD a: INTEGER END;
+  R1 = RECORD (R) b: INTEGER END;
+VAR
+  r1: R1; arr: ARRAY 5 OF INTEGER; m: ARRAY 2, 3 OF INTEGER;
+  isR1, b, len, sum, len2, elem, incr: INTEGER;
+
+PROCEDURE Sum(v: ARRAY OF INTEGER): INTEGER;
+  VAR i, s: INTEGER;
+BEGIN s := 0;
+  FOR i := 0 TO LEN(v) - 1 DO s := s + v[i] END
+  RETURN s
+END Sum;
+
+PROCEDURE Inc(VAR r: R);
+BEGIN INC(r.a);
+  IF r IS R1 THEN INC(r(R1).b) END
+END Inc;
+
+PROCEDURE P(VAR r: R; VAR v: ARRAY OF INTEGER; w: ARRAY OF ARRAY OF INTEGER);
+  PROCEDURE Q;
+    VAR i: INTEGER;
+  BEGIN
+    r.a := 7;
+    IF r IS R1 THEN isR1 := 1; b := r(R1).b END;
+    len := LEN(v);
+    FOR i := 0 TO LEN(v) - 1 DO v[i] := i * 2 END;
+    sum := Sum(v);
+    len2 := LEN(w[0]);
+    elem := w[1, 2];
+    Inc(r)
+  END Q;
+BEGIN Q
+END P;
+
+BEGIN
+  r1.b := 40;
+  m[1, 2] := 12;
+  P(r1, arr, m);
+  incr := r1.a * 100 + r1.b
+END T.`
+	m, varOffset := runModule(t, src, orp.Options{Extensions: []string{orp.ExtOuterVars}})
+	tests := []struct {
+		name string
+		want int32
+	}{
+		{"isR1", 1},
+		{"b", 40},
+		{"len", 5},
+		{"sum", 20},
+		{"len2", 3},
+		{"elem", 12},
+		{"incr", 841},
+	}
+	for _, tt := range tests {
+		if got := m.global(varOffset(tt.name)); got != tt.want {
+			t.Errorf("%s = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestOuterForVariable(t *testing.T) {
+	src := `MODULE T;
+VAR sum, last, n: INTEGER;
+
+PROCEDURE P(VAR k: INTEGER);
+  VAR i: INTEGER;
+  PROCEDURE Q;
+    VAR j: INTEGER;
+  BEGIN
+    FOR i := 1 TO 10 DO sum := sum + i END;
+    last := i;
+    FOR k := 10 TO 1 BY -3 DO j := k; n := n + 1 END;
+    FOR i := i TO 12 DO sum := sum + i * 100 END
+  END Q;
+BEGIN Q
+END P;
+
+BEGIN P(last)
+END T.`
+	m, varOffset := runModule(t, src, orp.Options{Extensions: []string{orp.ExtOuterVars}})
+	tests := []struct {
+		name string
+		want int32
+	}{
+		{"sum", 55 + 3300},
+		{"last", 1},
+		{"n", 4},
+	}
+	for _, tt := range tests {
+		if got := m.global(varOffset(tt.name)); got != tt.want {
+			t.Errorf("%s = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReturnRestoresDisplay(t *testing.T) {
+	src := `MODULE T;
+VAR early, late, plain: INTEGER;
+
+PROCEDURE F(n: INTEGER): INTEGER;
+  VAR k: INTEGER;
+  PROCEDURE Inc;
+  BEGIN k := k + n
+  END Inc;
+BEGIN k := 10; Inc;
+  IF n > 0 THEN RETURN k * 2 END;
+  Inc
+  RETURN k + 1
+END F;
+
+PROCEDURE G(n: INTEGER): INTEGER;
+  VAR k: INTEGER;
+  PROCEDURE Set;
+  BEGIN k := n
+  END Set;
+BEGIN Set;
+  RETURN k + 5
+END G;
+
+BEGIN
+  early := F(3);
+  late := F(-2);
+  plain := G(37)
+END T.`
+	opts := orp.Options{Dialect: orp.DialectOberon90, Extensions: []string{orp.ExtOuterVars}}
+	m, varOffset := runModule(t, src, opts)
+	tests := []struct {
+		name string
+		want int32
+	}{
+		{"early", 26},
+		{"late", 7},
+		{"plain", 42},
+	}
+	for _, tt := range tests {
+		if got := m.global(varOffset(tt.name)); got != tt.want {
+			t.Errorf("%s = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMissingReturn(t *testing.T) {
+	src := `MODULE T;
+VAR x: INTEGER;
+
+PROCEDURE NoRet(n: INTEGER): INTEGER;
+BEGIN IF n > 0 THEN RETURN 7 END
+END NoRet;
+
+BEGIN x := NoRet(1); x := NoRet(-1)
+END T.`
+	obj, vars := compile(t, src, orp.Options{Dialect: orp.DialectOberon90})
+	m := &machine{}
+	err := m.run(obj)
+	if err == nil || !strings.Contains(err.Error(), "trap 8") {
+		t.Errorf("NoRet(-1): got error %v, want trap 8", err)
+	}
+	if got := m.global(vars["x"]); got != 7 {
+		t.Errorf("NoRet(1) = %d, want 7", got)
+	}
+}
+
+func TestNewArray(t *testing.T) {
+	src := `MODULE T;
+TYPE
+  Node = POINTER TO NodeDesc;
+  NodeDesc = RECORD val: INTEGER; next: Node END;
+  Nodes = POINTER TO ARRAY OF Node;
+  Chars = POINTER TO ARRAY OF CHAR;
+  R = RECORD c: CHAR; n: Node END;
+  Recs = POINTER TO ARRAY OF R;
+  Holder = POINTER TO HolderDesc;
+  HolderDesc = RECORD chars: Chars END;
+VAR
+  nodes: Nodes; chars, empty: Chars; recs: Recs; h: Holder;
+  i, len, sum: INTEGER;
+
+BEGIN
+  NEW(chars, 5);
+  FOR i := 0 TO 4 DO chars[i] := CHR(ORD("a") + i) END;
+  NEW(empty, 0);
+  NEW(nodes, 40);
+  FOR i := 0 TO 39 DO NEW(nodes[i]); nodes[i].val := i END;
+  NEW(recs, 3); NEW(recs[2].n); recs[2].n.val := 100;
+  NEW(h); NEW(h.chars, 300);
+  len := LEN(nodes^) + LEN(chars^) + LEN(empty^) + LEN(h.chars^);
+  sum := recs[2].n.val;
+  FOR i := 0 TO 39 DO INC(sum, nodes[i].val) END
+END T.`
+	obj, vars := compile(t, src, orp.Options{Extensions: []string{orp.ExtDynArrays}})
+	m := &machine{}
+	err := m.run(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := m.global(vars["len"]), int32(40+5+0+300); got != want {
+		t.Errorf("len = %d, want %d", got, want)
+	}
+	if got, want := m.global(vars["sum"]), int32(100+780); got != want {
+		t.Errorf("sum = %d, want %d", got, want)
+	}
+	chars := m.global(vars["chars"])
+	if got := string(m.mem[chars+4 : chars+9]); got != "abcde" {
+		t.Errorf("chars = %q, want %q", got, "abcde")
+	}
+	n, err := m.scan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	marked, err := m.mark(obj.Ptrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// chars, empty, nodes and its 40 nodes, recs and a node, h and an array
+	if want := 47; n != want || len(marked) != want {
+		t.Errorf("%d heap blocks, %d marked, want %d", n, len(marked), want)
+	}
+}
+
+func TestMultiDimOpenArrays(t *testing.T) {
+	src := `MODULE T;
+VAR
+  m: ARRAY 3, 4 OF INTEGER;
+  c: ARRAY 2, 3, 5 OF CHAR;
+  sum, rowSum, lens, corner, chars: INTEGER;
+
+PROCEDURE Sum(v: ARRAY OF ARRAY OF INTEGER): INTEGER;
+  VAR i, j, s: INTEGER;
+BEGIN s := 0;
+  FOR i := 0 TO LEN(v) - 1 DO
+    FOR j := 0 TO LEN(v, 1) - 1 DO s := s + v[i, j] END
+  END
+  RETURN s
+END Sum;
+
+PROCEDURE Row(r: ARRAY OF INTEGER): INTEGER;
+  VAR i, s: INTEGER;
+BEGIN s := 0;
+  FOR i := 0 TO LEN(r) - 1 DO s := s + r[i] END
+  RETURN s
+END Row;
+
+PROCEDURE Fill(VAR v: ARRAY OF ARRAY OF INTEGER);
+  VAR i, j: INTEGER;
+BEGIN
+  FOR i := 0 TO LEN(v) - 1 DO
+    FOR j := 0 TO LEN(v[0]) - 1 DO v[i][j] := i * 10 + j END
+  END
+END Fill;
+
+PROCEDURE Lens(VAR a: ARRAY OF ARRAY OF ARRAY OF CHAR): INTEGER;
+  VAR n: INTEGER;
+BEGIN a[1, 2, 4] := "z"; n := ORD(a[1, 2, 4]) - ORD("a")
+  RETURN LEN(a) * 100 + LEN(a, 1) * 10 + LEN(a, 2) + n * 1000
+END Lens;
+
+BEGIN
+  Fill(m);
+  sum := Sum(m);
+  rowSum := Row(m[2]);
+  corner := m[2, 3];
+  lens := Lens(c);
+  chars := ORD(c[1, 2, 4])
+END T.`
+	m, varOffset := runModule(t, src, orp.Options{})
+	tests := []struct {
+		name string
+		want int32
+	}{
+		{"sum", 0 + 1 + 2 + 3 + 10 + 11 + 12 + 13 + 20 + 21 + 22 + 23},
+		{"rowSum", 20 + 21 + 22 + 23},
+		{"corner", 23},
+		{"lens", 25000 + 235},
+		{"chars", 'z'},
+	}
+	for _, tt := range tests {
+		if got := m.global(varOffset(tt.name)); got != tt.want {
+			t.Errorf("%s = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMultiDimOpenArrayBounds(t *testing.T) {
+	src := `MODULE T;
+VAR m: ARRAY 3, 4 OF INTEGER; i: INTEGER;
+
+PROCEDURE P(VAR v: ARRAY OF ARRAY OF INTEGER; j: INTEGER);
+BEGIN v[1, j] := 1
+END P;
+
+BEGIN i := 3; P(m, i); i := 4; P(m, i)
+END T.`
+	obj, _ := compile(t, src, orp.Options{})
+	m := &machine{}
+	err := m.run(obj)
+	if err == nil || !strings.Contains(err.Error(), "trap 1") {
+		t.Errorf("got error %v, want trap 1", err)
+	}
+}
+
+func TestLocalRecordExtensions(t *testing.T) {
+	src := `MODULE T;
+TYPE
+  Base = POINTER TO BaseDesc;
+  BaseDesc = RECORD x: INTEGER END;
+VAR isA, isB, isC, notB, sum, guarded: INTEGER;
+
+PROCEDURE P;
+  TYPE
+    A = POINTER TO ADesc;
+    ADesc = RECORD (BaseDesc) y: INTEGER END;
+    B = POINTER TO BDesc;
+    BDesc = RECORD (ADesc) z: INTEGER END;
+  VAR p, q: Base; a: A; b: B; r: BDesc;
+
+  PROCEDURE Q(VAR r: BaseDesc): INTEGER;
+    VAR n: INTEGER;
+  BEGIN n := r.x;
+    IF r IS BDesc THEN n := n + r(BDesc).z END
+    RETURN n
+  END Q;
+
+BEGIN
+  NEW(a); a.x := 1; a.y := 2; p := a;
+  NEW(b); b.x := 3; b.y := 4; b.z := 5; q := b;
+  IF p IS A THEN isA := 1 END;
+  IF q IS A THEN isB := 1 END;
+  IF q IS B THEN isC := 1 END;
+  IF ~(p IS B) THEN notB := 1 END;
+  sum := p(A).y + q(B).z + q(A).y;
+  r.x := 10; r.z := 20;
+  guarded := Q(r) + Q(b^);
+  a := q(B)
+END P;
+
+BEGIN P
+END T.`
+	m, varOffset := runModule(t, src, orp.Options{})
+	tests := []struct {
+		name string
+		want int32
+	}{
+		{"isA", 1},
+		{"isB", 1},
+		{"isC", 1},
+		{"notB", 1},
+		{"sum", 2 + 5 + 4},
+		{"guarded", 10 + 20 + 3 + 5},
+	}
+	for _, tt := range tests {
+		if got := m.global(varOffset(tt.name)); got != tt.want {
+			t.Errorf("%s = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLocalRecordGuard(t *testing.T) {
+	src := `MODULE T;
+TYPE
+  Base = POINTER TO BaseDesc;
+  BaseDesc = RECORD x: INTEGER END;
+VAR y: INTEGER;
+
+PROCEDURE P;
+  TYPE
+    A = POINTER TO ADesc;
+    ADesc = RECORD (BaseDesc) y: INTEGER END;
+    B = POINTER TO BDesc;
+    BDesc = RECORD (BaseDesc) z: INTEGER END;
+  VAR p: Base; b: B;
+BEGIN NEW(b); b.z := 1; p := b; y := p(B).z; y := p(A).y
+END P;
+
+BEGIN P
+END T.`
+	obj, vars := compile(t, src, orp.Options{})
+	m := &machine{}
+	err := m.run(obj)
+	if err == nil || !strings.Contains(err.Error(), "trap 2") {
+		t.Errorf("p(A): got error %v, want trap 2", err)
+	}
+	if got := m.global(vars["y"]); got != 1 {
+		t.Errorf("p(B).z = %d, want 1", got)
+	}
+}
+
+func TestImportedPointerBase(t *testing.T) {
+	a := `MODULE A;
+TYPE
+  Rec* = RECORD x*: INTEGER END;
+  Ext* = RECORD (Rec) y*: INTEGER END;
+END A.`
+	b := `MODULE B;
+IMPORT A;
+TYPE
+  P* = POINTER TO A.Rec;
+  E* = POINTER TO A.Ext;
+VAR p*: P;
+
+PROCEDURE NewExt*(x, y: INTEGER): P;
+  VAR e: E;
+BEGIN NEW(e); e.x := x; e.y := y
+  RETURN e
+END NewExt;
+
+BEGIN NEW(p); p.x := 1
+END B.`
+	c := `MODULE C;
+IMPORT B;
+VAR q: B.P; e: B.E; x, y, isE, notE: INTEGER;
+BEGIN
+  q := B.NewExt(2, 3);
+  IF q IS B.E THEN isE := 1; e := q(B.E); y := e.y END;
+  IF ~(B.p IS B.E) THEN notE := 1 END;
+  NEW(e); e.x := 4; q := e;
+  x := B.p.x * 10 + q.x
+END C.`
+	m, varOffset := runModules(t, orp.Options{}, a, b, c)
+	tests := []struct {
+		name string
+		want int32
+	}{
+		{"x", 14},
+		{"y", 3},
+		{"isE", 1},
+		{"notE", 1},
+	}
+	for _, tt := range tests {
+		if got := m.global(varOffset(tt.name)); got != tt.want {
+			t.Errorf("%s = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMethods(t *testing.T) {
+	src := `MODULE T;
+TYPE
+  Shape = POINTER TO ShapeDesc;
+  ShapeDesc = RECORD x: INTEGER END;
+  Circle = POINTER TO CircleDesc;
+  CircleDesc = RECORD (ShapeDesc) r: INTEGER END;
+  Rec = RECORD n: INTEGER END;
+  Ext = RECORD (Rec) m: INTEGER END;
+VAR
+  s, c: Shape; circle: Circle; r: Rec; e: Ext;
+  base, dyn, super, static, varDyn, varExt: INTEGER;
+
+PROCEDURE (s: Shape) Area(): INTEGER;
+BEGIN RETURN s.x
+END Area;
+
+PROCEDURE (c: Circle) Area(): INTEGER;
+BEGIN RETURN c.Area^() * 100 + c.r
+END Area;
+
+PROCEDURE (VAR r: Rec) Value(): INTEGER;
+BEGIN RETURN r.n
+END Value;
+
+PROCEDURE (VAR e: Ext) Value(): INTEGER;
+BEGIN RETURN e.Value^() + e.m * 10
+END Value;
+
+PROCEDURE Call(VAR r: Rec): INTEGER;
+BEGIN RETURN r.Value()
+END Call;
+
+BEGIN
+  NEW(s); s.x := 2;
+  NEW(circle); circle.x := 3; circle.r := 4; c := circle;
+  base := s.Area();
+  dyn := c.Area();
+  super := circle.Area^();
+  r.n := 5; e.n := 6; e.m := 7;
+  static := r.Value() * 100 + e.Value();
+  varDyn := Call(r);
+  varExt := Call(e)
+END T.`
+	m, varOffset := runModule(t, src, orp.Options{Dialect: orp.DialectOberon2})
+	tests := []struct {
+		name string
+		want int32
+	}{
+		{"base", 2},
+		{"dyn", 304},
+		{"super", 3},
+		{"static", 500 + 76},
+		{"varDyn", 5},
+		{"varExt", 76},
+	}
+	for _, tt := range tests {
+		if got := m.global(varOffset(tt.name)); got != tt.want {
+			t.Errorf("%s = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestImportedMethods(t *testing.T) {
+	a := `MODULE A;
+TYPE
+  Shape* = POINTER TO ShapeDesc;
+  ShapeDesc* = RECORD x*: INTEGER END;
+
+PROCEDURE (s: Shape) Area*(): INTEGER;
+BEGIN RETURN s.x
+END Area;
+
+PROCEDURE (s: Shape) Twice*(): INTEGER;
+BEGIN RETURN s.Area() * 2
+END Twice;
+END A.`
+	b := `MODULE B;
+IMPORT A;
+TYPE
+  Circle = POINTER TO CircleDesc;
+  CircleDesc = RECORD (A.ShapeDesc) r: INTEGER END;
+VAR s: A.Shape; c: Circle; area, twice, super: INTEGER;
+
+PROCEDURE (c: Circle) Area*(): INTEGER;
+BEGIN RETURN c.Area^() * 100 + c.r
+END Area;
+
+BEGIN
+  NEW(c); c.x := 3; c.r := 4; s := c;
+  area := s.Area();
+  twice := s.Twice();
+  super := c.Area^()
+END B.`
+	m, varOffset := runModules(t, orp.Options{Dialect: orp.DialectOberon2}, a, b)
+	tests := []struct {
+		name string
+		want int32
+	}{
+		{"area", 304},
+		{"twice", 608},
+		{"super", 3},
+	}
+	for _, tt := range tests {
+		if got := m.global(varOffset(tt.name)); got != tt.want {
+			t.Errorf("%s = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStructuredConstants(t *testing.T) {
+	a := `MODULE A;
+TYPE Point* = RECORD x*, y*: INTEGER END;
+CONST
+  Primes* = [2, 3, 5, 7, 11];
+  Origin* = Point[1, 2];
+  Names* = ["ab", "cde"];
+END A.`
+	b := `MODULE B;
+IMPORT A;
+TYPE Pair = RECORD x, y: INTEGER END;
+CONST Local = Pair[30, 40]; Squares = [0, 1, 4, 9];
+VAR
+  p: A.Point; i, sum, prime, x, y, len, sq: INTEGER; c: CHAR;
+  name: ARRAY 8 OF CHAR;
+
+PROCEDURE Sum(a: ARRAY OF INTEGER): INTEGER;
+  VAR i, s: INTEGER;
+BEGIN s := 0;
+  FOR i := 0 TO LEN(a) - 1 DO s := s + a[i] END
+  RETURN s
+END Sum;
+
+PROCEDURE Y(p: A.Point): INTEGER;
+BEGIN RETURN p.y
+END Y;
+
+BEGIN
+  sum := Sum(A.Primes);
+  i := 3; prime := A.Primes[i] * 100 + A.Primes[4];
+  p := A.Origin; x := p.x * 100 + Local.x;
+  y := Y(A.Origin) * 100 + Local.y;
+  len := LEN(A.Names) * 10 + LEN(A.Names[0]);
+  name := A.Names[1]; c := name[2];
+  i := 2; sq := Squares[i] + Squares[3] * 10
+END B.`
+	m, varOffset := runModules(t, orp.Options{Extensions: []string{orp.ExtStructConsts}}, a, b)
+	tests := []struct {
+		name string
+		want int32
+	}{
+		{"sum", 28},
+		{"prime", 711},
+		{"x", 130},
+		{"y", 240},
+		{"len", 24},
+		{"c", 'e'},
+		{"sq", 94},
+	}
+	for _, tt := range tests {
+		if got := m.global(varOffset(tt.name)); got != tt.want {
+			t.Errorf("%s = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
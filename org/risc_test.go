@@ -0,0 +1,494 @@
+package org_test
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/fzipp/oberon-compiler/files"
+	"github.com/fzipp/oberon-compiler/orb"
+	"github.com/fzipp/oberon-compiler/org"
+	"github.com/fzipp/oberon-compiler/orp"
+	"github.com/fzipp/oberon-compiler/ors"
+)
+
+// A machine is a minimal RISC-5 emulator for running the bodies of
+// modules, loaded as by the module loader of Project Oberon.
+// Trap 0 (NEW) allocates a heap block like Kernel.New, other traps and
+// floating-point instructions end the execution with an error.
+type machine struct {
+	mem     []byte
+	r       [16]int32
+	h       int32
+	n, z, c bool
+	v       bool
+	pc      int32 // word address
+	heap    int32 // end of the allocated heap blocks
+	sb      int32 // static base of the last module
+}
+
+const (
+	memSize  = 0x10000
+	mtAdr    = 0x40   // module table; the modules are numbered from 1
+	modAdr   = 0x1000 // static base of the first module
+	heapOrg  = 0x8000
+	stackTop = memSize - 0x100
+	mt       = 12
+	sp       = 14
+	lnk      = 15
+)
+
+// compile compiles an Oberon module from source and returns its object
+// file and the offsets of its global variables from the static base.
+// Compilation errors fail the test.
+func compile(t *testing.T, src string, opts orp.Options) (*org.ObjFile, map[string]int32) {
+	t.Helper()
+	objs, vars := compileModules(t, opts, src)
+	return objs[0], vars
+}
+
+// compileModules compiles Oberon modules from source in the given order,
+// so that each module can import the modules before it. It returns their
+// object files and the offsets of the global variables of the last
+// module from its static base. Compilation errors fail the test.
+func compileModules(t *testing.T, opts orp.Options, srcs ...string) ([]*org.ObjFile, map[string]int32) {
+	t.Helper()
+	var log strings.Builder
+	fsys := files.Overlay(files.OS)
+	opts.FS = fsys
+	opts.Log = &log
+	var mod *orp.Module
+	var vars map[string]int32
+	opts.Compiled = func(m *orp.Module) {
+		mod = m
+		vars = make(map[string]int32)
+		for obj := m.Base.TopScope.Next; obj != nil; obj = obj.Next {
+			if obj.Class == orb.ClassVar {
+				vars[string(obj.Name)] = obj.Val
+			}
+		}
+	}
+	var objs []*org.ObjFile
+	for _, src := range srcs {
+		log.Reset()
+		mod = nil
+		err := orp.CompileWith(strings.NewReader(src), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mod == nil {
+			t.Fatalf("compilation failed:\n%s", log.String())
+		}
+		f, err := fsys.Open(string(mod.Name) + ".rsc")
+		if err != nil {
+			t.Fatal(err)
+		}
+		obj, err := org.ReadObjFile(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		objs = append(objs, obj)
+	}
+	return objs, vars
+}
+
+// runModule compiles a module, executes its body and returns the
+// machine with the final state of the module variables, and a function
+// that returns the offset of a global variable from the static base.
+func runModule(t *testing.T, src string, opts orp.Options) (*machine, func(name string) int32) {
+	t.Helper()
+	return runModules(t, opts, src)
+}
+
+// runModules compiles modules like compileModules, executes their bodies
+// in the given order and returns the machine and a function that returns
+// the offset of a global variable of the last module from its static
+// base, see machine.global.
+func runModules(t *testing.T, opts orp.Options, srcs ...string) (*machine, func(name string) int32) {
+	t.Helper()
+	objs, vars := compileModules(t, opts, srcs...)
+	m := &machine{}
+	err := m.run(objs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	varOffset := func(name string) int32 {
+		off, ok := vars[name]
+		if !ok {
+			t.Fatalf("no variable %s", name)
+		}
+		return off
+	}
+	return m, varOffset
+}
+
+// A loadedModule is a module placed into the memory of the machine.
+type loadedModule struct {
+	obj  *org.ObjFile
+	num  int32 // module number, index in the module table
+	data int32 // address of the data, the static base
+	code int32 // address of the code
+}
+
+// load places the data and code of modules into the memory of the
+// machine, with the static base of each module in the module table, and
+// applies the fixups of their procedure calls, data accesses and type
+// descriptors like the module loader. Every module must be preceded by
+// the modules it imports.
+func (m *machine) load(objs []*org.ObjFile) ([]*loadedModule, error) {
+	m.mem = make([]byte, memSize)
+	m.heap = heapOrg
+	byName := make(map[ors.Ident]*loadedModule)
+	var mods []*loadedModule
+	adr := int32(modAdr)
+	for i, obj := range objs {
+		mod := &loadedModule{obj: obj, num: int32(i) + 1, data: adr}
+		for _, td := range obj.TDs {
+			m.putWord(adr, td)
+			adr += 4
+		}
+		adr += obj.VarSize
+		copy(m.mem[adr:], obj.Strings)
+		adr += int32(len(obj.Strings))
+		mod.code = (adr + 3) / 4 * 4
+		for i, inst := range obj.Code {
+			m.putWord(mod.code+int32(i)*4, inst)
+		}
+		adr = mod.code + int32(len(obj.Code))*4
+		if adr >= heapOrg {
+			return nil, fmt.Errorf("modules too large")
+		}
+		m.putWord(mtAdr+mod.num*4, mod.data)
+		imps := make([]*loadedModule, len(obj.Imports))
+		for i, imp := range obj.Imports {
+			im := byName[imp.Name]
+			if im == nil {
+				return nil, fmt.Errorf("%s imports %s, which is not loaded", obj.Name, imp.Name)
+			}
+			if im.obj.Key != imp.Key {
+				return nil, fmt.Errorf("%s imports %s with bad key", obj.Name, imp.Name)
+			}
+			imps[i] = im
+		}
+		// procedure fixups: BL to an entry of an imported module
+		for fix := obj.FixOrgP; fix != 0; {
+			a := mod.code + fix*4
+			inst := m.word(a)
+			im := imps[(inst>>20)&0xF-1]
+			dest := im.code + im.obj.Entries[(inst>>12)&0xFF]
+			m.putWord(a, int32(0xF7000000|uint32((dest-a-4)/4)&0xFFFFFF))
+			fix -= inst & 0xFFF
+		}
+		// data fixups: LDR R, [MT + module number * 4], followed by the
+		// access with the entry number of an imported object
+		for fix := obj.FixOrgD; fix != 0; {
+			a := mod.code + fix*4
+			inst := m.word(a)
+			if mno := (inst >> 20) & 0xF; mno == 0 {
+				m.putWord(a, (inst>>24*16+mt)<<20+mod.num*4)
+			} else {
+				im := imps[mno-1]
+				m.putWord(a, (inst>>24*16+mt)<<20+im.num*4)
+				next := m.word(a + 4)
+				off := im.obj.Entries[next&0xFF]
+				if next>>8&1 != 0 {
+					off += im.code - im.data
+				}
+				m.putWord(a+4, next>>16<<16+off)
+			}
+			fix -= inst & 0xFFF
+		}
+		// type descriptor fixups: addresses of base type descriptors
+		for fix := obj.FixOrgT; fix != 0; {
+			a := mod.data + fix*4
+			inst := m.word(a)
+			vno := (inst >> 12) & 0xFFF
+			if mno := (inst >> 24) & 0xF; mno == 0 {
+				m.putWord(a, mod.data+vno)
+			} else {
+				im := imps[mno-1]
+				m.putWord(a, im.data+im.obj.Entries[vno])
+			}
+			fix -= inst & 0xFFF
+		}
+		byName[obj.Name] = mod
+		mods = append(mods, mod)
+	}
+	return mods, nil
+}
+
+// run loads modules and executes their bodies in the given order.
+func (m *machine) run(objs ...*org.ObjFile) error {
+	mods, err := m.load(objs)
+	if err != nil {
+		return err
+	}
+	m.r[mt] = mtAdr
+	m.r[sp] = stackTop
+	steps := 0
+	for _, mod := range mods {
+		m.sb = mod.data
+		m.r[lnk] = 0
+		m.pc = (mod.code + mod.obj.Body) / 4
+		for m.pc != 0 {
+			steps++
+			if steps > 1_000_000 {
+				return fmt.Errorf("no termination")
+			}
+			err := m.step()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// global returns the word at offset off from the static base of the last
+// module.
+func (m *machine) global(off int32) int32 {
+	return m.word(m.sb + off)
+}
+
+func (m *machine) word(adr int32) int32 {
+	return int32(binary.LittleEndian.Uint32(m.mem[adr:]))
+}
+
+func (m *machine) putWord(adr, x int32) {
+	binary.LittleEndian.PutUint32(m.mem[adr:], uint32(x))
+}
+
+func (m *machine) step() error {
+	if m.pc < 0 || m.pc*4 >= memSize {
+		return fmt.Errorf("PC out of range: %X", m.pc*4)
+	}
+	ir := m.word(m.pc * 4)
+	adr := m.pc * 4
+	m.pc++
+	p := ir>>31&1 == 1
+	q := ir>>30&1 == 1
+	u := ir>>29&1 == 1
+	v := ir>>28&1 == 1
+	a := ir >> 24 & 0xF
+	b := ir >> 20 & 0xF
+	switch {
+	case !p:
+		op := ir >> 16 & 0xF
+		var c int32
+		if q {
+			c = ir & 0xFFFF
+			if v {
+				c |= ^0xFFFF
+			}
+		} else {
+			c = m.r[ir&0xF]
+		}
+		x := m.r[b]
+		var res int32
+		switch op {
+		case 0: // MOV
+			switch {
+			case q && u:
+				res = ir << 16
+			case q:
+				res = c
+			case u && ir&1 == 0:
+				res = m.h
+			case u:
+				var flags uint32
+				if m.n {
+					flags |= 1 << 31
+				}
+				if m.z {
+					flags |= 1 << 30
+				}
+				res = int32(flags)
+			default:
+				res = c
+			}
+		case 1:
+			res = x << (c & 31)
+		case 2:
+			res = x >> (c & 31)
+		case 3:
+			res = int32(uint32(x)>>(c&31) | uint32(x)<<(32-c&31))
+		case 4:
+			res = x & c
+		case 5:
+			res = x &^ c
+		case 6:
+			res = x | c
+		case 7:
+			res = x ^ c
+		case 8:
+			res = x + c
+			m.c = uint32(res) < uint32(x)
+			m.v = (x >= 0) == (c >= 0) && (res >= 0) != (x >= 0)
+		case 9:
+			res = x - c
+			m.c = uint32(x) < uint32(c)
+			m.v = (x >= 0) != (c >= 0) && (res >= 0) != (x >= 0)
+		case 10:
+			prod := int64(x) * int64(c)
+			res, m.h = int32(prod), int32(prod>>32)
+		case 11:
+			if c <= 0 {
+				return fmt.Errorf("division by %d at %X", c, adr)
+			}
+			res, m.h = x/c, x%c
+			if m.h < 0 {
+				res--
+				m.h += c
+			}
+		default:
+			return fmt.Errorf("floating-point instruction at %X", adr)
+		}
+		m.r[a] = res
+		m.n, m.z = res < 0, res == 0
+	case !q:
+		off := ir & 0xFFFFF
+		if off&0x80000 != 0 {
+			off -= 0x100000
+		}
+		ea := m.r[b] + off
+		if ea < 0 || ea+4 > memSize {
+			return fmt.Errorf("address out of range: %X at %X", ea, adr)
+		}
+		switch {
+		case !u && v:
+			m.r[a] = int32(m.mem[ea])
+		case !u:
+			m.r[a] = m.word(ea)
+		case v:
+			m.mem[ea] = byte(m.r[a])
+		default:
+			m.putWord(ea, m.r[a])
+		}
+		if !u {
+			m.n, m.z = m.r[a] < 0, m.r[a] == 0
+		}
+	default:
+		var t bool
+		switch a & 7 {
+		case 0:
+			t = m.n
+		case 1:
+			t = m.z
+		case 2:
+			t = m.c
+		case 3:
+			t = m.v
+		case 4:
+			t = !m.c || m.z
+		case 5:
+			t = m.n != m.v
+		case 6:
+			t = m.n != m.v || m.z
+		case 7:
+			t = true
+		}
+		if a&8 != 0 {
+			t = !t
+		}
+		if !t {
+			break
+		}
+		if v && !u && ir&0xF == mt && ir>>4&0xF == 0 {
+			m.r[lnk] = m.pc * 4
+			return m.kernelNew(m.r[0], m.r[1])
+		}
+		if v && !u && ir&0xF == mt {
+			return fmt.Errorf("trap %d at %X", ir>>4&0xF, adr)
+		}
+		if v {
+			m.r[lnk] = m.pc * 4
+		}
+		if u {
+			off := ir & 0xFFFFFF
+			if off&0x800000 != 0 {
+				off -= 0x1000000
+			}
+			m.pc += off
+		} else {
+			m.pc = m.r[ir&0xF] / 4
+		}
+	}
+	return nil
+}
+
+// kernelNew allocates a heap block for the pointer at ptrAdr with the type
+// tag tag, like Kernel.New of Project Oberon: the size of the block is the
+// first word of the type descriptor, rounded to a multiple of 256 bytes
+// if it is not one of the sizes 32, 64 and 128 of the small blocks. The
+// block is cleared, its first word is the tag, and the pointer is set to
+// the word after the mark. The registers are not preserved.
+func (m *machine) kernelNew(ptrAdr, tag int32) error {
+	size := m.word(tag)
+	if size != 32 && size != 64 && size != 128 {
+		size = (size + 255) / 256 * 256
+	}
+	p := m.heap
+	if size <= 0 || p+size > stackTop-0x1000 {
+		return fmt.Errorf("bad heap block size %d", size)
+	}
+	m.heap += size
+	clear(m.mem[p : p+size])
+	m.putWord(p, tag)
+	m.putWord(ptrAdr, p+8)
+	for i := range mt {
+		m.r[i] = -0x5A5A5A5A
+	}
+	return nil
+}
+
+// mark returns the heap blocks, by pointer, that are reachable from the
+// global pointer variables at the offsets ptrs from the static base of
+// the last module. Like
+// Kernel.Mark it follows the pointers at the offsets listed in the type
+// tag of each block from offset 16, ended by -1.
+func (m *machine) mark(ptrs []int32) (map[int32]bool, error) {
+	marked := make(map[int32]bool)
+	var visit func(p int32) error
+	visit = func(p int32) error {
+		if p < heapOrg || marked[p] {
+			return nil
+		}
+		if p >= m.heap {
+			return fmt.Errorf("pointer %X outside the heap", p)
+		}
+		marked[p] = true
+		for adr := m.word(p-8) + 16; m.word(adr) != -1; adr += 4 {
+			if adr >= memSize-4 {
+				return fmt.Errorf("pointer offsets of block %X not ended", p)
+			}
+			err := visit(m.word(p + m.word(adr)))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, off := range ptrs {
+		err := visit(m.word(m.sb + off))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return marked, nil
+}
+
+// scan returns the number of heap blocks, walking the heap like
+// Kernel.Scan by the sizes in the type tags of the blocks.
+func (m *machine) scan() (int, error) {
+	n := 0
+	for p := int32(heapOrg); p != m.heap; n++ {
+		size := m.word(m.word(p))
+		if size <= 0 || p+size > m.heap {
+			return 0, fmt.Errorf("heap block %X has size %d", p, size)
+		}
+		p += size
+	}
+	return n, nil
+}
@@ -0,0 +1,67 @@
+package orp
+
+import (
+	"github.com/fzipp/oberon-compiler/orb"
+	"github.com/fzipp/oberon-compiler/org"
+	"github.com/fzipp/oberon-compiler/ors"
+)
+
+// Pointers to open arrays (ExtDynArrays)
+//
+// A pointer type POINTER TO ARRAY OF T points to an array of elements of
+// type T, which must not be an open array. NEW(p, n) allocates an array of
+// n elements, whose length is stored with them in the heap block, see
+// org.Generator.NewArray. p^ is an open array: it is indexed with a bounds
+// check against that length, LEN(p^) returns it, and it can be passed as
+// open array parameter. p[i] is short for p^[i]. A pointer to an array is
+// only compatible with pointers of the same type and NIL, and it cannot be
+// type-tested.
+
+// openArrayType parses ARRAY OF T, the base type of a pointer type.
+func (p *Parser) openArrayType() *orb.Type {
+	p.nextSym()
+	if p.sym != ors.SymOf {
+		p.ors.Mark("must point to named record or open array")
+		return p.arrayType()
+	}
+	p.nextSym()
+	typ := &orb.Type{
+		Form: orb.FormArray,
+		Len:  -1,
+		Size: 2 * org.WordSize, // as for open array parameters
+	}
+	typ.Base = p._type()
+	if (typ.Base.Form == orb.FormArray) && (typ.Base.Len < 0) {
+		p.ors.Mark("dyn array not allowed")
+	}
+	return typ
+}
+
+// newArray compiles NEW(x, y), which allocates an array of y elements for
+// the pointer x.
+func (p *Parser) newArray(x, y *org.Item) {
+	p.checkInt(y)
+	if !isArrayPointer(x.Type) {
+		p.ors.Mark("not a pointer to array")
+		return
+	}
+	if (y.Mode == orb.ClassConst) && (y.A < 0) {
+		p.ors.Mark("bad length")
+	}
+	p.org.NewArray(x, y)
+}
+
+// isArrayPointer reports whether t is a pointer type to an open array.
+func isArrayPointer(t *orb.Type) bool {
+	return t.Form == orb.FormPointer && t.Base.Form == orb.FormArray
+}
+
+// isPointerExtension reports whether the base type of the pointer type t1
+// is an extension of that of t0. A pointer to an array only extends
+// pointers to the same array type.
+func isPointerExtension(t0, t1 *orb.Type) bool {
+	if isArrayPointer(t0) || isArrayPointer(t1) {
+		return t0.Base == t1.Base
+	}
+	return isExtension(t0.Base, t1.Base)
+}
@@ -0,0 +1,87 @@
+package orp
+
+import (
+	"github.com/fzipp/oberon-compiler/orb"
+	"github.com/fzipp/oberon-compiler/ors"
+)
+
+// Definite assignment of local variables
+//
+// Local variables are not initialised, their value depends on the previous
+// contents of the stack. While the body of a procedure is parsed, the parser
+// keeps the set of local variables that may not have been assigned on some
+// path to the current position, and warns when one of them is read.
+// Statements with several branches parse each branch with a copy of the set
+// and continue with the union of the sets at the end of the branches.
+// Passing a variable as VAR parameter counts as assignment.
+//
+// Only variables of basic, pointer and procedure types are checked. Arrays
+// and records are commonly assigned element by element in loops, which the
+// analysis cannot follow.
+
+// varSet is a set of local variables.
+type varSet map[*orb.Object]bool
+
+func (s varSet) clone() varSet {
+	t := make(varSet, len(s))
+	for obj := range s {
+		t[obj] = true
+	}
+	return t
+}
+
+// union adds the variables of t to s.
+func (s varSet) union(t varSet) {
+	for obj := range t {
+		s[obj] = true
+	}
+}
+
+// beginAssign starts the analysis for the body of a procedure, obj is
+// the first object of its scope. The first nOfPar objects are parameters,
+// which are assigned by the caller.
+func (p *Parser) beginAssign(obj *orb.Object, nOfPar int32) {
+	p.unassigned = make(varSet)
+	p.unassignedRead = make(varSet)
+	i := int32(0)
+	for obj != nil {
+		if i >= nOfPar && obj.Class == orb.ClassVar && obj.Lev == p.level &&
+			obj.Type.Form != orb.FormArray && obj.Type.Form != orb.FormRecord {
+			p.unassigned[obj] = true
+		}
+		i++
+		obj = obj.Next
+	}
+}
+
+// endAssign ends the analysis for the body of a procedure.
+func (p *Parser) endAssign() {
+	p.unassigned = nil
+	p.unassignedRead = nil
+}
+
+// assign records the assignment of a variable.
+func (p *Parser) assign(obj *orb.Object) {
+	delete(p.unassigned, obj)
+}
+
+// read records the reading of a variable and reports it if the variable
+// may not be assigned. Each variable is reported only once.
+func (p *Parser) read(obj *orb.Object) {
+	if p.unassigned[obj] && !p.unassignedRead[obj] {
+		p.unassignedRead[obj] = true
+		p.ors.Warn(p.ors.Pos(), WarnUnassigned, "variable "+string(obj.Name)+" may be read before it is assigned")
+	}
+}
+
+// access records the use of a variable as designator, which is an
+// assignment if the whole variable is passed as VAR parameter.
+func (p *Parser) access(obj *orb.Object, varArg bool) {
+	whole := p.sym != ors.SymPeriod && p.sym != ors.SymLbrak &&
+		p.sym != ors.SymArrow && p.sym != ors.SymLparen
+	if varArg && whole {
+		p.assign(obj)
+	} else {
+		p.read(obj)
+	}
+}
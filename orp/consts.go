@@ -0,0 +1,267 @@
+package orp
+
+import (
+	"cmp"
+	"encoding/binary"
+	"slices"
+
+	"github.com/fzipp/oberon-compiler/orb"
+	"github.com/fzipp/oberon-compiler/org"
+	"github.com/fzipp/oberon-compiler/ors"
+)
+
+// Structured constants (ExtStructConsts)
+//
+// A constant declaration can declare a constant array or record:
+//
+//	CONST
+//	  Primes = [2, 3, 5, 7, 11];
+//	  Identity = [[1.0, 0.0], [0.0, 1.0]];
+//	  Days = ["Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"];
+//	  Origin = Point[0, 0];
+//
+// Without a type, the elements determine the type: Primes is an ARRAY 5 OF
+// INTEGER, Identity an ARRAY 2 OF ARRAY 2 OF REAL and Days an ARRAY 7 OF
+// ARRAY 4 OF CHAR, long enough for the longest string. The elements are
+// constants of the same basic type, strings, or lists of the same type.
+// A type, named by an unqualified identifier, is followed by the elements
+// of the array type or the fields of the record type, in the order of
+// their declaration, starting with those of its base types. Arrays of CHAR
+// can also be given as strings, and fields of pointer and procedure types
+// only as NIL. For constants of the types of the module, further CONST
+// sections can follow the TYPE section, see declarations.
+//
+// The value is placed with the strings of the module, see
+// org.Generator.MakeConstData, where a structured constant is a read-only
+// variable: it can be indexed, its fields can be selected, and it can be
+// assigned and passed as value parameter. Importing modules access an
+// exported structured constant through its entry, like an exported
+// variable.
+
+// structConstAhead reports whether a structured constant follows in a
+// constant declaration: a list of elements, or a type followed by one.
+func (p *Parser) structConstAhead() bool {
+	if !p.ext(ExtStructConsts) {
+		return false
+	}
+	if p.sym == ors.SymLbrak {
+		return true
+	}
+	if p.sym == ors.SymIdent {
+		if obj := p.orb.ThisObj(); obj != nil {
+			obj.Uses-- // only looked ahead, see structConst
+			return obj.Class == orb.ClassTyp
+		}
+	}
+	return false
+}
+
+// structConst parses a structured constant and returns its type and value.
+func (p *Parser) structConst() (*orb.Type, []byte) {
+	var tp *orb.Type
+	if p.sym == ors.SymIdent {
+		tp = p.qualIdent().Type
+		if (tp.Form != orb.FormArray) && (tp.Form != orb.FormRecord) {
+			p.ors.Mark("illegal type")
+			tp = nil
+		}
+		if p.sym != ors.SymLbrak {
+			p.ors.Mark("[ missing")
+			if tp == nil {
+				tp = arrayOf(p.orb.IntType, 0)
+			}
+			return tp, make([]byte, tp.Size)
+		}
+	}
+	return p.constValue(tp)
+}
+
+// enterStructConst makes obj the structured constant of type tp with the
+// value data, which is placed with the strings.
+func (p *Parser) enterStructConst(obj *orb.Object, tp *orb.Type, data []byte) {
+	obj.Type = tp
+	obj.Val = p.org.MakeConstData(data)
+	obj.Lev = 0 // global, also if declared in a procedure
+	if obj.Expo {
+		obj.ExNo = byte(p.exNo)
+		p.exNo++
+	}
+}
+
+// constValue parses a value of type t, or of the type determined by the
+// value if t is nil, and returns its type and data.
+func (p *Parser) constValue(t *orb.Type) (*orb.Type, []byte) {
+	if p.sym == ors.SymLbrak {
+		p.nextSym()
+		if t == nil {
+			return p.constList()
+		}
+		if (t.Form == orb.FormArray) && (t.Len >= 0) {
+			return t, p.constArray(t)
+		}
+		if t.Form == orb.FormRecord {
+			return t, p.constRecord(t)
+		}
+		p.ors.Mark("illegal assignment")
+		p.constList()
+		return t, make([]byte, t.Size)
+	}
+	var x org.Item
+	var str []byte
+	if p.sym == ors.SymString {
+		str = slices.Clone(p.ors.Str)
+		p.nextSym()
+	} else {
+		p.expression(&x)
+		if x.Mode != orb.ClassConst {
+			p.ors.Mark("expression not constant")
+			x.Mode = orb.ClassConst
+			x.Type = p.orb.IntType
+		} else if x.Type.Form == orb.FormString {
+			str = p.org.StringData(&x)
+		}
+	}
+	if str != nil {
+		return p.constString(t, str)
+	}
+	if t == nil {
+		t = x.Type
+		if t.Form > orb.FormSet {
+			p.ors.Mark("illegal type")
+			t = p.orb.IntType
+		}
+	}
+	data := make([]byte, t.Size)
+	if (t.Form <= orb.FormSet) && (x.Type.Form == t.Form) {
+		if t.Size == 1 {
+			data[0] = byte(x.A)
+		} else {
+			binary.LittleEndian.PutUint32(data, uint32(x.A))
+		}
+	} else if ((t.Form != orb.FormPointer) && (t.Form != orb.FormProc)) || (x.Type.Form != orb.FormNilTyp) {
+		p.ors.Mark("illegal assignment")
+	}
+	return t, data
+}
+
+// constString returns the value of the string str, with the terminating
+// 0X, as CHAR or array of CHAR t, or of its own type if t is nil.
+func (p *Parser) constString(t *orb.Type, str []byte) (*orb.Type, []byte) {
+	n := int32(len(str))
+	if t == nil {
+		if n == 2 {
+			t = p.orb.CharType
+		} else {
+			t = arrayOf(p.orb.CharType, n)
+		}
+	}
+	data := make([]byte, t.Size)
+	if (t.Form == orb.FormChar) && (n == 2) {
+		data[0] = str[0]
+	} else if (t.Form == orb.FormArray) && (t.Base.Form == orb.FormChar) && (t.Len >= 0) {
+		if n > t.Len {
+			p.ors.Mark("string too long")
+		}
+		copy(data[:t.Len], str)
+	} else {
+		p.ors.Mark("illegal assignment")
+	}
+	return t, data
+}
+
+// constList parses the elements of an array constant up to ], which
+// determine its type: an array of the type of the first element. An array
+// of CHAR from a string is extended for longer strings.
+func (p *Parser) constList() (*orb.Type, []byte) {
+	var base *orb.Type
+	var elems [][]byte
+	n := p.constElems(func(i int32) {
+		if (base != nil) && (p.sym == ors.SymString) && (base.Form == orb.FormArray) &&
+			(base.Base.Form == orb.FormChar) && (int32(len(p.ors.Str)) > base.Len) {
+			base = arrayOf(p.orb.CharType, int32(len(p.ors.Str)))
+		}
+		var data []byte
+		base, data = p.constValue(base)
+		elems = append(elems, data)
+	})
+	if n == 0 {
+		p.ors.Mark("expression expected")
+		base = p.orb.IntType
+	}
+	t := arrayOf(base, n)
+	data := make([]byte, t.Size)
+	for i, elem := range elems {
+		copy(data[int32(i)*base.Size:], elem)
+	}
+	return t, data
+}
+
+// constArray parses the elements of a constant of array type t up to ].
+func (p *Parser) constArray(t *orb.Type) []byte {
+	data := make([]byte, t.Size)
+	n := p.constElems(func(i int32) {
+		_, elem := p.constValue(t.Base)
+		if i < t.Len {
+			copy(data[i*t.Base.Size:], elem)
+		}
+	})
+	if n < t.Len {
+		p.ors.Mark("too few elements")
+	} else if n > t.Len {
+		p.ors.Mark("too many elements")
+	}
+	return data
+}
+
+// constRecord parses the fields of a constant of record type t up to ].
+func (p *Parser) constRecord(t *orb.Type) []byte {
+	var flds []*orb.Object
+	for fld := t.Dsc; fld != nil; fld = fld.Next {
+		if (fld.Class == orb.ClassFld) && (fld.Name != "") {
+			flds = append(flds, fld)
+		}
+	}
+	slices.SortFunc(flds, func(a, b *orb.Object) int { return cmp.Compare(a.Val, b.Val) })
+	data := make([]byte, t.Size)
+	n := p.constElems(func(i int32) {
+		if i < int32(len(flds)) {
+			_, val := p.constValue(flds[i].Type)
+			copy(data[flds[i].Val:], val)
+		} else {
+			p.constValue(nil)
+		}
+	})
+	if n < int32(len(flds)) {
+		p.ors.Mark("too few elements")
+	} else if n > int32(len(flds)) {
+		p.ors.Mark("too many elements")
+	}
+	return data
+}
+
+// constElems parses a list of elements up to ] with elem, which is called
+// with the index of each element, and returns their number.
+func (p *Parser) constElems(elem func(i int32)) int32 {
+	n := int32(0)
+	if p.sym != ors.SymRbrak {
+		elem(n)
+		n++
+		for p.sym == ors.SymComma {
+			p.nextSym()
+			elem(n)
+			n++
+		}
+	}
+	p.check(ors.SymRbrak, "no ]")
+	return n
+}
+
+// arrayOf returns the array type of n elements of type base.
+func arrayOf(base *orb.Type, n int32) *orb.Type {
+	return &orb.Type{
+		Form: orb.FormArray,
+		Len:  n,
+		Base: base,
+		Size: (n*base.Size + 3) / 4 * 4,
+	}
+}
@@ -0,0 +1,55 @@
+package orp
+
+import (
+	"slices"
+)
+
+// Names of the language extensions that can be enabled with
+// Options.Extensions. They are beyond Oberon-07 and off by default.
+const (
+	// Access to the variables and parameters of enclosing procedures,
+	// as in earlier versions of Oberon and in Pascal.
+	ExtOuterVars = "outer-vars"
+
+	// Pointers to open arrays, POINTER TO ARRAY OF T, whose arrays are
+	// allocated with NEW(p, n).
+	ExtDynArrays = "dyn-arrays"
+
+	// Constant arrays and records, CONST T = [1, 2, 3], which are placed
+	// with the strings of the module.
+	ExtStructConsts = "struct-consts"
+)
+
+// Extensions lists the names of all language extensions.
+var Extensions = []string{
+	ExtOuterVars,
+	ExtDynArrays,
+	ExtStructConsts,
+}
+
+// Names of the dialects that can be selected with Options.Dialect.
+const (
+	DialectOberon07 = "oberon07" // the default
+
+	// Oberon-2 type-bound procedures: procedures with a receiver, bound
+	// to a record type, called through the method table of the dynamic
+	// type of the receiver.
+	DialectOberon2 = "oberon2"
+
+	// Earlier versions of Oberon, as in Oberon V4 and System 3: LOOP and
+	// EXIT, WITH statements, RETURN anywhere in a procedure body, and the
+	// type SHORTINT with the functions LONG and SHORT.
+	DialectOberon90 = "oberon90"
+)
+
+// Dialects lists the names of all dialects.
+var Dialects = []string{
+	DialectOberon07,
+	DialectOberon2,
+	DialectOberon90,
+}
+
+// ext reports whether the language extension with the given name is enabled.
+func (p *Parser) ext(name string) bool {
+	return slices.Contains(p.exts, name)
+}
@@ -0,0 +1,79 @@
+package orp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/fzipp/oberon-compiler/ors"
+)
+
+// An Import is an entry of the import list of a module.
+type Import struct {
+	Alias ors.Ident // name under which the module is known in the importing module
+	Name  ors.Ident // name of the imported module; SYSTEM for the pseudo-module
+}
+
+// ReadImports reads the heading and the import list of the source code of
+// a module, without compiling it. The rest of the module is not read.
+func ReadImports(r io.Reader) (modId ors.Ident, imports []Import, err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = rec.(error)
+		}
+	}()
+	var errs bytes.Buffer
+	s := ors.NewScanner(r, &errs)
+	sym := s.Get()
+	if sym == ors.SymModule {
+		sym = s.Get()
+		if sym == ors.SymTimes {
+			sym = s.Get()
+		}
+		if sym == ors.SymIdent {
+			modId = s.Id
+			sym = s.Get()
+		} else {
+			s.Mark("identifier expected")
+		}
+		if sym == ors.SymSemicolon {
+			sym = s.Get()
+		} else {
+			s.Mark("no ;")
+		}
+		if sym == ors.SymImport {
+			for {
+				sym = s.Get()
+				if sym == ors.SymIdent {
+					imp := Import{Alias: s.Id, Name: s.Id}
+					sym = s.Get()
+					if sym == ors.SymBecomes {
+						sym = s.Get()
+						if sym == ors.SymIdent {
+							imp.Name = s.Id
+							sym = s.Get()
+						} else {
+							s.Mark("id expected")
+						}
+					}
+					imports = append(imports, imp)
+				} else {
+					s.Mark("id expected")
+				}
+				if sym != ors.SymComma {
+					break
+				}
+			}
+			if sym != ors.SymSemicolon {
+				s.Mark("; missing")
+			}
+		}
+	} else {
+		s.Mark("must start with MODULE")
+	}
+	if s.ErrCnt > 0 {
+		return modId, imports, errors.New(strings.TrimSpace(errs.String()))
+	}
+	return modId, imports, nil
+}
@@ -0,0 +1,143 @@
+package orp
+
+import (
+	"github.com/fzipp/oberon-compiler/orb"
+	"github.com/fzipp/oberon-compiler/org"
+	"github.com/fzipp/oberon-compiler/ors"
+)
+
+// Statements of earlier versions of Oberon (DialectOberon90)
+//
+// LOOP, EXIT and WITH are keywords, and RETURN is a statement that may
+// appear anywhere in a procedure body. The statements are compiled to the
+// code of their Oberon-07 counterparts: a LOOP statement is a WHILE TRUE
+// loop, whose EXIT statements jump behind it, a WITH statement is a type
+// CASE statement, which traps if no branch matches and it has no ELSE, and
+// a RETURN statement loads the result into R0 and jumps to the epilogue at
+// the end of the procedure. A function procedure whose end is reached
+// without a RETURN statement traps (trap 8). SHORTINT and LONGINT are INTEGER, see
+// orb.Base.EnterLegacy.
+
+// loop is a LOOP statement being parsed.
+type loop struct {
+	exits      int32  // fixups of the EXIT statements
+	unassigned varSet // variables that may not be assigned at an EXIT
+}
+
+// loopStatement parses LOOP StatementSequence END.
+func (p *Parser) loopStatement() {
+	p.nextSym()
+	outer := p.loop
+	p.loop = &loop{unassigned: make(varSet)}
+	L0 := p.org.Here()
+	p.statSequence()
+	p.org.BJump(L0)
+	p.org.FixLink(p.loop.exits)
+	p.unassigned = p.loop.unassigned // the loop ends at an EXIT
+	p.loop = outer
+	p.check(ors.SymEnd, "no END")
+}
+
+// exitStatement parses EXIT, which ends the innermost LOOP statement.
+func (p *Parser) exitStatement() {
+	p.nextSym()
+	if p.loop != nil {
+		p.org.FJump(&p.loop.exits)
+		p.loop.unassigned.union(p.unassigned)
+		p.unassigned = make(varSet) // not reached
+	} else {
+		p.ors.Mark("EXIT not within LOOP")
+	}
+}
+
+// withStatement parses a regional type guard
+//
+//	WITH v: T DO StatementSequence {"|" v: T DO StatementSequence}
+//	[ELSE StatementSequence] END
+//
+// The statements of the first branch whose type test v IS T holds are
+// executed with v of type T.
+func (p *Parser) withStatement() {
+	var x org.Item
+	p.nextSym()
+	before := p.unassigned
+	after := make(varSet)
+	L0 := int32(0)
+	for {
+		p.unassigned = before.clone()
+		p.withBranch(&x)
+		after.union(p.unassigned)
+		if p.sym != ors.SymBar {
+			break
+		}
+		p.nextSym()
+		p.org.FJump(&L0)
+		p.org.Fixup(&x)
+	}
+	p.org.FJump(&L0)
+	p.org.Fixup(&x)
+	if p.sym == ors.SymElse {
+		p.nextSym()
+		p.unassigned = before
+		p.statSequence()
+		after.union(p.unassigned)
+	} else {
+		p.org.Trap(2) // type guard
+	}
+	p.unassigned = after
+	p.org.FixLink(L0)
+	p.check(ors.SymEnd, "no END")
+}
+
+// withBranch parses v: T DO StatementSequence of a WITH statement, x is
+// the type test.
+func (p *Parser) withBranch(x *org.Item) {
+	if p.sym != ors.SymIdent {
+		p.ors.Mark("ident expected")
+		return
+	}
+	obj := p.qualIdent()
+	p.read(obj)
+	orgType := obj.Type
+	p.check(ors.SymColon, ": expected")
+	if p.sym == ors.SymIdent {
+		typObj := p.qualIdent()
+		p.org.MakeItem(x, obj, p.level)
+		if typObj.Class != orb.ClassTyp {
+			p.ors.Mark("not a type")
+		}
+		p.typeTest(x, typObj.Type, false)
+		obj.Type = typObj.Type
+		p.org.CFJump(x)
+	} else {
+		p.ors.Mark("type id expected")
+	}
+	p.check(ors.SymDo, "no DO")
+	p.statSequence()
+	obj.Type = orgType
+}
+
+// returnStatement parses a RETURN statement in a procedure body. Unless it
+// is the last statement of the body, it jumps to the end of the procedure.
+func (p *Parser) returnStatement() {
+	p.nextSym()
+	if p.level == 0 {
+		p.ors.Mark("RETURN in module body")
+		return
+	}
+	var x org.Item
+	if p.resType.Form != orb.FormNoTyp {
+		p.expression(&x)
+		if !p.compTypes(p.resType, x.Type, false) {
+			p.ors.Mark("wrong result type")
+		}
+	}
+	if p.nest == 1 && p.sym == ors.SymEnd {
+		p.org.Result(p.resType.Form, &x, nil)
+		p.retEnd = true
+	} else {
+		p.org.Result(p.resType.Form, &x, &p.retL)
+	}
+	p.returned = true
+	p.unassigned = make(varSet) // not reached
+}
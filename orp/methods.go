@@ -0,0 +1,198 @@
+package orp
+
+import (
+	"github.com/fzipp/oberon-compiler/orb"
+	"github.com/fzipp/oberon-compiler/org"
+	"github.com/fzipp/oberon-compiler/ors"
+)
+
+// Type-bound procedures (DialectOberon2)
+//
+// A procedure declared with a receiver, PROCEDURE (VAR r: T) P or
+// PROCEDURE (p: Ptr) P for Ptr = POINTER TO T, is bound to the record type
+// T, which must be declared at the module level. It is called as r.P or
+// p.P, and the procedure bound to the dynamic type of the receiver is
+// called. A procedure of the same name bound to an extension of T
+// redefines P, with the same parameters and result type. The procedure it
+// redefines is called with r.P^ or p.P^.
+//
+// Each type-bound procedure of T and its extensions has a method number,
+// its index in the method tables of the types, see org.Generator.Method.
+// A redefinition has the number of the procedure it redefines, a new
+// procedure the first number not used by T, its base types, and the
+// extensions of T declared so far.
+
+// receiver parses the receiver of a type-bound procedure and returns it as
+// parameter object, not yet in a scope, and the record type it is bound
+// to, or nil.
+func (p *Parser) receiver() (rcv *orb.Object, rec *orb.Type) {
+	p.nextSym()
+	rcv = &orb.Object{Class: orb.ClassVar, Type: p.orb.IntType}
+	if p.sym == ors.SymVar {
+		p.nextSym()
+		rcv.Class = orb.ClassPar
+	}
+	if p.sym == ors.SymIdent {
+		rcv.Name = p.ors.Id
+		rcv.Pos = p.ors.Pos()
+		p.nextSym()
+	} else {
+		p.ors.Mark("ident?")
+	}
+	p.check(ors.SymColon, "colon expected")
+	if p.sym == ors.SymIdent {
+		obj := p.qualIdent()
+		if obj.Class == orb.ClassTyp {
+			rcv.Type = obj.Type
+		} else {
+			p.ors.Mark("not a type")
+		}
+	} else {
+		p.ors.Mark("type identifier expected")
+	}
+	p.check(ors.SymRparen, "no )")
+	t := rcv.Type
+	if rcv.Class == orb.ClassPar && t.Form == orb.FormRecord {
+		rec = t
+	} else if rcv.Class == orb.ClassVar && t.Form == orb.FormPointer && t.Base.Form == orb.FormRecord {
+		rec = t.Base
+	} else {
+		p.ors.Mark("receiver must be VAR record or pointer")
+		return rcv, nil
+	}
+	if rec.Mno != 0 || rec.TypObj == nil {
+		p.ors.Mark("receiver type not declared in module")
+		return rcv, nil
+	}
+	if p.level != 0 {
+		p.ors.Mark("type-bound procedure must be global")
+		return rcv, nil
+	}
+	return rcv, rec
+}
+
+// method returns the type-bound procedure named name bound to the record
+// type rec or one of its base types, and the type it is bound to.
+func method(rec *orb.Type, name ors.Ident) (*orb.Object, *orb.Type) {
+	for ; rec != nil; rec = rec.Base {
+		for m := rec.Methods; m != nil; m = m.Next {
+			if m.Name == name {
+				return m, rec
+			}
+		}
+	}
+	return nil, nil
+}
+
+// bindMethod binds the procedure proc, whose receiver is the first
+// parameter, to the record type rec and assigns its method number.
+func (p *Parser) bindMethod(rec *orb.Type, proc *orb.Object) {
+	m := &orb.Object{
+		Class: orb.ClassMethod,
+		Name:  proc.Name,
+		Expo:  proc.Expo,
+		Type:  proc.Type,
+		Dsc:   proc,
+		Pos:   proc.Pos,
+	}
+	for fld := rec.Dsc; fld != nil; fld = fld.Next {
+		if fld.Name == m.Name {
+			p.ors.Mark("mult def")
+		}
+	}
+	for old := rec.Methods; old != nil; old = old.Next {
+		if old.Name == m.Name {
+			p.ors.Mark("mult def")
+		}
+	}
+	if old, _ := method(rec.Base, m.Name); old != nil {
+		// redefinition
+		if old.Type.Dsc.Class != proc.Type.Dsc.Class ||
+			!equalSignatures(orb.MethodSignature(old), orb.MethodSignature(m)) {
+			p.ors.Mark("redefinition does not match")
+		} else if old.Expo && !m.Expo && rec.TypObj.Expo {
+			p.ors.Mark("redefinition must be exported")
+		}
+		m.Val = old.Val
+	} else {
+		m.Val = orb.NumMethods(rec)
+		for _, obj := range p.records {
+			if t := obj.Type; t != rec && isExtension(rec, t) {
+				if ext, _ := method(t, m.Name); ext != nil {
+					p.ors.Mark("declare before redefinition")
+				}
+				m.Val = max(m.Val, orb.NumMethods(t))
+			}
+		}
+	}
+	m.Next = rec.Methods
+	rec.Methods = m
+	rec.NOfMeth = max(rec.NOfMeth, m.Val+1)
+	p.refField(rec, m, proc.Pos, true)
+}
+
+// thisMethod returns the type-bound procedure with the current identifier
+// that is selected from x, if it is not a field, and the type it is bound to.
+func (p *Parser) thisMethod(x *org.Item) (*orb.Object, *orb.Type) {
+	rec := x.Type
+	if rec.Form == orb.FormPointer {
+		rec = rec.Base
+	}
+	if p.dialect != DialectOberon2 || rec.Form != orb.FormRecord || p.orb.ThisField(rec) != nil {
+		return nil, nil
+	}
+	return method(rec, p.ors.Id)
+}
+
+// methodSelector makes x the type-bound procedure m bound to rec selected
+// from it, or for a super call x.P^, the procedure that m redefines. It
+// loads the receiver; dyn reports whether x denotes a record of its dynamic
+// type, a dereferenced pointer or a VAR parameter.
+func (p *Parser) methodSelector(x *org.Item, m *orb.Object, rec *orb.Type, dyn bool) {
+	p.refField(rec, m, p.ors.Pos(), false)
+	p.nextSym()
+	var proc *orb.Object
+	var td *orb.Type
+	if p.sym == ors.SymArrow {
+		// super call
+		p.nextSym()
+		t := x.Type
+		if t.Form == orb.FormPointer {
+			t = t.Base
+		}
+		old, owner := method(t.Base, m.Name)
+		if old != nil {
+			m = old
+			if owner.Mno > 0 {
+				td = owner
+			} else {
+				proc = old.Dsc
+			}
+		} else {
+			p.ors.Mark("no redefined procedure")
+		}
+	}
+	n := int32(1)
+	if m.Type.Dsc.Class == orb.ClassPar {
+		// record receiver
+		if x.Type.Form == orb.FormPointer {
+			p.org.DeRef(x)
+			x.Type = x.Type.Base
+			dyn = true
+		} else {
+			p.checkReadOnly(x)
+		}
+		if dyn && x.Tagged() {
+			p.org.VarParam(x, x.Type)
+		} else {
+			p.org.RecordReceiver(x, dyn)
+		}
+		n = 2
+	} else if x.Type.Form == orb.FormPointer {
+		p.org.ValueParam(x)
+	} else {
+		p.ors.Mark("receiver must be a pointer")
+	}
+	p.org.Method(x, n, m.Val, proc, td)
+	x.Type = orb.MethodSignature(m)
+}
@@ -6,10 +6,14 @@
 package orp
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"slices"
+	"strings"
 
+	"github.com/fzipp/oberon-compiler/files"
 	"github.com/fzipp/oberon-compiler/orb"
 	"github.com/fzipp/oberon-compiler/org"
 	"github.com/fzipp/oberon-compiler/ors"
@@ -31,15 +35,45 @@ type Parser struct {
 	exNo    int32
 	version int32
 	newSF   bool // option flag: new symbol file?
+	fp      bool // option flag: symbol file with fingerprints?
 	modId   ors.Ident
 	pbsList []*ptrBase
+	records []*orb.Object // record types declared in the module
+	mapFile bool          // option flag: write map file?
+	procs   []org.ProcCode
+	procId  string // name of the procedure being compiled, qualified
+	varArg  bool   // next factor is passed as VAR parameter
 	dummy   *orb.Object
 	w       io.Writer
+
+	unassigned     varSet // local variables that may not be assigned yet
+	unassignedRead varSet // local variables reported as read before assignment
+
+	sysPos  int         // position of the import of SYSTEM, 0 if not imported
+	sysUses []SystemUse // uses of SYSTEM procedures and functions
+	sysMods []ors.Ident // option: modules allowed to import SYSTEM; nil means all
+
+	exts    []string // option: enabled language extensions, see Extensions
+	dialect string   // option: language dialect, see Dialects
+
+	// statements of DialectOberon90, see legacy.go
+	loop     *loop     // innermost LOOP statement, nil outside of loops
+	nest     int       // nesting depth of statement sequences
+	resType  *orb.Type // result type of the procedure being compiled
+	retL     int32     // fixups of RETURN statements that jump to the end
+	returned bool      // procedure has a RETURN statement
+	retEnd   bool      // the last statement of the body is a RETURN statement
+
+	xref bool  // option flag: record declarations and uses of identifiers?
+	refs []ref // see xref.go
+
+	compiled func(*Module) // see Options.Compiled
 }
 
 type ptrBase struct {
 	name ors.Ident
 	typ  *orb.Type
+	pos  int
 }
 
 func NewParser(s *ors.Scanner, b *orb.Base, g *org.Generator, w io.Writer) *Parser {
@@ -67,6 +101,7 @@ func (p *Parser) check(s ors.Sym, msg string) {
 
 func (p *Parser) qualIdent() *orb.Object {
 	obj := p.orb.ThisObj()
+	p.refUse(obj, p.ors.Pos())
 	p.nextSym()
 	if obj == nil {
 		p.ors.Mark("undef")
@@ -75,7 +110,12 @@ func (p *Parser) qualIdent() *orb.Object {
 	if p.sym == ors.SymPeriod && obj.Class == orb.ClassMod {
 		p.nextSym()
 		if p.sym == ors.SymIdent {
-			obj = p.orb.ThisImport(obj)
+			mod := obj
+			obj = p.orb.ThisImport(mod)
+			if obj != nil && mod.Dsc == p.orb.System {
+				p.sysUses = append(p.sysUses, SystemUse{Pos: p.ors.Pos(), Name: obj.Name})
+			}
+			p.refImport(mod, obj, p.ors.Pos())
 			p.nextSym()
 			if obj == nil {
 				p.ors.Mark("undef")
@@ -163,7 +203,8 @@ func isExtension(t0, t1 *orb.Type) bool {
 
 func (p *Parser) typeTest(x *org.Item, t *orb.Type, guard bool) {
 	xt := x.Type
-	if (t.Form == xt.Form) && ((t.Form == orb.FormPointer) || (t.Form == orb.FormRecord && x.Mode == orb.ClassPar)) {
+	if (t.Form == xt.Form) && ((t.Form == orb.FormPointer && !isArrayPointer(t) && !isArrayPointer(xt)) ||
+		(t.Form == orb.FormRecord && x.Tagged())) {
 		for xt != t && xt != nil {
 			xt = xt.Base
 		}
@@ -176,7 +217,7 @@ func (p *Parser) typeTest(x *org.Item, t *orb.Type, guard bool) {
 				} else {
 					p.ors.Mark("not an extension")
 				}
-			} else if xt.Form == orb.FormRecord && x.Mode == orb.ClassPar {
+			} else if xt.Form == orb.FormRecord && x.Tagged() {
 				if isExtension(xt, t) {
 					p.org.TypeTest(x, t, true, guard)
 					x.Type = t
@@ -198,10 +239,16 @@ func (p *Parser) typeTest(x *org.Item, t *orb.Type, guard bool) {
 }
 
 func (p *Parser) selector(x *org.Item) {
+	dyn := x.Tagged() // of its dynamic type, for type-bound procedures
 	for p.sym == ors.SymLbrak || p.sym == ors.SymPeriod || p.sym == ors.SymArrow ||
 		(p.sym == ors.SymLparen && (x.Type.Form == orb.FormRecord || x.Type.Form == orb.FormPointer)) {
 
 		if p.sym == ors.SymLbrak {
+			if isArrayPointer(x.Type) {
+				// p[i] for p^[i]
+				p.org.DeRef(x)
+				x.Type = x.Type.Base
+			}
 			for {
 				p.nextSym()
 				var y org.Item
@@ -218,15 +265,22 @@ func (p *Parser) selector(x *org.Item) {
 				}
 			}
 			p.check(ors.SymRbrak, "no ]")
+			dyn = false
 		} else if p.sym == ors.SymPeriod {
 			p.nextSym()
 			if p.sym == ors.SymIdent {
+				if m, rec := p.thisMethod(x); m != nil {
+					p.methodSelector(x, m, rec, dyn)
+					continue
+				}
+				dyn = false
 				if x.Type.Form == orb.FormPointer {
 					p.org.DeRef(x)
 					x.Type = x.Type.Base
 				}
 				if x.Type.Form == orb.FormRecord {
 					obj := p.orb.ThisField(x.Type)
+					p.refField(x.Type, obj, p.ors.Pos(), false)
 					p.nextSym()
 					if obj != nil {
 						p.org.Field(x, obj)
@@ -245,6 +299,7 @@ func (p *Parser) selector(x *org.Item) {
 			if x.Type.Form == orb.FormPointer {
 				p.org.DeRef(x)
 				x.Type = x.Type.Base
+				dyn = true
 			} else {
 				p.ors.Mark("not a pointer")
 			}
@@ -266,6 +321,42 @@ func (p *Parser) selector(x *org.Item) {
 	}
 }
 
+// openArrayCompatible reports whether an array of type t1 can be passed as
+// open array parameter of type t0: t1 has an array dimension for each open
+// dimension of t0, and the remaining element types are the same.
+func openArrayCompatible(t0, t1 *orb.Type) bool {
+	for (t0.Form == orb.FormArray) && (t0.Len < 0) {
+		if t1.Form != orb.FormArray {
+			return false
+		}
+		t0 = t0.Base
+		t1 = t1.Base
+	}
+	return t0 == t1
+}
+
+// equalOpenArrays reports whether t0 and t1 are open array types with the
+// same number of dimensions and the same element type.
+func equalOpenArrays(t0, t1 *orb.Type) bool {
+	if (t0.Form != orb.FormArray) || (t0.Len >= 0) || (t1.Form != orb.FormArray) || (t1.Len >= 0) {
+		return false
+	}
+	for (t0.Form == orb.FormArray) && (t0.Len < 0) && (t1.Form == orb.FormArray) && (t1.Len < 0) {
+		t0 = t0.Base
+		t1 = t1.Base
+	}
+	return t0 == t1
+}
+
+// dimensions returns the number of dimensions of the array type t.
+func dimensions(t *orb.Type) (n int32) {
+	for t.Form == orb.FormArray {
+		n++
+		t = t.Base
+	}
+	return n
+}
+
 func equalSignatures(t0, t1 *orb.Type) (com bool) {
 	com = true
 	if (t0.Base == t1.Base) && (t0.NOfPar == t1.NOfPar) {
@@ -274,6 +365,7 @@ func equalSignatures(t0, t1 *orb.Type) (com bool) {
 		for p0 != nil {
 			if (p0.Class == p1.Class) && (p0.Rdo == p1.Rdo) && ((p0.Type == p1.Type) ||
 				((p0.Type.Form == orb.FormArray) && (p1.Type.Form == orb.FormArray) && (p0.Type.Len == p1.Type.Len) && (p0.Type.Base == p1.Type.Base)) ||
+				equalOpenArrays(p0.Type, p1.Type) ||
 				((p0.Type.Form == orb.FormProc) && (p1.Type.Form == orb.FormProc) && equalSignatures(p0.Type, p1.Type))) {
 
 				p0 = p0.Next
@@ -294,13 +386,14 @@ func (p *Parser) compTypes(t0, t1 *orb.Type, varPar bool) bool {
 	return (t0 == t1) || // open array assignment disallowed in ORG
 		(t0.Form == orb.FormArray) && (t1.Form == orb.FormArray) && (t0.Base == t1.Base) && (t0.Len == t1.Len) ||
 		(t0.Form == orb.FormRecord) && (t1.Form == orb.FormRecord) && isExtension(t0, t1) ||
-		!varPar && ((t0.Form == orb.FormPointer) && (t1.Form == orb.FormPointer) && isExtension(t0.Base, t1.Base) ||
+		!varPar && ((t0.Form == orb.FormPointer) && (t1.Form == orb.FormPointer) && isPointerExtension(t0, t1) ||
 			(t0.Form == orb.FormProc) && (t1.Form == orb.FormProc) && equalSignatures(t0, t1) ||
 			(t0.Form == orb.FormPointer || t0.Form == orb.FormProc) && (t1.Form == orb.FormNilTyp))
 }
 
 func (p *Parser) parameter(par *orb.Object) {
 	var x org.Item
+	p.varArg = par != nil && par.Class == orb.ClassPar && !par.Rdo
 	p.expression(&x)
 	if par != nil {
 		varPar := par.Class == orb.ClassPar
@@ -314,13 +407,11 @@ func (p *Parser) parameter(par *orb.Object) {
 				}
 				p.org.VarParam(&x, par.Type)
 			}
-		} else if (x.Type.Form == orb.FormArray) && (par.Type.Form == orb.FormArray) &&
-			(x.Type.Base == par.Type.Base) && (par.Type.Len < 0) {
-
+		} else if (par.Type.Form == orb.FormArray) && (par.Type.Len < 0) && openArrayCompatible(par.Type, x.Type) {
 			if !par.Rdo {
 				p.checkReadOnly(&x)
 			}
-			p.org.OpenArrayParam(&x)
+			p.org.OpenArrayParam(&x, par.Type)
 		} else if (x.Type.Form == orb.FormString) && varPar && par.Rdo && (par.Type.Form == orb.FormArray) &&
 			(par.Type.Base.Form == orb.FormChar) && (par.Type.Len < 0) {
 
@@ -370,6 +461,7 @@ func (p *Parser) standFunc(x *org.Item, fct int32, resTyp *orb.Type) {
 	p.check(ors.SymLparen, "no (")
 	nPar := fct % 10
 	fct = fct / 10
+	p.varArg = fct == 17 // ADR: the variable may be assigned through its address
 	p.expression(x)
 	n := int32(1)
 	for p.sym == ors.SymComma {
@@ -378,7 +470,7 @@ func (p *Parser) standFunc(x *org.Item, fct int32, resTyp *orb.Type) {
 		n++
 	}
 	p.check(ors.SymRparen, "no )")
-	if n == nPar {
+	if n == nPar || (fct == 6 && n == 2) { // LEN(a, dim)
 		switch fct {
 		case 0: // ABS
 			if (x.Type.Form == orb.FormInt) || (x.Type.Form == orb.FormReal) {
@@ -408,10 +500,18 @@ func (p *Parser) standFunc(x *org.Item, fct int32, resTyp *orb.Type) {
 			p.checkInt(x)
 			p.org.Ord(x)
 		case 6: // LEN
-			if x.Type.Form == orb.FormArray {
-				p.org.Len(x)
-			} else {
+			dim := int32(0)
+			if n == 2 {
+				p.checkConst(&y)
+				p.checkInt(&y)
+				dim = y.A
+			}
+			if x.Type.Form != orb.FormArray {
 				p.ors.Mark("not an array")
+			} else if (dim < 0) || (dim >= dimensions(x.Type)) {
+				p.ors.Mark("bad dimension")
+			} else {
+				p.org.Len(x, dim)
 			}
 		case 7, 8, 9: // LSL, ASR, ROR
 			p.checkInt(&y)
@@ -458,6 +558,12 @@ func (p *Parser) standFunc(x *org.Item, fct int32, resTyp *orb.Type) {
 			p.checkConst(x)
 			p.checkInt(x)
 			p.org.H(x)
+		case 21, 22: // LONG, SHORT, see orb.Base.EnterLegacy
+			if (x.Type.Form == orb.FormInt) || (x.Type.Form == orb.FormReal) {
+				resTyp = x.Type
+			} else {
+				p.ors.Mark("bad type")
+			}
 		}
 		x.Type = resTyp
 	} else {
@@ -502,11 +608,13 @@ func (p *Parser) set(x *org.Item) {
 }
 
 func (p *Parser) factor(x *org.Item) {
+	varArg := p.varArg
+	p.varArg = false
 	if p.sym < ors.SymChar || p.sym > ors.SymIdent {
 		p.ors.Mark("expression expected")
 		for {
 			p.nextSym()
-			if (p.sym >= ors.SymChar && p.sym <= ors.SymFor) || (p.sym >= ors.SymThen) {
+			if (p.sym >= ors.SymChar && p.sym <= ors.SymExit) || (p.sym >= ors.SymThen) {
 				break
 			}
 		}
@@ -516,6 +624,7 @@ func (p *Parser) factor(x *org.Item) {
 		if obj.Class == orb.ClassSFunc {
 			p.standFunc(x, obj.Val, obj.Type)
 		} else {
+			p.access(obj, varArg)
 			p.org.MakeItem(x, obj, p.level)
 			p.selector(x)
 			if p.sym == ors.SymLparen {
@@ -671,6 +780,7 @@ func (p *Parser) expression(x *org.Item) {
 		rel := p.sym
 		p.nextSym()
 		p.simpleExpression(&y)
+		p.checkCompare(rel, x, &y)
 		xf := x.Type.Form
 		yf := y.Type.Form
 		if x.Type == y.Type {
@@ -697,7 +807,7 @@ func (p *Parser) expression(x *org.Item) {
 			} else {
 				p.ors.Mark("only = or #")
 			}
-		} else if (xf == orb.FormPointer && yf == orb.FormPointer && (isExtension(x.Type.Base, y.Type.Base) || isExtension(y.Type.Base, x.Type.Base))) ||
+		} else if (xf == orb.FormPointer && yf == orb.FormPointer && (isPointerExtension(x.Type, y.Type) || isPointerExtension(y.Type, x.Type))) ||
 			(xf == orb.FormProc && yf == orb.FormProc && equalSignatures(x.Type, y.Type)) {
 
 			if rel <= ors.SymNeq {
@@ -743,10 +853,12 @@ func (p *Parser) standProc(pno int32) {
 	nPar := pno % 10
 	pno = pno / 10
 	var x, y, z org.Item
+	p.varArg = pno == 5 // NEW
 	p.expression(&x)
 	nap := int32(1)
 	if p.sym == ors.SymComma {
 		p.nextSym()
+		p.varArg = pno == 7 || pno == 10 // UNPK, GET
 		p.expression(&y)
 		nap = 2
 		z.Type = p.orb.NoType
@@ -759,7 +871,7 @@ func (p *Parser) standProc(pno int32) {
 		y.Type = p.orb.NoType
 	}
 	p.check(ors.SymRparen, "no )")
-	if (nPar == nap) || (pno == 0 || pno == 1) {
+	if (nPar == nap) || (pno == 0 || pno == 1) || (pno == 5 && nap == 2 && p.ext(ExtDynArrays)) {
 		switch pno {
 		case 0, 1: // INC, DEC
 			p.checkInt(&x)
@@ -778,7 +890,9 @@ func (p *Parser) standProc(pno int32) {
 			p.org.Assert(&x)
 		case 5: // NEW
 			p.checkReadOnly(&x)
-			if (x.Type.Form == orb.FormPointer) && (x.Type.Base.Form == orb.FormRecord) {
+			if nap == 2 {
+				p.newArray(&x, &y)
+			} else if (x.Type.Form == orb.FormPointer) && (x.Type.Base.Form == orb.FormRecord) {
 				p.org.New(&x)
 			} else {
 				p.ors.Mark("not a pointer to record")
@@ -825,8 +939,10 @@ func (p *Parser) standProc(pno int32) {
 
 func (p *Parser) statSequence() {
 	var x org.Item
+	p.nest++
+	defer func() { p.nest-- }()
 	for {
-		if !((p.sym >= ors.SymIdent) && (p.sym <= ors.SymFor) || (p.sym >= ors.SymSemicolon)) {
+		if !((p.sym >= ors.SymIdent) && (p.sym <= ors.SymExit) || (p.sym >= ors.SymSemicolon)) {
 			p.ors.Mark("statement expected")
 			for {
 				p.nextSym()
@@ -841,6 +957,12 @@ func (p *Parser) statSequence() {
 			if x.Mode == orb.ClassSProc {
 				p.standProc(obj.Val)
 			} else {
+				whole := p.sym == ors.SymBecomes
+				if whole {
+					obj.Uses-- // not read
+				} else {
+					p.read(obj)
+				}
 				p.selector(&x)
 				if p.sym == ors.SymBecomes {
 					// assignment
@@ -854,8 +976,8 @@ func (p *Parser) statSequence() {
 						} else {
 							p.org.StoreStruct(&x, &y)
 						}
-					} else if (x.Type.Form == orb.FormArray) && (y.Type.Form == orb.FormArray) && (x.Type.Base == y.Type.Base) && (y.Type.Len < 0) {
-						p.org.StoreStruct(&x, &y)
+					} else if (x.Type.Form == orb.FormArray) && (y.Type.Form == orb.FormArray) && (x.Type.Base == y.Type.Base) && (y.Type.Len < 0 || x.Type.Len >= 0) {
+						p.org.StoreStruct(&x, &y) // open or different length
 					} else if (x.Type.Form == orb.FormArray) && (x.Type.Base.Form == orb.FormChar) && (y.Type.Form == orb.FormString) {
 						p.org.CopyString(&x, &y)
 					} else if (x.Type.Form == orb.FormInt) && (y.Type.Form == orb.FormInt) {
@@ -866,6 +988,9 @@ func (p *Parser) statSequence() {
 					} else {
 						p.ors.Mark("illegal assignment")
 					}
+					if whole {
+						p.assign(obj)
+					}
 				} else if p.sym == ors.SymEql {
 					p.ors.Mark("should be :=")
 					p.nextSym()
@@ -903,20 +1028,30 @@ func (p *Parser) statSequence() {
 			p.nextSym()
 			p.expression(&x)
 			p.checkBool(&x)
+			p.checkCond(&x)
 			p.org.CFJump(&x)
 			p.check(ors.SymThen, "no THEN")
+			cond := p.unassigned
+			p.unassigned = cond.clone()
 			p.statSequence()
+			after := p.unassigned
 			L0 := int32(0)
 			for p.sym == ors.SymElsif {
 				p.nextSym()
 				p.org.FJump(&L0)
 				p.org.Fixup(&x)
+				p.unassigned = cond
 				p.expression(&x)
 				p.checkBool(&x)
+				p.checkCond(&x)
 				p.org.CFJump(&x)
 				p.check(ors.SymThen, "no THEN")
+				cond = p.unassigned
+				p.unassigned = cond.clone()
 				p.statSequence()
+				after.union(p.unassigned)
 			}
+			p.unassigned = cond
 			if p.sym == ors.SymElse {
 				p.nextSym()
 				p.org.FJump(&L0)
@@ -925,6 +1060,8 @@ func (p *Parser) statSequence() {
 			} else {
 				p.org.Fixup(&x)
 			}
+			after.union(p.unassigned)
+			p.unassigned = after
 			p.org.FixLink(L0)
 			p.check(ors.SymEnd, "no END")
 		} else if p.sym == ors.SymWhile {
@@ -934,18 +1071,24 @@ func (p *Parser) statSequence() {
 			p.checkBool(&x)
 			p.org.CFJump(&x)
 			p.check(ors.SymDo, "no DO")
+			cond := p.unassigned
+			p.unassigned = cond.clone()
 			p.statSequence()
 			p.org.BJump(L0)
 			for p.sym == ors.SymElsif {
 				p.nextSym()
 				p.org.Fixup(&x)
+				p.unassigned = cond
 				p.expression(&x)
 				p.checkBool(&x)
 				p.org.CFJump(&x)
 				p.check(ors.SymDo, "no DO")
+				cond = p.unassigned
+				p.unassigned = cond.clone()
 				p.statSequence()
 				p.org.BJump(L0)
 			}
+			p.unassigned = cond // the loop ends when all conditions are false
 			p.org.Fixup(&x)
 			p.check(ors.SymEnd, "no END")
 		} else if p.sym == ors.SymRepeat {
@@ -972,6 +1115,8 @@ func (p *Parser) statSequence() {
 					var y org.Item
 					p.expression(&y)
 					p.checkInt(&y)
+					p.assign(obj)
+					lowConst, low := y.Mode == orb.ClassConst, y.A
 					p.org.For0(&x, &y)
 					L0 := p.org.Here()
 					p.check(ors.SymTo, "no TO")
@@ -989,8 +1134,14 @@ func (p *Parser) statSequence() {
 						p.org.MakeConstItem(&w, p.orb.IntType, 1)
 					}
 					p.check(ors.SymDo, "no DO")
+					if lowConst && z.Mode == orb.ClassConst {
+						p.checkFor(low, z.A, w.A)
+					}
 					L1 := p.org.For1(&x, &y, &z, &w)
+					before := p.unassigned
+					p.unassigned = before.clone()
 					p.statSequence()
+					p.unassigned = before // the body may not be executed
 					p.check(ors.SymEnd, "no END")
 					p.org.For2(&x, &y, &w)
 					p.org.BJump(L0)
@@ -1030,18 +1181,26 @@ func (p *Parser) statSequence() {
 			p.nextSym()
 			if p.sym == ors.SymIdent {
 				obj := p.qualIdent()
+				p.read(obj)
 				orgType := obj.Type
 				if (orgType.Form == orb.FormPointer) || ((orgType.Form == orb.FormRecord) && (obj.Class == orb.ClassPar)) {
 					p.check(ors.SymOf, "OF expected")
+					before := p.unassigned
+					p.unassigned = before.clone()
 					typeCase(obj, &x)
+					after := p.unassigned
 					L0 := int32(0)
 					for p.sym == ors.SymBar {
 						p.nextSym()
 						p.org.FJump(&L0)
 						p.org.Fixup(&x)
 						obj.Type = orgType
+						p.unassigned = before.clone()
 						typeCase(obj, &x)
+						after.union(p.unassigned)
 					}
+					after.union(before) // no case matches
+					p.unassigned = after
 					p.org.Fixup(&x)
 					p.org.FixLink(L0)
 					obj.Type = orgType
@@ -1057,6 +1216,14 @@ func (p *Parser) statSequence() {
 				p.ors.Mark("ident expected")
 			}
 			p.check(ors.SymEnd, "no END")
+		} else if p.sym == ors.SymLoop {
+			p.loopStatement()
+		} else if p.sym == ors.SymExit {
+			p.exitStatement()
+		} else if p.sym == ors.SymWith {
+			p.withStatement()
+		} else if p.sym == ors.SymReturn && p.dialect == DialectOberon90 {
+			p.returnStatement()
 		}
 		p.org.CheckRegs()
 		if p.sym == ors.SymSemicolon {
@@ -1064,7 +1231,7 @@ func (p *Parser) statSequence() {
 		} else if p.sym < ors.SymSemicolon {
 			p.ors.Mark("missing semicolon?")
 		}
-		if p.sym > ors.SymSemicolon {
+		if p.sym > ors.SymSemicolon && (p.sym != ors.SymReturn || p.dialect != DialectOberon90) {
 			break
 		}
 	}
@@ -1075,12 +1242,17 @@ func (p *Parser) statSequence() {
 func (p *Parser) identList(class orb.Class) (first *orb.Object) {
 	if p.sym == ors.SymIdent {
 		first = p.orb.NewObj(p.ors.Id, class)
+		p.refDecl(first, p.ors.Pos())
 		p.nextSym()
 		first.Expo = p.checkExport()
 		for p.sym == ors.SymComma {
 			p.nextSym()
 			if p.sym == ors.SymIdent {
 				obj := p.orb.NewObj(p.ors.Id, class)
+				if obj.Doc == "" {
+					obj.Doc = first.Doc
+				}
+				p.refDecl(obj, p.ors.Pos())
 				p.nextSym()
 				obj.Expo = p.checkExport()
 			} else {
@@ -1142,14 +1314,15 @@ func (p *Parser) recordType() *orb.Type {
 	if p.sym == ors.SymLparen {
 		// record extension
 		p.nextSym()
-		if p.level != 0 {
-			p.ors.Mark("extension of local types not implemented")
-		}
 		if p.sym == ors.SymIdent {
 			base := p.qualIdent()
 			if base.Class == orb.ClassTyp {
 				if base.Type.Form == orb.FormRecord {
 					typ.Base = base.Type
+					if orb.NumMethods(typ.Base) > 0 && p.dialect != DialectOberon2 {
+						// no method table in the type descriptor
+						p.ors.Mark("base type has type-bound procedures")
+					}
 				} else {
 					typ.Base = p.orb.IntType
 					p.ors.Mark("invalid extension")
@@ -1183,6 +1356,7 @@ func (p *Parser) recordType() *orb.Type {
 				Class: orb.ClassFld,
 				Next:  obj,
 			}
+			p.refField(typ, obj, p.ors.Pos(), true)
 			n++
 			p.nextSym()
 			obj.Expo = p.checkExport()
@@ -1232,15 +1406,18 @@ func (p *Parser) fpSection(adr, nOfPar *int32) {
 		cl = orb.ClassVar
 	}
 	first := p.identList(cl)
-	tp := p.formalType(0)
+	tp := p.formalType()
 	rdo := false
 	if (cl == orb.ClassVar) && (tp.Form >= orb.FormArray) {
 		cl = orb.ClassPar
 		rdo = true
 	}
 	var parSize int32
-	if ((tp.Form == orb.FormArray) && (tp.Len < 0)) || (tp.Form == orb.FormRecord) {
-		// open array or record, needs second word for length or type tag
+	if (tp.Form == orb.FormArray) && (tp.Len < 0) {
+		// open array, needs a word for the length of each open dimension
+		parSize = tp.Size
+	} else if tp.Form == orb.FormRecord {
+		// record, needs second word for type tag
 		parSize = 2 * org.WordSize
 	} else {
 		parSize = org.WordSize
@@ -1296,7 +1473,7 @@ func (p *Parser) procedureType(pType *orb.Type, parBlkSize *int32) {
 	*parBlkSize = size
 }
 
-func (p *Parser) formalType(dim int) (typ *orb.Type) {
+func (p *Parser) formalType() (typ *orb.Type) {
 	if p.sym == ors.SymIdent {
 		obj := p.qualIdent()
 		if obj.Class == orb.ClassTyp {
@@ -1308,15 +1485,15 @@ func (p *Parser) formalType(dim int) (typ *orb.Type) {
 	} else if p.sym == ors.SymArray {
 		p.nextSym()
 		p.check(ors.SymOf, "OF ?")
-		if dim >= 1 {
-			p.ors.Mark("multi-dimensional open arrays not implemented")
-		}
 		typ = &orb.Type{
 			Form: orb.FormArray,
 			Len:  -1,
-			Size: 2 * org.WordSize,
+			Size: 2 * org.WordSize, // descriptor: address and length
+		}
+		typ.Base = p.formalType()
+		if (typ.Base.Form == orb.FormArray) && (typ.Base.Len < 0) {
+			typ.Size = typ.Base.Size + org.WordSize // and the lengths of the inner dimensions
 		}
-		typ.Base = p.formalType(dim + 1)
 	} else if p.sym == ors.SymProcedure {
 		p.nextSym()
 		p.orb.OpenScope()
@@ -1325,7 +1502,9 @@ func (p *Parser) formalType(dim int) (typ *orb.Type) {
 			Size: org.WordSize,
 		}
 		dmy := int32(0)
+		nRefs := len(p.refs)
 		p.procedureType(typ, &dmy)
+		p.dropRefDecls(nRefs)
 		typ.Dsc = p.orb.TopScope.Next
 		p.orb.CloseScope()
 	} else {
@@ -1335,12 +1514,6 @@ func (p *Parser) formalType(dim int) (typ *orb.Type) {
 	return typ
 }
 
-func (p *Parser) checkRecLevel(lev int32) {
-	if lev != 0 {
-		p.ors.Mark("ptr base must be global")
-	}
-}
-
 func (p *Parser) _type() *orb.Type {
 	var typ *orb.Type
 	typ = p.orb.IntType // sync
@@ -1379,30 +1552,39 @@ func (p *Parser) _type() *orb.Type {
 		}
 		if p.sym == ors.SymIdent {
 			obj := p.orb.ThisObj()
-			if obj != nil {
-				if (obj.Class == orb.ClassTyp) && (obj.Type.Form == orb.FormRecord || obj.Type.Form == orb.FormNoTyp) {
-					p.checkRecLevel(obj.Lev)
+			if obj != nil && obj.Class == orb.ClassMod {
+				// imported base type
+				obj = p.qualIdent()
+				if (obj.Class == orb.ClassTyp) && (obj.Type.Form == orb.FormRecord) {
 					typ.Base = obj.Type
-				} else if obj.Class == orb.ClassMod {
-					p.ors.Mark("external base type not implemented")
-				} else {
+				} else if obj != p.dummy {
 					p.ors.Mark("no valid base type")
 				}
 			} else {
-				p.checkRecLevel(p.level)
-				// enter into list of forward references to be fixed in `declarations`
-				p.pbsList = append(p.pbsList, &ptrBase{
-					name: p.ors.Id,
-					typ:  typ,
-				})
+				p.refUse(obj, p.ors.Pos())
+				if obj != nil {
+					if (obj.Class == orb.ClassTyp) && (obj.Type.Form == orb.FormRecord || obj.Type.Form == orb.FormNoTyp) {
+						typ.Base = obj.Type
+					} else {
+						p.ors.Mark("no valid base type")
+					}
+				} else {
+					// enter into list of forward references to be fixed in `declarations`
+					p.pbsList = append(p.pbsList, &ptrBase{
+						name: p.ors.Id,
+						typ:  typ,
+						pos:  p.ors.Pos(),
+					})
+				}
+				p.nextSym()
 			}
-			p.nextSym()
+		} else if p.sym == ors.SymArray && p.ext(ExtDynArrays) {
+			typ.Base = p.openArrayType()
 		} else {
 			typ.Base = p._type()
 			if (typ.Base.Form != orb.FormRecord) || (typ.Base.TypObj == nil) {
 				p.ors.Mark("must point to named record")
 			}
-			p.checkRecLevel(p.level)
 		}
 	} else if p.sym == ors.SymProcedure {
 		p.nextSym()
@@ -1412,7 +1594,9 @@ func (p *Parser) _type() *orb.Type {
 			Size: org.WordSize,
 		}
 		dmy := int32(0)
+		nRefs := len(p.refs)
 		p.procedureType(typ, &dmy)
+		p.dropRefDecls(nRefs)
 		typ.Dsc = p.orb.TopScope.Next
 		p.orb.CloseScope()
 	} else {
@@ -1432,73 +1616,104 @@ func (p *Parser) declarations(varSize *int32) {
 			}
 		}
 	}
-	if p.sym == ors.SymConst {
-		p.nextSym()
-		for p.sym == ors.SymIdent {
-			id := p.ors.Id
+	for {
+		if p.sym == ors.SymConst {
 			p.nextSym()
-			expo := p.checkExport()
-			if p.sym == ors.SymEql {
+			for p.sym == ors.SymIdent {
+				id := p.ors.Id
+				pos := p.ors.Pos()
+				doc := p.ors.Comment
 				p.nextSym()
-			} else {
-				p.ors.Mark("= ?")
-			}
-			var x org.Item
-			p.expression(&x)
-			if (x.Type.Form == orb.FormString) && (x.B == 2) {
-				p.org.StrToChar(&x)
-			}
-			obj := p.orb.NewObj(id, orb.ClassConst)
-			obj.Expo = expo
-			if x.Mode == orb.ClassConst {
-				obj.Val = x.A
-				obj.Lev = x.B
-				obj.Type = x.Type
-			} else {
-				p.ors.Mark("expression not constant")
-				obj.Type = p.orb.IntType
+				expo := p.checkExport()
+				if p.sym == ors.SymEql {
+					p.nextSym()
+				} else {
+					p.ors.Mark("= ?")
+				}
+				var x org.Item
+				var tp *orb.Type
+				var data []byte
+				if p.structConstAhead() {
+					tp, data = p.structConst()
+				} else {
+					p.expression(&x)
+					if (x.Type.Form == orb.FormString) && (x.B == 2) {
+						p.org.StrToChar(&x)
+					}
+				}
+				obj := p.orb.NewObj(id, orb.ClassConst)
+				obj.Pos = pos
+				p.refDecl(obj, pos)
+				obj.Doc = doc
+				obj.Expo = expo
+				if tp != nil {
+					p.enterStructConst(obj, tp, data)
+				} else if x.Mode == orb.ClassConst {
+					obj.Val = x.A
+					obj.Lev = x.B
+					obj.Type = x.Type
+				} else {
+					p.ors.Mark("expression not constant")
+					obj.Type = p.orb.IntType
+				}
+				p.check(ors.SymSemicolon, "; missing")
 			}
-			p.check(ors.SymSemicolon, "; missing")
 		}
-	}
-	if p.sym == ors.SymType {
-		p.nextSym()
-		for p.sym == ors.SymIdent {
-			id := p.ors.Id
+		if p.sym == ors.SymType {
 			p.nextSym()
-			expo := p.checkExport()
-			if p.sym == ors.SymEql {
+			for p.sym == ors.SymIdent {
+				id := p.ors.Id
+				pos := p.ors.Pos()
+				doc := p.ors.Comment
 				p.nextSym()
-			} else {
-				p.ors.Mark("=?")
-			}
-			tp := p._type()
-			obj := p.orb.NewObj(id, orb.ClassTyp)
-			obj.Type = tp
-			obj.Expo = expo
-			obj.Lev = p.level
-			if tp.TypObj == nil {
-				tp.TypObj = obj
-			}
-			if expo && (obj.Type.Form == orb.FormRecord) {
-				obj.ExNo = byte(p.exNo)
-				p.exNo++
-			} else {
-				obj.ExNo = 0
-			}
-			if tp.Form == orb.FormRecord {
-				// check whether this is base of a pointer type; search and fixup
-				for _, ptBase := range p.pbsList {
-					if obj.Name == ptBase.name {
-						ptBase.typ.Base = obj.Type
-					}
+				expo := p.checkExport()
+				if p.sym == ors.SymEql {
+					p.nextSym()
+				} else {
+					p.ors.Mark("=?")
+				}
+				tp := p._type()
+				obj := p.orb.NewObj(id, orb.ClassTyp)
+				obj.Pos = pos
+				p.refDecl(obj, pos)
+				obj.Doc = doc
+				obj.Type = tp
+				obj.Expo = expo
+				obj.Lev = p.level
+				if tp.TypObj == nil {
+					tp.TypObj = obj
 				}
-				if p.level == 0 {
-					p.org.BuildTD(tp, &p.dc) // type descriptor; len used as its address
+				if expo && (obj.Type.Form == orb.FormRecord) {
+					obj.ExNo = byte(p.exNo)
+					p.exNo++
+				} else {
+					obj.ExNo = 0
+				}
+				if tp.Form == orb.FormRecord {
+					// check whether this is base of a pointer type; search and fixup
+					for _, ptBase := range p.pbsList {
+						if obj.Name == ptBase.name {
+							ptBase.typ.Base = obj.Type
+							obj.Uses++
+							p.refUse(obj, ptBase.pos)
+						}
+					}
+					if p.level == 0 {
+						p.org.BuildTD(tp, &p.dc) // type descriptor; len used as its address
+					} else {
+						p.org.BuildLocalTD(tp)
+					}
+					if tp.TypObj == obj {
+						p.records = append(p.records, obj)
+					}
 				}
+				p.check(ors.SymSemicolon, "; missing")
 			}
-			p.check(ors.SymSemicolon, "; missing")
 		}
+		if p.sym != ors.SymConst || !p.ext(ExtStructConsts) {
+			break
+		}
+		// another CONST section, for structured constants of declared types
 	}
 	if p.sym == ors.SymVar {
 		p.nextSym()
@@ -1536,15 +1751,42 @@ func (p *Parser) declarations(varSize *int32) {
 
 func (p *Parser) procedureDecl() {
 	interrupt := false
+	doc := p.ors.Comment // preceding PROCEDURE
 	p.nextSym()
 	if p.sym == ors.SymTimes {
 		p.nextSym()
 		interrupt = true
 	}
+	var rcv *orb.Object
+	var rec *orb.Type
+	if p.sym == ors.SymLparen && p.dialect == DialectOberon2 {
+		rcv, rec = p.receiver()
+	}
 	if p.sym == ors.SymIdent {
 		procId := p.ors.Id
+		pos := p.ors.Pos()
+		if doc == "" {
+			doc = p.ors.Comment
+		}
 		p.nextSym()
-		proc := p.orb.NewObj(p.ors.Id, orb.ClassConst)
+		var proc *orb.Object
+		if rcv == nil {
+			proc = p.orb.NewObj(p.ors.Id, orb.ClassConst)
+			p.refDecl(proc, pos)
+		} else {
+			// type-bound, not in the scope
+			proc = &orb.Object{Name: procId, Class: orb.ClassConst}
+		}
+		proc.Pos = pos
+		proc.Doc = doc
+		outerId := p.procId
+		if outerId != "" {
+			p.procId = outerId + "." + string(procId)
+		} else if rec != nil {
+			p.procId = string(rec.TypObj.Name) + "." + string(procId)
+		} else {
+			p.procId = string(procId)
+		}
 		var parBlkSize int32
 		if interrupt {
 			parBlkSize = 12
@@ -1559,14 +1801,34 @@ func (p *Parser) procedureDecl() {
 		proc.Val = -1
 		proc.Lev = p.level
 		proc.Expo = p.checkExport()
-		if proc.Expo {
+		if proc.Expo && rcv == nil {
 			proc.ExNo = byte(p.exNo)
 			p.exNo++
 		}
 		p.orb.OpenScope()
 		p.level++
+		if rcv != nil {
+			obj := p.orb.NewObj(rcv.Name, rcv.Class)
+			obj.Pos = rcv.Pos
+			p.refDecl(obj, rcv.Pos)
+			obj.Type = rcv.Type
+			obj.Lev = p.level
+			obj.Val = parBlkSize
+			if rcv.Class == orb.ClassPar {
+				parBlkSize += 2 * org.WordSize // address and type tag
+			} else {
+				parBlkSize += org.WordSize
+			}
+		}
 		typ.Base = p.orb.NoType
 		p.procedureType(typ, &parBlkSize) // formal parameter list
+		if rcv != nil {
+			typ.NOfPar++
+			typ.Dsc = p.orb.TopScope.Next
+			if rec != nil {
+				p.bindMethod(rec, proc)
+			}
+		}
 		p.check(ors.SymSemicolon, "no ;")
 		locBlkSize := parBlkSize
 		p.declarations(&locBlkSize)
@@ -1586,13 +1848,32 @@ func (p *Parser) procedureDecl() {
 			proc.Val = p.org.Here() * 4
 			proc.Type.Dsc = p.orb.TopScope.Next
 		}
+		dsp := int32(0)
+		if p.org.FrameAccessed(p.level) {
+			// accessed by nested procedures, slot for the saved display entry
+			dsp = locBlkSize
+			locBlkSize += 4
+		}
 		p.org.Enter(parBlkSize, locBlkSize, interrupt)
+		if dsp != 0 {
+			p.org.EnterDisplay(p.level, dsp)
+		}
+		p.beginAssign(p.orb.TopScope.Next, typ.NOfPar)
+		p.resType, p.retL, p.returned, p.retEnd = typ.Base, 0, false, false
 		if p.sym == ors.SymBegin {
 			p.nextSym()
 			p.statSequence()
 		}
 		var x org.Item
-		if p.sym == ors.SymReturn {
+		form := typ.Base.Form
+		if p.returned {
+			// RETURN statements, the result is loaded
+			form = orb.FormNoTyp
+			if typ.Base.Form != orb.FormNoTyp && !p.retEnd {
+				p.org.Trap(8) // end of a function without RETURN
+			}
+			p.org.FixLink(p.retL)
+		} else if p.sym == ors.SymReturn {
 			p.nextSym()
 			p.expression(&x)
 			if typ.Base == p.orb.NoType {
@@ -1603,14 +1884,27 @@ func (p *Parser) procedureDecl() {
 		} else if typ.Base.Form != orb.FormNoTyp {
 			p.ors.Mark("function without result")
 			typ.Base = p.orb.NoType
-		}
-		p.org.Return(typ.Base.Form, &x, locBlkSize, interrupt)
+			form = orb.FormNoTyp
+		}
+		p.org.Return(form, &x, locBlkSize, interrupt)
+		p.endAssign()
+		p.procs = append(p.procs, org.ProcCode{
+			Name:  p.procId,
+			Entry: proc.Val,
+			End:   p.org.Here() * 4,
+			Frame: locBlkSize,
+		})
+		p.procId = outerId
+		p.checkUnused(p.orb.TopScope.Next, typ.NOfPar)
+		p.checkShadowed(p.orb.TopScope.Next)
 		p.orb.CloseScope()
 		p.level--
 		p.check(ors.SymEnd, "no END")
 		if p.sym == ors.SymIdent {
 			if p.ors.Id != procId {
 				p.ors.Mark("no match")
+			} else {
+				p.refUse(proc, p.ors.Pos())
 			}
 			p.nextSym()
 		} else {
@@ -1625,6 +1919,7 @@ func (p *Parser) importMod() {
 	var impId, impId1 ors.Ident
 	if p.sym == ors.SymIdent {
 		impId = p.ors.Id
+		pos := p.ors.Pos()
 		p.nextSym()
 		if p.sym == ors.SymBecomes {
 			p.nextSym()
@@ -1638,7 +1933,14 @@ func (p *Parser) importMod() {
 		} else {
 			impId1 = impId
 		}
+		if impId1 == "SYSTEM" {
+			p.sysPos = p.ors.Pos()
+			if p.sysMods != nil && !slices.Contains(p.sysMods, p.modId) {
+				p.ors.Mark("import of SYSTEM not allowed")
+			}
+		}
 		p.orb.Import(impId, impId1)
+		p.refModule(string(impId), pos)
 	} else {
 		p.ors.Mark("id expected")
 	}
@@ -1648,6 +1950,7 @@ func (p *Parser) module() {
 	p.log("  compiling ")
 	p.nextSym()
 	if p.sym == ors.SymModule {
+		doc := p.ors.Comment
 		p.nextSym()
 		if p.sym == ors.SymTimes {
 			p.version = 0
@@ -1668,6 +1971,15 @@ func (p *Parser) module() {
 			p.ors.Mark("identifier expected")
 		}
 		p.check(ors.SymSemicolon, "no ;")
+		if doc == "" {
+			// following the heading
+			doc = p.ors.LineComment
+			if doc != "" && p.ors.Comment != "" {
+				doc += "\n\n"
+			}
+			doc += p.ors.Comment
+		}
+		p.orb.Doc = doc
 		p.level = 0
 		p.exNo = 1
 		if p.sym == ors.SymImport {
@@ -1703,22 +2015,58 @@ func (p *Parser) module() {
 		if p.sym != ors.SymPeriod {
 			p.ors.Mark("period missing")
 		}
+		p.checkUnused(p.orb.TopScope.Next, 0)
+		if p.ors.WarnCnt > 0 && p.ors.ErrCnt == 0 {
+			p.log("\n ")
+		}
 		key := int32(0)
+		var changes []orb.Change
 		if p.ors.ErrCnt == 0 && p.version != 0 {
-			key, p.newSF = p.orb.Export(p.modId, p.newSF)
+			key, p.newSF, changes = p.orb.Export(p.modId, p.newSF, p.fp)
 			if p.newSF {
 				p.log(" new symbol file")
 			}
 		}
 		if p.ors.ErrCnt == 0 {
 			p.org.Close(p.modId, key, p.exNo)
+			if p.mapFile && p.ors.ErrCnt == 0 {
+				p.org.WriteMap(p.modId, key, p.procs)
+			}
+		}
+		if p.ors.ErrCnt == 0 {
 			p.log(fmt.Sprintf(" %d %d %X", p.org.PC, p.dc, uint32(key)))
 		} else {
 			p.log("\ncompilation FAILED")
 		}
 		p.log("\n")
+		for _, c := range changes {
+			p.log("    ", strings.ReplaceAll(c.String(), "\n", "\n    "), "\n")
+		}
+		if p.ors.ErrCnt == 0 && p.compiled != nil {
+			var refs []Ref
+			if p.xref {
+				refs = p.resolveRefs()
+			}
+			p.compiled(&Module{
+				Name:    p.modId,
+				Key:     key,
+				Base:    p.orb,
+				Gen:     p.org,
+				Records: p.records,
+
+				SystemImport: p.sysPos,
+				SystemUses:   p.sysUses,
+
+				Refs: refs,
+			})
+		}
 		p.orb.CloseScope()
 		p.pbsList = nil
+		p.records = nil
+		p.procs = nil
+		p.sysPos = 0
+		p.sysUses = nil
+		p.refs = nil
 	} else {
 		p.ors.Mark("must start with MODULE")
 	}
@@ -1728,28 +2076,126 @@ func (p *Parser) log(a ...any) {
 	_, _ = fmt.Fprint(p.w, a...)
 }
 
+// A Module is a module compiled without errors, as passed to
+// Options.Compiled. The scope of the module is still open in Base.
+type Module struct {
+	Name    ors.Ident
+	Key     int32
+	Base    *orb.Base
+	Gen     *org.Generator
+	Records []*orb.Object // record types declared in the module, in order of declaration
+
+	SystemImport int         // position of the import of SYSTEM, 0 if not imported
+	SystemUses   []SystemUse // in order of occurrence
+
+	Refs []Ref // declarations and uses of identifiers, if Options.XRef
+}
+
+// A SystemUse is the use of a procedure or function of the unsafe
+// pseudo-module SYSTEM.
+type SystemUse struct {
+	Pos  int       // source position
+	Name ors.Ident // e.g. GET
+}
+
+// Options control the compilation of a module.
+type Options struct {
+	NewSF        bool             // overwrite existing symbol file on changes
+	Fingerprints bool             // symbol file with fingerprints, see orb.Base.Export
+	FS           files.FileSystem // for source, symbol and object files; nil means files.OS
+
+	// ROM image for RISC-0 modules (MODULE*), see org.Generator.ROMFormat
+	ROMFormat string // org.ROMMem, org.ROMHex, org.ROMBin or "" for none
+	ROMSize   int32  // in words, must be positive
+
+	Map bool // write memory layout to <module>.map, see org.Generator.WriteMap
+
+	// Modules allowed to import SYSTEM; nil means all. The import of SYSTEM
+	// by other modules is an error.
+	SystemModules []ors.Ident
+
+	XRef bool // record the declarations and uses of identifiers in Module.Refs
+
+	Extensions []string // names of the enabled language extensions, see Extensions
+	Dialect    string   // language dialect, see Dialects; "" means DialectOberon07
+
+	Warnings ors.Warnings // levels of the warnings named in Warnings; nil means all off
+
+	Log      io.Writer     // for the compilation log and errors; nil means os.Stdout
+	Compiled func(*Module) // if not nil, called after a compilation without errors
+}
+
+func (opts *Options) fileSystem() files.FileSystem {
+	if opts.FS == nil {
+		return files.OS
+	}
+	return opts.FS
+}
+
 func CompileFile(path string, newSF bool) error {
-	f, err := os.Open(path)
+	return CompileFileWith(path, Options{NewSF: newSF})
+}
+
+func Compile(r io.Reader, newSF bool) error {
+	return CompileWith(r, Options{NewSF: newSF})
+}
+
+// CompileFileWith compiles the module in the source file path with the
+// given options.
+func CompileFileWith(path string, opts Options) error {
+	f, err := opts.fileSystem().Open(path)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
-	return Compile(f, newSF)
+	return CompileWith(f, opts)
 }
 
-func Compile(r io.Reader, newSF bool) (err error) {
+// CompileWith compiles the module read from r with the given options.
+func CompileWith(r io.Reader, opts Options) (err error) {
 	defer func() {
 		if rec := recover(); rec != nil {
 			err = rec.(error)
 		}
 	}()
+	if opts.Dialect != "" && !slices.Contains(Dialects, opts.Dialect) {
+		return errors.New("unknown dialect " + opts.Dialect)
+	}
+	switch opts.ROMFormat {
+	case "", org.ROMMem, org.ROMHex, org.ROMBin:
+	default:
+		return errors.New("unknown ROM image format " + opts.ROMFormat)
+	}
+	if opts.ROMFormat != "" && opts.ROMSize <= 0 {
+		return fmt.Errorf("invalid ROM size %d", opts.ROMSize)
+	}
 
-	w := os.Stdout
+	var w io.Writer = os.Stdout
+	if opts.Log != nil {
+		w = opts.Log
+	}
+	fsys := opts.fileSystem()
 	s := ors.NewScanner(r, w)
-	b := orb.NewBase(s)
-	g := org.NewGenerator(s, b)
+	b := orb.NewBase(s, fsys)
+	g := org.NewGenerator(s, b, fsys)
 	p := NewParser(s, b, g, w)
-	p.newSF = newSF
+	p.newSF = opts.NewSF
+	p.fp = opts.Fingerprints
+	p.mapFile = opts.Map
+	p.sysMods = opts.SystemModules
+	p.xref = opts.XRef
+	p.exts = opts.Extensions
+	g.OuterVars = p.ext(ExtOuterVars)
+	g.ROMFormat = opts.ROMFormat
+	g.ROMSize = opts.ROMSize
+	p.dialect = opts.Dialect
+	g.Methods = p.dialect == DialectOberon2
+	if p.dialect == DialectOberon90 {
+		s.Legacy = true
+		b.EnterLegacy()
+	}
+	s.Warnings = opts.Warnings
+	p.compiled = opts.Compiled
 	p.module()
 	return nil
 }
@@ -0,0 +1,121 @@
+package orp_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+
+	"github.com/fzipp/oberon-compiler/orb"
+	"github.com/fzipp/oberon-compiler/orp"
+)
+
+// memFS is a file system in memory.
+type memFS map[string][]byte
+
+func (fsys memFS) Open(name string) (io.ReadCloser, error) {
+	data, ok := fsys[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (fsys memFS) Create(name string) (io.WriteCloser, error) {
+	return &memFile{fsys: fsys, name: name}, nil
+}
+
+type memFile struct {
+	bytes.Buffer
+	fsys memFS
+	name string
+}
+
+func (f *memFile) Close() error {
+	f.fsys[f.name] = f.Bytes()
+	return nil
+}
+
+func TestSymFileChanges(t *testing.T) {
+	fsys := make(memFS)
+	compile := func(src string) []byte {
+		t.Helper()
+		delete(fsys, "A.rsc")
+		err := orp.CompileWith(strings.NewReader(src), orp.Options{NewSF: true, FS: fsys})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fsys["A.rsc"] == nil {
+			t.Fatalf("compilation failed:\n%s", src)
+		}
+		return fsys["A.smb"]
+	}
+	old := compile(`MODULE A;
+CONST N* = 1;
+TYPE R* = RECORD x*: INTEGER END;
+VAR v*: INTEGER;
+PROCEDURE P*(x: INTEGER); END P;
+END A.`)
+	new := compile(`MODULE A;
+CONST N* = 2;
+TYPE R* = RECORD x*, y*: INTEGER END;
+PROCEDURE P*(x: INTEGER); END P;
+PROCEDURE Q*; END Q;
+END A.`)
+	changes, err := orb.DiffSymFiles(bytes.NewReader(old), bytes.NewReader(new))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, c := range changes {
+		got = append(got, c.String())
+	}
+	want := []string{
+		"changed CONST N = 2\n    was CONST N = 1",
+		"changed TYPE R = RECORD; size 8, extension level 0, descriptor entry 1\n" +
+			"    was TYPE R = RECORD; size 4, extension level 0, descriptor entry 1",
+		"added   R.y: INTEGER; offset 4",
+		"changed PROCEDURE P(INTEGER); entry 2\n    was PROCEDURE P(INTEGER); entry 3",
+		"added   PROCEDURE Q; entry 3",
+		"removed VAR v: INTEGER; entry 2",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("changes:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestFingerprintKey(t *testing.T) {
+	fsys := make(memFS)
+	compile := func(src string, newSF bool) int32 {
+		t.Helper()
+		delete(fsys, "A.rsc")
+		opts := orp.Options{NewSF: newSF, Fingerprints: true, FS: fsys}
+		err := orp.CompileWith(strings.NewReader(src), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fsys["A.rsc"] == nil {
+			t.Fatalf("compilation failed:\n%s", src)
+		}
+		return int32(binary.LittleEndian.Uint32(fsys["A.smb"][4:]))
+	}
+	key0 := compile(`MODULE A;
+PROCEDURE P*(x: INTEGER); END P;
+END A.`, false)
+	key1 := compile(`MODULE A;
+PROCEDURE P*(x: INTEGER); END P;
+PROCEDURE Q*; END Q;
+END A.`, false)
+	if key1 != key0 {
+		t.Errorf("key after adding an export: %08X, want %08X", uint32(key1), uint32(key0))
+	}
+	key2 := compile(`MODULE A;
+PROCEDURE P*(x, z: INTEGER); END P;
+PROCEDURE Q*; END Q;
+END A.`, true)
+	if key2 == key1 {
+		t.Errorf("key after changing the signature of P: %08X, want a new key", uint32(key2))
+	}
+}
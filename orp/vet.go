@@ -0,0 +1,123 @@
+package orp
+
+import (
+	"math"
+
+	"github.com/fzipp/oberon-compiler/orb"
+	"github.com/fzipp/oberon-compiler/org"
+	"github.com/fzipp/oberon-compiler/ors"
+)
+
+// Names of the warnings for suspicious code. They are reported as errors
+// by oc vet. Assigning a local procedure to a procedure variable and
+// dividing by a constant negative divisor are compile errors.
+const (
+	WarnConstCompare = "constant-comparison"
+	WarnConstCond    = "constant-condition"
+	WarnShadowImport = "shadowed-import"
+	WarnEmptyFor     = "empty-for"
+)
+
+// VetChecks lists the names of the warnings for suspicious code.
+var VetChecks = []string{
+	WarnConstCompare,
+	WarnConstCond,
+	WarnShadowImport,
+	WarnEmptyFor,
+}
+
+// checkCompare reports the comparison x rel y of integers or characters
+// if it has the same result for all values of the operand that is not
+// constant, such as ch >= 0X or b < 0 for a BYTE b.
+func (p *Parser) checkCompare(rel ors.Sym, x, y *org.Item) {
+	if (x.Mode == orb.ClassConst) == (y.Mode == orb.ClassConst) {
+		return
+	}
+	if x.Mode == orb.ClassConst {
+		// c rel y  is  y rel' c
+		x, y = y, x
+		switch rel {
+		case ors.SymLss:
+			rel = ors.SymGtr
+		case ors.SymLeq:
+			rel = ors.SymGeq
+		case ors.SymGtr:
+			rel = ors.SymLss
+		case ors.SymGeq:
+			rel = ors.SymLeq
+		}
+	}
+	if (y.Type.Form != orb.FormInt && y.Type.Form != orb.FormChar) ||
+		(x.Type.Form != orb.FormInt && x.Type.Form != orb.FormChar) {
+		return
+	}
+	lo, hi := int64(math.MinInt32), int64(math.MaxInt32)
+	if x.Type == p.orb.CharType || x.Type == p.orb.ByteType {
+		lo, hi = 0, 255
+	}
+	c := int64(y.A)
+	holds := func(v int64) bool {
+		switch rel {
+		case ors.SymEql:
+			return v == c
+		case ors.SymNeq:
+			return v != c
+		case ors.SymLss:
+			return v < c
+		case ors.SymLeq:
+			return v <= c
+		case ors.SymGtr:
+			return v > c
+		}
+		return v >= c
+	}
+	if rel == ors.SymEql || rel == ors.SymNeq {
+		if c >= lo && c <= hi {
+			return
+		}
+	} else if holds(lo) != holds(hi) {
+		return
+	}
+	if holds(lo) {
+		p.ors.Warn(p.ors.Pos(), WarnConstCompare, "comparison is always TRUE")
+	} else {
+		p.ors.Warn(p.ors.Pos(), WarnConstCompare, "comparison is always FALSE")
+	}
+}
+
+// checkCond reports a constant condition of an IF statement.
+func (p *Parser) checkCond(x *org.Item) {
+	if x.Mode == orb.ClassConst {
+		if x.A != 0 {
+			p.ors.Warn(p.ors.Pos(), WarnConstCond, "condition is always TRUE")
+		} else {
+			p.ors.Warn(p.ors.Pos(), WarnConstCond, "condition is always FALSE")
+		}
+	}
+}
+
+// checkShadowed reports the declarations in a scope, starting with obj,
+// that hide an imported module.
+func (p *Parser) checkShadowed(obj *orb.Object) {
+	for obj != nil {
+		for s := p.orb.TopScope.Dsc; s != nil; s = s.Dsc {
+			mod := s.Next
+			for mod != nil && (mod.Class != orb.ClassMod || mod.Name != obj.Name) {
+				mod = mod.Next
+			}
+			if mod != nil && mod.Type.Form == orb.FormNoTyp {
+				p.ors.Warn(obj.Pos, WarnShadowImport, string(obj.Name)+" hides imported module "+string(mod.OrgName))
+				break
+			}
+		}
+		obj = obj.Next
+	}
+}
+
+// checkFor reports a FOR statement with the constant bounds low and high
+// whose body is never executed.
+func (p *Parser) checkFor(low, high, step int32) {
+	if step > 0 && low > high || step < 0 && low < high {
+		p.ors.Warn(p.ors.Pos(), WarnEmptyFor, "body of FOR statement is never executed")
+	}
+}
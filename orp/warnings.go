@@ -0,0 +1,67 @@
+package orp
+
+import (
+	"github.com/fzipp/oberon-compiler/orb"
+)
+
+// Names of the warnings reported by the parser.
+const (
+	WarnUnusedImport = "unused-import"
+	WarnUnusedVar    = "unused-var"
+	WarnUnusedProc   = "unused-proc"
+	WarnUnusedConst  = "unused-const"
+	WarnUnusedType   = "unused-type"
+	WarnUnreadParam  = "unread-param"
+	WarnUnassigned   = "unassigned-var"
+)
+
+// Warnings lists the names of all warnings.
+var Warnings = []string{
+	WarnUnusedImport,
+	WarnUnusedVar,
+	WarnUnusedProc,
+	WarnUnusedConst,
+	WarnUnusedType,
+	WarnUnreadParam,
+	WarnUnassigned,
+	WarnConstCompare,
+	WarnConstCond,
+	WarnShadowImport,
+	WarnEmptyFor,
+}
+
+// checkUnused reports the declarations in a scope that are never used,
+// starting with obj. The first nOfPar objects are the parameters of a
+// procedure, which are reported if they are never read. Exported objects
+// are always used.
+func (p *Parser) checkUnused(obj *orb.Object, nOfPar int32) {
+	i := int32(0)
+	for obj != nil {
+		if !obj.Expo && obj.Uses <= 0 {
+			name := string(obj.Name)
+			if i < nOfPar {
+				if obj.Class == orb.ClassVar || obj.Rdo {
+					// value parameter; VAR parameters may be results
+					p.ors.Warn(obj.Pos, WarnUnreadParam, "parameter "+name+" is never read")
+				}
+			} else if obj.Class == orb.ClassMod {
+				if obj.Type.Form == orb.FormNoTyp {
+					// declared import, not re-imported by a symbol file
+					p.ors.Warn(obj.Pos, WarnUnusedImport, "unused import "+name)
+				}
+			} else if obj.Class == orb.ClassVar {
+				p.ors.Warn(obj.Pos, WarnUnusedVar, "unused variable "+name)
+			} else if obj.Class == orb.ClassConst {
+				if obj.Type.Form == orb.FormProc {
+					p.ors.Warn(obj.Pos, WarnUnusedProc, "unused procedure "+name)
+				} else {
+					p.ors.Warn(obj.Pos, WarnUnusedConst, "unused constant "+name)
+				}
+			} else if obj.Class == orb.ClassTyp {
+				p.ors.Warn(obj.Pos, WarnUnusedType, "unused type "+name)
+			}
+		}
+		i++
+		obj = obj.Next
+	}
+}
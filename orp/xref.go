@@ -0,0 +1,230 @@
+package orp
+
+import (
+	"github.com/fzipp/oberon-compiler/orb"
+)
+
+// Cross-reference
+//
+// With Options.XRef the parser records every identifier that declares or
+// denotes an object of the module or of an imported module. Objects are
+// identified across modules by their qualified names: the module, the
+// enclosing procedures and the name, e.g. Texts.Write or Texts.Write.W.
+// Record fields are qualified by the name of the record type that declares
+// them, e.g. Texts.Writer.buf, even if they are selected from an extension.
+// The fields of an anonymous record type are qualified by the name of the
+// first variable or field of that type. The names are resolved at the end
+// of the module, when all types are named.
+
+// A Ref is the declaration or use of an object, as passed in Module.Refs.
+type Ref struct {
+	Pos  int    // source position after the identifier, as in error messages
+	Name string // qualified name of the object, e.g. Texts.Writer.buf
+	Obj  *orb.Object
+	Decl bool // declaration, otherwise use
+}
+
+// ref is a Ref before its name is resolved.
+type ref struct {
+	pos   int
+	obj   *orb.Object
+	decl  bool
+	scope string      // qualified name of the scope of a declaration
+	rec   *orb.Type   // record type a field is declared in or selected from
+	mod   *orb.Object // module of an imported object
+}
+
+// refDecl records the declaration of obj in the current scope.
+func (p *Parser) refDecl(obj *orb.Object, pos int) {
+	if !p.xref {
+		return
+	}
+	scope := string(p.modId)
+	if p.procId != "" {
+		scope += "." + p.procId
+	}
+	p.refs = append(p.refs, ref{pos: pos, obj: obj, decl: true, scope: scope})
+}
+
+// refUse records the use of obj, unless it is undefined. Predeclared
+// objects are dropped when the names are resolved.
+func (p *Parser) refUse(obj *orb.Object, pos int) {
+	if p.xref && obj != nil && obj != p.dummy {
+		p.refs = append(p.refs, ref{pos: pos, obj: obj})
+	}
+}
+
+// refImport records the use of obj imported from mod.
+func (p *Parser) refImport(mod, obj *orb.Object, pos int) {
+	if p.xref && obj != nil {
+		p.refs = append(p.refs, ref{pos: pos, obj: obj, mod: mod})
+	}
+}
+
+// refField records the declaration of the field fld of the record type
+// rec, or its use in a selector of a variable of type rec.
+func (p *Parser) refField(rec *orb.Type, fld *orb.Object, pos int, decl bool) {
+	if p.xref && fld != nil {
+		p.refs = append(p.refs, ref{pos: pos, obj: fld, decl: decl, rec: rec})
+	}
+}
+
+// refModule records the import of the module named name as a use of it.
+// Modules are named by their original names, not by their aliases.
+func (p *Parser) refModule(name string, pos int) {
+	if !p.xref {
+		return
+	}
+	for obj := p.orb.TopScope.Next; obj != nil; obj = obj.Next {
+		if obj.Class == orb.ClassMod && string(obj.Name) == name {
+			p.refUse(obj, pos)
+		}
+	}
+}
+
+// dropRefDecls drops the declarations recorded since the first n refs,
+// such as the parameters of a procedure type, which are not in a scope
+// that can be named.
+func (p *Parser) dropRefDecls(n int) {
+	if !p.xref {
+		return
+	}
+	refs := p.refs[:n]
+	for _, r := range p.refs[n:] {
+		if !r.decl {
+			refs = append(refs, r)
+		}
+	}
+	p.refs = refs
+}
+
+// resolveRefs returns the recorded refs with their names.
+func (p *Parser) resolveRefs() []Ref {
+	names := make(map[*orb.Object]string)
+	owners := make(map[*orb.Type]string) // names of anonymous record types
+	for _, r := range p.refs {
+		if r.decl && r.rec == nil {
+			names[r.obj] = r.scope + "." + string(r.obj.Name)
+		}
+	}
+	for _, r := range p.refs {
+		if r.decl && r.rec == nil {
+			addOwner(owners, r.obj.Type, names[r.obj])
+		}
+	}
+	for mod := p.orb.TopScope.Next; mod != nil; mod = mod.Next {
+		if mod.Class == orb.ClassMod {
+			for obj := mod.Dsc; obj != nil; obj = obj.Next {
+				name := string(mod.OrgName) + "." + string(obj.Name)
+				addOwner(owners, obj.Type, name)
+				if obj.Class == orb.ClassTyp && obj.Type.TypObj == obj {
+					addFieldOwners(owners, obj.Type, name)
+				}
+			}
+		}
+	}
+	recName := func(t *orb.Type) string {
+		if t.TypObj == nil || t.TypObj.Type != t {
+			return owners[t]
+		}
+		if t.Mno > 0 {
+			return p.moduleName(t.Mno) + "." + string(t.TypObj.Name)
+		}
+		if name, ok := names[t.TypObj]; ok {
+			return name
+		}
+		return string(t.TypObj.Name)
+	}
+	for _, r := range p.refs {
+		if r.decl && r.rec != nil {
+			names[r.obj] = recName(r.rec) + "." + string(r.obj.Name)
+			addOwner(owners, r.obj.Type, names[r.obj])
+		}
+	}
+
+	var refs []Ref
+	for _, r := range p.refs {
+		name, ok := names[r.obj]
+		if !ok {
+			if r.obj.Class == orb.ClassMod {
+				name = string(r.obj.OrgName)
+			} else if r.mod != nil {
+				name = string(r.mod.OrgName) + "." + string(r.obj.Name)
+			} else if r.rec != nil {
+				name = recName(declaringRecord(r.rec, r.obj)) + "." + string(r.obj.Name)
+			} else {
+				continue // predeclared
+			}
+		}
+		refs = append(refs, Ref{Pos: r.pos, Name: name, Obj: r.obj, Decl: r.decl})
+	}
+	return refs
+}
+
+// moduleName returns the name of the imported module with number mno.
+func (p *Parser) moduleName(mno int32) string {
+	for mod := p.orb.TopScope.Next; mod != nil; mod = mod.Next {
+		if mod.Class == orb.ClassMod && mod.Lev == mno {
+			return string(mod.OrgName)
+		}
+	}
+	return ""
+}
+
+// anonRecord returns the anonymous record type of t or of the elements
+// of the array type t, or nil.
+func anonRecord(t *orb.Type) *orb.Type {
+	for t != nil && t.Form == orb.FormArray {
+		t = t.Base
+	}
+	if t == nil || t.Form != orb.FormRecord || (t.TypObj != nil && t.TypObj.Type == t) {
+		return nil
+	}
+	return t
+}
+
+// addOwner names the anonymous record type of t after the object name,
+// unless it is already named, and its fields that are anonymous records
+// in turn.
+func addOwner(owners map[*orb.Type]string, t *orb.Type, name string) {
+	rec := anonRecord(t)
+	if rec == nil || owners[rec] != "" {
+		return
+	}
+	owners[rec] = name
+	addFieldOwners(owners, rec, name)
+}
+
+// addFieldOwners names the anonymous record types of the fields of the
+// record type rec named name.
+func addFieldOwners(owners map[*orb.Type]string, rec *orb.Type, name string) {
+	if rec.Form != orb.FormRecord {
+		return
+	}
+	var bot *orb.Object
+	if rec.Base != nil {
+		bot = rec.Base.Dsc
+	}
+	for fld := rec.Dsc; fld != bot && fld != nil; fld = fld.Next {
+		if fld.Name != "" {
+			addOwner(owners, fld.Type, name+"."+string(fld.Name))
+		}
+	}
+}
+
+// declaringRecord returns the record type among t and its base types that
+// declares the field fld.
+func declaringRecord(t *orb.Type, fld *orb.Object) *orb.Type {
+	for rec := t; rec != nil; rec = rec.Base {
+		var bot *orb.Object
+		if rec.Base != nil {
+			bot = rec.Base.Dsc
+		}
+		for obj := rec.Dsc; obj != bot && obj != nil; obj = obj.Next {
+			if obj == fld {
+				return rec
+			}
+		}
+	}
+	return t
+}
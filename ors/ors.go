@@ -10,16 +10,20 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"strings"
+
+	"github.com/fzipp/oberon-compiler/files"
 )
 
 const (
 	IdLen         = 32
 	maxExp        = 38
 	stringBufSize = 256
+	textTag       = 0xF1 // first byte of a file in Oberon Text format
 )
 
-// Scanner does lexical analysis. Input is Oberon-Text, output is
-// sequence of symbols, i.e. identifiers, numbers, strings, and special symbols.
+// Scanner does lexical analysis. Input is Oberon-Text or plain ASCII text,
+// output is sequence of symbols, i.e. identifiers, numbers, strings, and special symbols.
 // Recognises all Oberon keywords and skips comments. The keywords are
 // recorded in a table (map).
 // Get delivers next symbol from input text with Reader r.
@@ -34,25 +38,107 @@ type Scanner struct {
 	Str    []byte
 	ErrCnt int
 
+	// Comments preceding the symbol delivered by Get, separated by empty
+	// lines: those starting on a line of their own in Comment, those
+	// following the previous symbol on its line in LineComment.
+	Comment     string
+	LineComment string
+
+	Warnings Warnings // levels of the warnings reported by Warn
+	WarnCnt  int
+
+	// Legacy makes LOOP, EXIT and WITH keywords, as in earlier versions
+	// of Oberon.
+	Legacy bool
+
 	ch     byte // last character read
+	start  int  // position of the symbol delivered by Get
 	eot    bool
 	errPos int
 	pos    int
+	text   []byte // characters of the comment being read, if recording
+	record bool
 	r      io.ByteReader
 	w      io.Writer
 }
 
 func NewScanner(r io.Reader, w io.Writer) *Scanner {
 	return &Scanner{
-		r: bufio.NewReader(r),
+		r: textReader(bufio.NewReader(r)),
 		w: w,
 	}
 }
 
+// ReadText returns the text of a source file as read by the scanner, so
+// that source positions are offsets into the text.
+func ReadText(r io.Reader) (text []byte, err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = rec.(error)
+		}
+	}()
+	tr := textReader(bufio.NewReader(r))
+	for {
+		ch, err := tr.ReadByte()
+		if err == io.EOF {
+			return text, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		text = append(text, ch)
+	}
+}
+
+// textReader returns a reader for the text part of r. If r is a file in
+// Oberon Text format, the header with the font and colour runs is skipped,
+// so that positions are counted from the beginning of the text as in the
+// Oberon editor. Otherwise r is read as plain ASCII text. Lines may end
+// with CR (as in Oberon texts), LF or CR LF; all of them are blanks.
+func textReader(r *bufio.Reader) io.ByteReader {
+	tag, err := r.Peek(1)
+	if err != nil || tag[0] != textTag {
+		return r
+	}
+	_ = files.ReadByte(r)
+	off := files.ReadInt(r) // file position of the text
+	pos := int32(5)
+	n := byte(1) // next font number
+	fno := files.ReadByte(r)
+	pos++
+	for fno != 0 {
+		if fno == n {
+			fontName := files.ReadString(r)
+			pos += int32(len(fontName)) + 1
+			n++
+		}
+		_ = files.ReadByte(r) // colour
+		_ = files.ReadByte(r) // vertical offset
+		_ = files.ReadInt(r)  // length of run
+		fno = files.ReadByte(r)
+		pos += 7
+	}
+	length := files.ReadInt(r)
+	pos += 4
+	if off > pos {
+		_, err = r.Discard(int(off - pos))
+		if err != nil {
+			panic(err)
+		}
+	}
+	return bufio.NewReader(io.LimitReader(r, int64(length)))
+}
+
 func (s *Scanner) Pos() int {
 	return s.pos - 1
 }
 
+// Start returns the position of the first character of the symbol
+// delivered by Get, whose end is at Pos.
+func (s *Scanner) Start() int {
+	return s.start
+}
+
 func (s *Scanner) Mark(msg string) {
 	p := s.Pos()
 	if p > s.errPos && s.ErrCnt < 25 {
@@ -65,7 +151,44 @@ func (s *Scanner) Mark(msg string) {
 	s.errPos = p + 4
 }
 
+// WarnLevel determines how a warning is reported.
+type WarnLevel int
+
+const (
+	WarnOff   WarnLevel = iota // not reported
+	WarnOn                     // reported as warning
+	WarnError                  // reported as error
+)
+
+// Warnings maps the names of warnings to their levels. Warnings that are
+// not in the map are off.
+type Warnings map[string]WarnLevel
+
+// Warn reports a warning at position pos, according to the level of the
+// warning with the given name. Warnings are reported with their name,
+// and count as errors if they have level WarnError.
+func (s *Scanner) Warn(pos int, name, msg string) {
+	var format string
+	switch s.Warnings[name] {
+	case WarnOn:
+		format = "\n  pos %d warning: %s [%s]"
+		s.WarnCnt++
+	case WarnError:
+		format = "\n  pos %d %s [%s]"
+		s.ErrCnt++
+	default:
+		return
+	}
+	_, err := fmt.Fprintf(s.w, format, pos, msg, name)
+	if err != nil {
+		panic(err)
+	}
+}
+
 func (s *Scanner) nextCh() {
+	if s.record {
+		s.text = append(s.text, s.ch)
+	}
 	var err error
 	s.ch, err = s.r.ReadByte()
 	s.pos++
@@ -93,6 +216,8 @@ func (s *Scanner) identifier() (sym Sym) {
 	// lookup keyword
 	if kwSym, ok := keyTab[s.Id]; ok {
 		sym = kwSym
+	} else if kwSym, ok := legacyKeyTab[s.Id]; ok && s.Legacy {
+		sym = kwSym
 	} else {
 		sym = SymIdent
 	}
@@ -320,11 +445,36 @@ func (s *Scanner) comment() {
 	}
 }
 
+// addComment adds the text of a comment to the comments c. The recorded
+// text starts with the '*' of the opening bracket.
+func addComment(c *string, recorded []byte) {
+	text := strings.Trim(string(bytes.TrimSuffix(recorded, []byte(")"))), "*")
+	text = strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\r", "\n")
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	text = strings.TrimSpace(strings.Join(lines, "\n"))
+	if text == "" {
+		return
+	}
+	if *c != "" {
+		*c += "\n\n"
+	}
+	*c += text
+}
+
 func (s *Scanner) Get() (sym Sym) {
+	s.Comment, s.LineComment = "", ""
+	newLine := s.pos == 0
 	for sym == symNull {
 		for !s.eot && s.ch <= ' ' {
+			if s.ch == '\r' || s.ch == '\n' {
+				newLine = true
+			}
 			s.nextCh()
 		}
+		s.start = s.pos - 1
 		if s.eot {
 			sym = SymEot
 		} else if s.ch < 'A' {
@@ -346,7 +496,15 @@ func (s *Scanner) Get() (sym Sym) {
 					s.nextCh()
 					if s.ch == '*' {
 						sym = symNull
+						s.text = s.text[:0]
+						s.record = true
 						s.comment()
+						s.record = false
+						if newLine {
+							addComment(&s.Comment, s.text)
+						} else {
+							addComment(&s.LineComment, s.text)
+						}
 					} else {
 						sym = SymLparen
 					}
@@ -495,6 +653,9 @@ const (
 	SymRepeat
 	SymCase
 	SymFor
+	SymLoop
+	SymWith
+	SymExit
 	SymComma
 	SymColon
 	SymBecomes
@@ -564,3 +725,11 @@ var keyTab = map[Ident]Sym{
 	"VAR":       SymVar,
 	"WHILE":     SymWhile,
 }
+
+// legacyKeyTab holds the keywords of earlier versions of Oberon, see
+// Scanner.Legacy.
+var legacyKeyTab = map[Ident]Sym{
+	"EXIT": SymExit,
+	"LOOP": SymLoop,
+	"WITH": SymWith,
+}
@@ -0,0 +1,101 @@
+package ors_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/fzipp/oberon-compiler/ors"
+)
+
+// oberonText returns text in Oberon Text format, with a run for each font
+// and trailing bytes after the text, as written by the Oberon editor.
+func oberonText(text string, fonts ...string) []byte {
+	var b bytes.Buffer
+	b.WriteByte(0xF1)
+	b.Write(make([]byte, 4)) // position of the text, set below
+	for i, font := range fonts {
+		b.WriteByte(byte(i + 1))
+		b.WriteString(font)
+		b.WriteByte(0)
+		b.WriteByte(0) // colour
+		b.WriteByte(0) // vertical offset
+		binary.Write(&b, binary.LittleEndian, int32(len(text)/len(fonts)))
+	}
+	b.WriteByte(0)
+	binary.Write(&b, binary.LittleEndian, int32(len(text)))
+	data := b.Bytes()
+	binary.LittleEndian.PutUint32(data[1:], uint32(len(data)))
+	data = append(data, text...)
+	return append(data, "\x00\x01garbage"...)
+}
+
+type symbol struct {
+	sym ors.Sym
+	id  string
+	pos int
+}
+
+func scan(t *testing.T, src []byte) []symbol {
+	t.Helper()
+	s := ors.NewScanner(bytes.NewReader(src), io.Discard)
+	var syms []symbol
+	for {
+		sym := s.Get()
+		if sym == ors.SymEot {
+			break
+		}
+		if len(syms) > 100 {
+			t.Fatal("no end of text")
+		}
+		syms = append(syms, symbol{sym, string(s.Id), s.Pos()})
+	}
+	if s.ErrCnt > 0 {
+		t.Errorf("%d errors", s.ErrCnt)
+	}
+	return syms
+}
+
+func TestOberonText(t *testing.T) {
+	text := "MODULE M;\rVAR x: INTEGER;\r(* comment *)\rEND M."
+	want := scan(t, []byte(text))
+	if len(want) != 11 || want[0].sym != ors.SymModule || want[1].id != "M" {
+		t.Fatalf("plain text: %v", want)
+	}
+	tests := []struct {
+		name  string
+		fonts []string
+	}{
+		{"one font", []string{"Oberon10.Scn.Fnt"}},
+		{"two fonts", []string{"Oberon10.Scn.Fnt", "Oberon10b.Scn.Fnt"}},
+	}
+	for _, tt := range tests {
+		got := scan(t, oberonText(text, tt.fonts...))
+		if len(got) != len(want) {
+			t.Errorf("%s: %d symbols, want %d", tt.name, len(got), len(want))
+			continue
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				t.Errorf("%s: symbol %d = %v, want %v", tt.name, i, got[i], want[i])
+			}
+		}
+	}
+}
+
+func TestLineEnds(t *testing.T) {
+	want := scan(t, []byte("MODULE M;\rEND M."))
+	for _, text := range []string{"MODULE M;\nEND M.", "MODULE M;\r\nEND M."} {
+		got := scan(t, []byte(text))
+		if len(got) != len(want) {
+			t.Errorf("%q: %d symbols, want %d", text, len(got), len(want))
+			continue
+		}
+		for i := range got {
+			if got[i].sym != want[i].sym || got[i].id != want[i].id {
+				t.Errorf("%q: symbol %d = %v, want %v", text, i, got[i], want[i])
+			}
+		}
+	}
+}